@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// apiContextKey API 请求上下文的键
+type apiContextKey int
+
+const (
+	unixSocketConnKey apiContextKey = iota // 连接来自 Unix Socket
+	apiScopeKey                            // 请求通过认证后的权限范围
+)
+
+// requireScope 包装处理函数，要求请求具有指定的权限范围
+// 未配置 tokens 或请求来自 Unix Socket (由文件权限控制访问) 时视为 admin；
+// 否则从 Authorization: Bearer <token> 中查找令牌，未认证返回 401，权限不足返回 403
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		granted, name, ok := authenticateAPIRequest(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="iplog_checker"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			logrus.Warnf("API request %s %s from %s rejected: missing or invalid token", r.Method, r.URL.Path, r.RemoteAddr)
+			return
+		}
+		if scope == APIScopeAdmin && granted != APIScopeAdmin {
+			http.Error(w, "Forbidden: admin scope required", http.StatusForbidden)
+			logrus.Warnf("API request %s %s from %s rejected: token %s has scope %s", r.Method, r.URL.Path, r.RemoteAddr, name, granted)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), apiScopeKey, granted)))
+	}
+}
+
+// authenticateAPIRequest 返回请求的权限范围与令牌名称，ok 为 false 表示未通过认证
+func authenticateAPIRequest(r *http.Request) (scope, name string, ok bool) {
+	if fromSocket, _ := r.Context().Value(unixSocketConnKey).(bool); fromSocket {
+		return APIScopeAdmin, "unix socket", true
+	}
+
+	configMutex.RLock()
+	tokens := config.APIServer.Tokens
+	configMutex.RUnlock()
+	if len(tokens) == 0 {
+		return APIScopeAdmin, "", true
+	}
+
+	auth := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	presented := []byte(strings.TrimSpace(auth[len(prefix):]))
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare(presented, []byte(t.Token)) == 1 {
+			return t.Scope, t.Name, true
+		}
+	}
+	return "", "", false
+}
+
+// requestScope 返回已通过认证的请求的权限范围
+func requestScope(r *http.Request) string {
+	scope, _ := r.Context().Value(apiScopeKey).(string)
+	return scope
+}
+
+// apiTLSConfig 根据配置创建 TLS 配置，配置了 client_ca 时要求并校验客户端证书 (mTLS)
+func apiTLSConfig(api APIServer) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(api.TLSCert, api.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if api.ClientCA != "" {
+		pem, err := os.ReadFile(api.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", api.ClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// listenUnixSocket 在 Unix Socket 上监听 (删除遗留的 socket 文件并设置文件权限)
+func listenUnixSocket(path, mode string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	perm, _ := strconv.ParseUint(mode, 8, 32)
+	if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to chmod socket: %v", err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// apiTestRequests 覆盖所有路由的请求及其要求的权限范围
+var apiTestRequests = []struct {
+	method, path, scope string
+}{
+	{http.MethodGet, "/notify", APIScopeAdmin},
+	{http.MethodGet, "/status", APIScopeRead},
+	{http.MethodGet, "/export", APIScopeRead},
+	{http.MethodGet, "/check?ip=192.0.2.1", APIScopeRead},
+	{http.MethodGet, "/metrics", APIScopeRead},
+	{http.MethodGet, "/lists", APIScopeRead},
+	{http.MethodPost, "/lists/risk_list/blocked/entries", APIScopeAdmin},
+	{http.MethodDelete, "/lists/risk_list/blocked/entries", APIScopeAdmin},
+}
+
+// newAPIAuthTestServer 使用真实的路由表与权限范围，处理函数替换为返回请求权限范围的桩
+func newAPIAuthTestServer(t *testing.T, tokens []APIToken) *httptest.Server {
+	t.Helper()
+	setTestConfig(t, Config{APIServer: APIServer{Tokens: tokens}})
+	routes := make([]apiRoute, len(apiRoutes))
+	for i, route := range apiRoutes {
+		routes[i] = route
+		routes[i].Handler = func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, requestScope(r))
+		}
+	}
+	srv := httptest.NewServer(newAPIMux(routes))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// doAPIRequest 发送请求，返回状态码与响应内容
+func doAPIRequest(t *testing.T, srv *httptest.Server, method, path, authorization string) (int, string, http.Header) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), resp.Header
+}
+
+func TestRequireScopeWithTokens(t *testing.T) {
+	if len(apiTestRequests) != len(apiRoutes) {
+		t.Fatalf("apiTestRequests covers %d of %d routes", len(apiTestRequests), len(apiRoutes))
+	}
+	srv := newAPIAuthTestServer(t, []APIToken{
+		{Name: "grafana", Token: "read-secret", Scope: APIScopeRead},
+		{Name: "ops", Token: "admin-secret", Scope: APIScopeAdmin},
+	})
+
+	for _, rq := range apiTestRequests {
+		// read 令牌只能访问只读接口
+		status, body, _ := doAPIRequest(t, srv, rq.method, rq.path, "Bearer read-secret")
+		if rq.scope == APIScopeAdmin {
+			if status != http.StatusForbidden {
+				t.Errorf("%s %s with read token: status %d, want 403", rq.method, rq.path, status)
+			}
+		} else if status != http.StatusOK || body != APIScopeRead {
+			t.Errorf("%s %s with read token: status %d, scope %q", rq.method, rq.path, status, body)
+		}
+
+		// admin 令牌可以访问全部接口
+		status, body, _ = doAPIRequest(t, srv, rq.method, rq.path, "Bearer admin-secret")
+		if status != http.StatusOK || body != APIScopeAdmin {
+			t.Errorf("%s %s with admin token: status %d, scope %q", rq.method, rq.path, status, body)
+		}
+
+		// 缺少或格式错误的令牌返回 401
+		for _, auth := range []string{
+			"",
+			"Bearer",
+			"Bearer ",
+			"Bearer wrong-secret",
+			"Bearer admin-secret-suffix",
+			"Basic YWRtaW46c2VjcmV0",
+			"admin-secret",
+			"Token admin-secret",
+		} {
+			status, _, header := doAPIRequest(t, srv, rq.method, rq.path, auth)
+			if status != http.StatusUnauthorized || !strings.HasPrefix(header.Get("WWW-Authenticate"), "Bearer") {
+				t.Errorf("%s %s with Authorization %q: status %d, WWW-Authenticate %q", rq.method, rq.path, auth, status, header.Get("WWW-Authenticate"))
+			}
+		}
+	}
+
+	// Bearer 前缀不区分大小写，令牌前后的空白被忽略
+	for _, auth := range []string{"bearer admin-secret", "BEARER admin-secret", "Bearer  admin-secret "} {
+		if status, body, _ := doAPIRequest(t, srv, http.MethodGet, "/notify", auth); status != http.StatusOK || body != APIScopeAdmin {
+			t.Errorf("Authorization %q: status %d, scope %q", auth, status, body)
+		}
+	}
+}
+
+func TestRequireScopeWithoutTokens(t *testing.T) {
+	srv := newAPIAuthTestServer(t, nil)
+	for _, rq := range apiTestRequests {
+		for _, auth := range []string{"", "Bearer anything"} {
+			status, body, _ := doAPIRequest(t, srv, rq.method, rq.path, auth)
+			if status != http.StatusOK || body != APIScopeAdmin {
+				t.Errorf("%s %s without configured tokens (Authorization %q): status %d, scope %q", rq.method, rq.path, auth, status, body)
+			}
+		}
+	}
+}
+
+// TestRequireScopeUnixSocket 来自 Unix Socket 的请求不需要令牌
+func TestRequireScopeUnixSocket(t *testing.T) {
+	setTestConfig(t, Config{APIServer: APIServer{Tokens: []APIToken{{Name: "ops", Token: "admin-secret", Scope: APIScopeAdmin}}}})
+	handler := requireScope(APIScopeAdmin, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, requestScope(r))
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/notify", nil)
+	w := httptest.NewRecorder()
+	handler(w, r.WithContext(context.WithValue(r.Context(), unixSocketConnKey, true)))
+	if w.Code != http.StatusOK || w.Body.String() != APIScopeAdmin {
+		t.Fatalf("unix socket request: status %d, scope %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/notify", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("TCP request without token: status %d, want 401", w.Code)
+	}
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -31,29 +35,91 @@ type NotifyResponse struct {
 
 // StatusResponse /status 端点的响应结构
 type StatusResponse struct {
-	SafeListCount     int            `json:"safe_list_count"`    // 安全列表条目总数
-	RiskListCount     int            `json:"risk_list_count"`    // 风险列表条目总数
-	RiskListStatus    map[string]int `json:"risk_list_status"`   // 每个风险列表的条目数
-	NotificationsSent uint64         `json:"notifications_sent"` // 已发送通知总数
-	ConfigInJSON      interface{}    `json:"config_in_json"`     // 当前配置的 JSON 对象
+	SafeListCount     int             `json:"safe_list_count"`    // 安全列表条目总数
+	RiskListCount     int             `json:"risk_list_count"`    // 风险列表条目总数
+	RiskListStatus    map[string]int  `json:"risk_list_status"`   // 每个风险列表的条目数
+	NotificationsSent uint64          `json:"notifications_sent"` // 已发送通知总数
+	Services          []ServiceHealth `json:"services"`           // 各通知项的健康状态
+	RuntimeLists      []RuntimeList   `json:"runtime_lists"`      // 通过 API 管理的运行时列表
+	Lists             []ListState     `json:"lists"`              // file/url 列表的更新时间与新鲜度
+	ConfigInJSON      interface{}     `json:"config_in_json"`     // 当前配置的 JSON 对象 (仅 admin 权限返回)
 }
 
-// StartAPIServer 启动 API 服务器
-func StartAPIServer(addr string) error {
+// apiRoute API 路由及其要求的权限范围
+type apiRoute struct {
+	Pattern string
+	Scope   string
+	Handler http.HandlerFunc
+}
+
+// apiRoutes API 路由表 (read: 只读接口, admin: 会触发外部动作或修改列表的接口)
+var apiRoutes = []apiRoute{
+	{"/notify", APIScopeAdmin, handleNotify},
+	{"/status", APIScopeRead, handleStatus},
+	{"/export", APIScopeRead, handleExport},
+	{"/check", APIScopeRead, handleCheck},
+	{"/metrics", APIScopeRead, handleMetrics},
+	{"GET /lists", APIScopeRead, handleListRuntimeLists},
+	{"POST /lists/{type}/{name}/entries", APIScopeAdmin, handleAddRuntimeEntries},
+	{"DELETE /lists/{type}/{name}/entries", APIScopeAdmin, handleRemoveRuntimeEntries},
+}
+
+// newAPIMux 注册路由，每个处理函数都经过权限检查
+func newAPIMux(routes []apiRoute) *http.ServeMux {
 	mux := http.NewServeMux()
+	for _, route := range routes {
+		mux.HandleFunc(route.Pattern, requireScope(route.Scope, route.Handler))
+	}
+	return mux
+}
 
-	// 注册路由
-	mux.HandleFunc("/notify", handleNotify)
-	mux.HandleFunc("/status", handleStatus)
+// StartAPIServer 启动 API 服务器
+// 在 addr 上监听 HTTP (配置了 tls_cert/tls_key 时为 HTTPS)，配置了 socket 时同时在 Unix Socket 上监听
+func StartAPIServer(api APIServer) error {
+	mux := newAPIMux(apiRoutes)
 
 	server := &http.Server{
-		Addr:         addr,
+		Addr:         api.Addr,
 		Handler:      mux,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
 
-	logrus.Infof("Starting API server on %s", addr)
+	if api.Socket != "" {
+		listener, err := listenUnixSocket(api.Socket, api.SocketMode)
+		if err != nil {
+			return fmt.Errorf("failed to listen on unix socket %s: %v", api.Socket, err)
+		}
+		socketServer := &http.Server{
+			Handler:      mux,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			// 标记来自 Unix Socket 的连接 (访问由 socket 文件权限控制, 不需要 token)
+			ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+				return context.WithValue(ctx, unixSocketConnKey, true)
+			},
+		}
+		go func() {
+			logrus.Infof("Starting API server on unix socket %s", api.Socket)
+			if err := socketServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+				logrus.Errorf("API unix socket server error: %v", err)
+			}
+		}()
+	}
+
+	if len(api.Tokens) == 0 {
+		logrus.Warnf("API server has no tokens configured, all endpoints are unauthenticated")
+	}
+	if api.TLSCert != "" {
+		tlsConfig, err := apiTLSConfig(api)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = tlsConfig
+		logrus.Infof("Starting API server on %s (TLS, client certificate required: %t)", api.Addr, api.ClientCA != "")
+		return server.ListenAndServeTLS("", "")
+	}
+	logrus.Infof("Starting API server on %s", api.Addr)
 	return server.ListenAndServe()
 }
 
@@ -120,9 +186,12 @@ func handleNotify(w http.ResponseWriter, r *http.Request) {
 		// 构造测试消息
 		testMessage := fmt.Sprintf("Test notification from iplog_checker at %s", time.Now().Format("2006-01-02 15:04:05"))
 		testTitle := "Test Notification"
+		// 测试数据使用文档保留地址 (RFC 5737)
+		now := time.Now()
+		testData := TemplateData{IP: "192.0.2.1", Count: 1, Timestamp: now.Unix(), Time: now.Format("2006-01-02 15:04:05")}
 
 		// 发送测试通知
-		err := sendNotification(svc, testMessage, testTitle)
+		err := sendNotification(svc, testMessage, testTitle, "", testData)
 
 		if err != nil {
 			responses = append(responses, NotifyResponse{
@@ -164,10 +233,13 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 		riskListCount, riskListStatus = RiskListData.Stats()
 	}
 
-	// 获取当前配置
-	configMutex.RLock()
-	configCopy := config
-	configMutex.RUnlock()
+	// 获取当前配置 (包含通知服务的 token 等敏感信息，仅 admin 权限返回)
+	var configCopy interface{}
+	if requestScope(r) == APIScopeAdmin {
+		configMutex.RLock()
+		configCopy = config
+		configMutex.RUnlock()
+	}
 
 	// 构造响应
 	response := StatusResponse{
@@ -175,6 +247,9 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 		RiskListCount:     riskListCount,
 		RiskListStatus:    riskListStatus,
 		NotificationsSent: GetNotificationsSent(),
+		Services:          NotifierHealth(),
+		RuntimeLists:      RuntimeListsSnapshot(),
+		Lists:             ListStatesSnapshot(),
 		ConfigInJSON:      configCopy,
 	}
 
@@ -184,3 +259,175 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
+
+// handleExport 处理 /export 端点
+// 导出所选风险列表的并集减去安全列表 (合并重叠与相邻的 CIDR)
+// 参数: list (可重复或逗号分隔, 默认所有风险列表), format (text/nftables/ipset/json, 默认 text), table, set, set6
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := ExportOptions{
+		Lists:  splitListNames(query["list"]),
+		Format: query.Get("format"),
+		Table:  query.Get("table"),
+		Set:    query.Get("set"),
+		Set6:   query.Get("set6"),
+	}
+	if err := opts.normalize(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if RiskListData == nil {
+		http.Error(w, "IP lists are not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	result, err := ExportRiskList(RiskListData, SafeListData, opts.Lists)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if opts.Format == ExportFormatJSON {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	if err := WriteExport(w, result, opts); err != nil {
+		logrus.Errorf("Failed to write export response: %v", err)
+	}
+}
+
+// CheckRequest /check 端点批量查询的请求结构
+type CheckRequest struct {
+	IPs []string `json:"ips"` // 待查询的 IP 列表
+}
+
+// handleCheck 处理 /check 端点
+// GET /check?ip=1.2.3.4 查询单个 IP (ip 参数可重复, 多个 IP 时返回数组)；
+// POST /check 批量查询, 请求体为 {"ips": [...]} 或每行一个 IP 的纯文本, 返回数组
+func handleCheck(w http.ResponseWriter, r *http.Request) {
+	var ips []string
+	switch r.Method {
+	case http.MethodGet:
+		ips = r.URL.Query()["ip"]
+		if len(ips) == 0 {
+			http.Error(w, "Missing ip parameter", http.StatusBadRequest)
+			return
+		}
+		if len(ips) == 1 {
+			writeJSON(w, CheckIP(ips[0]))
+			return
+		}
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if strings.HasPrefix(strings.TrimSpace(string(body)), "{") {
+			var req CheckRequest
+			if err := json.Unmarshal(body, &req); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid JSON body: %v", err), http.StatusBadRequest)
+				return
+			}
+			ips = req.IPs
+		} else {
+			ips = splitListNames(strings.Fields(string(body)))
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(ips) > maxBulkCheck {
+		http.Error(w, fmt.Sprintf("Too many IPs: %d (max %d)", len(ips), maxBulkCheck), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	results := make([]CheckResult, 0, len(ips))
+	for _, ip := range ips {
+		results = append(results, CheckIP(ip))
+	}
+	writeJSON(w, results)
+}
+
+// writeJSON 以 JSON 格式返回响应
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Errorf("Failed to encode response: %v", err)
+	}
+}
+
+// RuntimeEntriesRequest /lists/{type}/{name}/entries 的请求结构
+type RuntimeEntriesRequest struct {
+	Entries []string `json:"entries"` // IP 或 CIDR 列表
+	Entry   string   `json:"entry"`   // 单个 IP 或 CIDR (与 entries 合并)
+	Comment string   `json:"comment"` // 备注 (仅添加)
+	TTL     string   `json:"ttl"`     // 过期时长, 如 24h、7d (仅添加, 为空表示永不过期)
+	Level   int      `json:"level"`   // 风险等级 (仅添加 risk 列表, 新列表默认 1)
+}
+
+// readRuntimeEntriesRequest 解析请求体 (可为空) 与 entry 查询参数
+func readRuntimeEntriesRequest(r *http.Request) (RuntimeEntriesRequest, error) {
+	var req RuntimeEntriesRequest
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return req, fmt.Errorf("failed to read request body: %v", err)
+	}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return req, fmt.Errorf("invalid JSON body: %v", err)
+		}
+	}
+	if req.Entry != "" {
+		req.Entries = append(req.Entries, req.Entry)
+	}
+	req.Entries = append(req.Entries, r.URL.Query()["entry"]...)
+	return req, nil
+}
+
+// handleListRuntimeLists 处理 GET /lists, 返回所有运行时列表
+func handleListRuntimeLists(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, RuntimeListsSnapshot())
+}
+
+// handleAddRuntimeEntries 处理 POST /lists/{type}/{name}/entries, 添加条目 (列表不存在时创建)
+func handleAddRuntimeEntries(w http.ResponseWriter, r *http.Request) {
+	req, err := readRuntimeEntriesRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ttl, err := ParseDuration(req.TTL)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid ttl: %v", err), http.StatusBadRequest)
+		return
+	}
+	list, err := AddRuntimeEntries(r.PathValue("type"), r.PathValue("name"), req.Entries, req.Comment, ttl, req.Level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, list)
+}
+
+// handleRemoveRuntimeEntries 处理 DELETE /lists/{type}/{name}/entries, 删除条目
+// 未指定条目时需要 ?all=true 才会删除整个列表
+func handleRemoveRuntimeEntries(w http.ResponseWriter, r *http.Request) {
+	req, err := readRuntimeEntriesRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Entries) == 0 && r.URL.Query().Get("all") != "true" {
+		http.Error(w, "no entries given (use ?all=true to remove the whole list)", http.StatusBadRequest)
+		return
+	}
+	removed, err := RemoveRuntimeEntries(r.PathValue("type"), r.PathValue("name"), req.Entries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]int{"removed": removed})
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// actionCommandDefaults 各后端的默认封禁/解封命令模板
+var actionCommandDefaults = map[string]struct{ ban, unban string }{
+	"nftables": {
+		ban:   "nft add element {{.Table}} {{.Set}} { {{.IP}} }",
+		unban: "nft delete element {{.Table}} {{.Set}} { {{.IP}} }",
+	},
+	"ipset": {
+		ban:   "ipset add {{.Set}} {{.IP}} -exist",
+		unban: "ipset del {{.Set}} {{.IP}} -exist",
+	},
+	"iptables": {
+		ban:   "{{if .IsIPv6}}ip6tables{{else}}iptables{{end}} -I {{.Chain}} -s {{.IP}} -j DROP",
+		unban: "{{if .IsIPv6}}ip6tables{{else}}iptables{{end}} -D {{.Chain}} -s {{.IP}} -j DROP",
+	},
+	"command": {},
+}
+
+// ActionCommandData 封禁/解封命令模板的数据
+// 可用的模板变量：
+//   - {{.IP}}     - 风险 IP 地址
+//   - {{.IsIPv6}} - 是否为 IPv6 地址
+//   - {{.Family}} - 地址族: ip (IPv4) / ip6 (IPv6)
+//   - {{.Set}}    - 按地址族选择的集合名 (IPv4 使用 set, IPv6 使用 set6)
+//   - {{.Table}}  - nftables 表
+//   - {{.Chain}}  - iptables 链
+//   - {{.TTL}}    - 封禁时长 (秒, 0 表示永久)
+//   - {{.Action}} - 动作名称
+//   - {{.List}}   - 命中的最高等级风险列表名称
+//   - {{.Level}}  - 风险等级
+//   - {{.Count}}  - 命中次数
+type ActionCommandData struct {
+	IP     string
+	IsIPv6 bool
+	Family string
+	Set    string
+	Table  string
+	Chain  string
+	TTL    int64
+	Action string
+	List   string
+	Level  int
+	Count  int
+}
+
+// BanRecord 一条生效中的封禁记录 (持久化到 data_dir/bans.json)
+// 解封命令在封禁时渲染并保存，动作配置被修改或删除后仍可按原命令解封
+type BanRecord struct {
+	Action       string    `json:"action"`                  // 动作名称
+	IP           string    `json:"ip"`                      // 被封禁的 IP
+	Reason       string    `json:"reason"`                  // 封禁原因 (通知项、风险列表与命中次数)
+	BannedAt     time.Time `json:"banned_at"`               // 封禁时间
+	ExpiresAt    time.Time `json:"expires_at,omitzero"`     // 到期时间 (零值表示永久)
+	DryRun       bool      `json:"dry_run,omitempty"`       // 是否为 dry-run (命令未实际执行)
+	UnbanCommand []string  `json:"unban_command,omitempty"` // 解封命令
+
+	UnbanAttempts int       `json:"unban_attempts,omitempty"` // 解封命令失败的次数
+	NextUnbanAt   time.Time `json:"next_unban_at,omitzero"`   // 解封失败后下次重试的时间
+	LastError     string    `json:"last_error,omitempty"`     // 最近一次解封失败的错误
+}
+
+// 解封命令失败后的重试间隔: unbanRetryBackoff * 2^(N-1)，不超过 unbanRetryMaxDelay
+const (
+	unbanRetryBackoff  = 5 * time.Second
+	unbanRetryMaxDelay = 10 * time.Minute
+)
+
+// banKey 封禁记录的键：动作 + IP
+type banKey struct {
+	Action string
+	IP     netip.Addr
+}
+
+// 生效中的封禁记录
+var ActiveBans = make(map[banKey]*BanRecord)
+var ActiveBansMutex sync.Mutex
+
+// bansLoaded 是否已从磁盘加载封禁记录 (只在首次初始化时加载)
+var bansLoaded bool
+
+// bansPath 返回封禁记录文件的路径
+func bansPath() string {
+	configMutex.RLock()
+	dataDir := config.DataDir
+	configMutex.RUnlock()
+	return filepath.Join(dataDir, "bans.json")
+}
+
+// LoadBans 从磁盘加载封禁记录 (仅首次调用时加载)
+func LoadBans() {
+	ActiveBansMutex.Lock()
+	defer ActiveBansMutex.Unlock()
+	if bansLoaded {
+		return
+	}
+	bansLoaded = true
+
+	path := bansPath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Errorf("Failed to read bans %s: %v", path, err)
+		}
+		return
+	}
+	var records []*BanRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		logrus.Errorf("Failed to parse bans %s: %v", path, err)
+		return
+	}
+	for _, r := range records {
+		ip, err := ParseIP(r.IP)
+		if err != nil {
+			continue
+		}
+		ActiveBans[banKey{Action: r.Action, IP: ip}] = r
+	}
+	logrus.Infof("Loaded %d active bans from %s", len(ActiveBans), path)
+}
+
+// saveBansLocked 将封禁记录写入磁盘 (调用方需持有 ActiveBansMutex)
+func saveBansLocked() {
+	records := make([]*BanRecord, 0, len(ActiveBans))
+	for _, r := range ActiveBans {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Action != records[j].Action {
+			return records[i].Action < records[j].Action
+		}
+		return records[i].IP < records[j].IP
+	})
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		logrus.Errorf("Failed to encode bans: %v", err)
+		return
+	}
+	if err := writeFileAtomic(bansPath(), data); err != nil {
+		logrus.Errorf("Failed to save bans: %v", err)
+	}
+}
+
+// TriggerActions 通知项达到触发条件时执行对应的封禁动作 (线程安全)
+// 安全列表中的 IP 与已封禁的 IP 会被跳过；命令在独立的 goroutine 中执行
+func TriggerActions(ip netip.Addr, notif Notification, data TemplateData) {
+	configMutex.RLock()
+	actions := make([]Action, 0, len(config.Actions))
+	for _, action := range config.Actions {
+		if actionListensTo(action, notif.ID) {
+			actions = append(actions, action)
+		}
+	}
+	configMutex.RUnlock()
+	if len(actions) == 0 {
+		return
+	}
+
+	if IsIPInSafeList(ip) {
+		logrus.Warnf("Refusing to ban IP %s: it is in the safe list", ip)
+		return
+	}
+
+	now := time.Now()
+	ActiveBansMutex.Lock()
+	defer ActiveBansMutex.Unlock()
+	for _, action := range actions {
+		if data.SourceListInfo.Level < action.RiskLevel {
+			continue
+		}
+		key := banKey{Action: action.Name, IP: ip}
+		if r, ok := ActiveBans[key]; ok && (!r.DryRun || action.DryRun) {
+			continue
+		}
+
+		cmdData := newActionCommandData(action, ip, data)
+		banCmd, err := renderActionCommand(action.BanTmpl, cmdData)
+		if err != nil {
+			logrus.Errorf("Failed to render ban command of action %s for IP %s: %v", action.Name, ip, err)
+			continue
+		}
+		var unbanCmd []string
+		if action.UnbanTmpl != nil {
+			if unbanCmd, err = renderActionCommand(action.UnbanTmpl, cmdData); err != nil {
+				logrus.Errorf("Failed to render unban command of action %s for IP %s: %v", action.Name, ip, err)
+				continue
+			}
+		}
+
+		record := &BanRecord{
+			Action:       action.Name,
+			IP:           ip.String(),
+			Reason:       fmt.Sprintf("notification %s, list %s (level %d), %d hits", notif.ID, data.SourceListInfo.Name, data.SourceListInfo.Level, data.Count),
+			BannedAt:     now,
+			DryRun:       action.DryRun,
+			UnbanCommand: unbanCmd,
+		}
+		if action.TTLParsed > 0 {
+			record.ExpiresAt = now.Add(action.TTLParsed)
+		}
+		ActiveBans[key] = record
+
+		go func(action Action, key banKey, record *BanRecord) {
+			if err := runActionCommand(action.Name, "ban", banCmd, action.DryRun, action.TimeoutParsed); err != nil {
+				logrus.Errorf("Failed to ban IP %s with action %s: %v", key.IP, action.Name, err)
+				ActiveBansMutex.Lock()
+				if ActiveBans[key] == record {
+					delete(ActiveBans, key)
+					saveBansLocked()
+				}
+				ActiveBansMutex.Unlock()
+				return
+			}
+			logrus.Infof("Banned IP %s with action %s (%s), expires: %s", key.IP, action.Name, record.Reason, formatBanExpiry(record.ExpiresAt))
+		}(action, key, record)
+	}
+	saveBansLocked()
+}
+
+// actionListensTo 判断动作是否由该通知项触发
+func actionListensTo(action Action, notifID string) bool {
+	if len(action.On) == 0 {
+		return true
+	}
+	for _, id := range action.On {
+		if id == notifID {
+			return true
+		}
+	}
+	return false
+}
+
+// newActionCommandData 生成命令模板数据
+func newActionCommandData(action Action, ip netip.Addr, data TemplateData) ActionCommandData {
+	d := ActionCommandData{
+		IP:     ip.String(),
+		IsIPv6: ip.Is6(),
+		Family: "ip",
+		Set:    action.Set,
+		Table:  action.Table,
+		Chain:  action.Chain,
+		TTL:    int64(action.TTLParsed / time.Second),
+		Action: action.Name,
+		List:   data.SourceListInfo.Name,
+		Level:  data.SourceListInfo.Level,
+		Count:  data.Count,
+	}
+	if d.IsIPv6 {
+		d.Family = "ip6"
+		d.Set = action.Set6
+	}
+	return d
+}
+
+// renderActionCommand 渲染命令模板并拆分为参数列表
+func renderActionCommand(tmpl *template.Template, data ActionCommandData) ([]string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	args, err := splitCommandLine(buf.String())
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return args, nil
+}
+
+// splitCommandLine 按空白拆分命令行，支持单引号、双引号与反斜杠转义 (不经过 shell 执行)
+func splitCommandLine(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in command: %s", s)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash in command: %s", s)
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// runActionCommand 执行封禁/解封命令，dryRun 时只记录命令
+func runActionCommand(actionName, kind string, args []string, dryRun bool, timeout time.Duration) error {
+	if dryRun {
+		logrus.Infof("[dry-run] Action %s would %s: %s", actionName, kind, strings.Join(args, " "))
+		return nil
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	logrus.Debugf("Action %s %s: %s", actionName, kind, strings.Join(args, " "))
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// formatBanExpiry 格式化封禁到期时间
+func formatBanExpiry(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// StartBanScheduler 启动解封调度器
+// 每 1 秒检查一次封禁记录：到期的执行解封命令；已加入安全列表的 IP 立即解封
+func StartBanScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				logrus.Info("Ban scheduler stopped")
+				return
+			case <-ticker.C:
+				unbanExpired(time.Now())
+			}
+		}
+	}()
+}
+
+// unbanExpired 解封到期或已加入安全列表的 IP
+// 先执行解封命令，成功后才删除并保存封禁记录；失败时保留记录，按退避时间在之后的检查中重试
+func unbanExpired(now time.Time) {
+	type unban struct {
+		key    banKey
+		record *BanRecord
+		reason string
+		err    error
+	}
+	var due []*unban
+
+	ActiveBansMutex.Lock()
+	for key, r := range ActiveBans {
+		if now.Before(r.NextUnbanAt) {
+			continue
+		}
+		switch {
+		case !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt):
+			due = append(due, &unban{key: key, record: r, reason: "ttl expired"})
+		case IsIPInSafeList(key.IP):
+			due = append(due, &unban{key: key, record: r, reason: "ip is in the safe list"})
+		}
+	}
+	ActiveBansMutex.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	configMutex.RLock()
+	timeouts := make(map[string]time.Duration, len(config.Actions))
+	for _, action := range config.Actions {
+		timeouts[action.Name] = action.TimeoutParsed
+	}
+	configMutex.RUnlock()
+
+	// 解封命令在锁外执行 (可能较慢)，调度器串行调用本函数，同一条记录不会被并发解封
+	for _, u := range due {
+		if len(u.record.UnbanCommand) > 0 {
+			u.err = runActionCommand(u.key.Action, "unban", u.record.UnbanCommand, u.record.DryRun, timeouts[u.key.Action])
+		}
+	}
+
+	ActiveBansMutex.Lock()
+	defer ActiveBansMutex.Unlock()
+	for _, u := range due {
+		r := u.record
+		if u.err == nil {
+			if ActiveBans[u.key] == r {
+				delete(ActiveBans, u.key)
+			}
+			logrus.Infof("Unbanned IP %s with action %s (%s)", u.key.IP, u.key.Action, u.reason)
+			continue
+		}
+		r.UnbanAttempts++
+		r.LastError = u.err.Error()
+		delay := unbanRetryBackoff
+		for i := 1; i < r.UnbanAttempts && delay < unbanRetryMaxDelay; i++ {
+			delay *= 2
+		}
+		if delay > unbanRetryMaxDelay {
+			delay = unbanRetryMaxDelay
+		}
+		r.NextUnbanAt = now.Add(delay)
+		logrus.Errorf("Failed to unban IP %s with action %s (%s, attempt %d), retrying in %s: %v",
+			u.key.IP, u.key.Action, u.reason, r.UnbanAttempts, delay, u.err)
+	}
+	saveBansLocked()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestUnbanRetriedAfterFailure 解封命令失败时保留封禁记录并按退避时间重试，成功后才删除
+func TestUnbanRetriedAfterFailure(t *testing.T) {
+	configMutex.Lock()
+	prev := config
+	config = Config{DataDir: t.TempDir()}
+	configMutex.Unlock()
+	ActiveBans = make(map[banKey]*BanRecord)
+	t.Cleanup(func() {
+		configMutex.Lock()
+		config = prev
+		configMutex.Unlock()
+		ActiveBans = make(map[banKey]*BanRecord)
+	})
+
+	now := time.Now()
+	key := banKey{Action: "cmd", IP: netip.MustParseAddr("192.0.2.1")}
+	record := &BanRecord{
+		Action:       key.Action,
+		IP:           key.IP.String(),
+		BannedAt:     now.Add(-time.Hour),
+		ExpiresAt:    now.Add(-time.Second),
+		UnbanCommand: []string{"false"},
+	}
+	ActiveBans[key] = record
+
+	unbanExpired(now)
+	if ActiveBans[key] != record {
+		t.Fatal("ban record removed although the unban command failed")
+	}
+	if record.UnbanAttempts != 1 || record.LastError == "" || !record.NextUnbanAt.After(now) {
+		t.Fatalf("failure not recorded: %+v", record)
+	}
+	data, err := os.ReadFile(bansPath())
+	if err != nil || !strings.Contains(string(data), `"unban_attempts": 1`) {
+		t.Fatalf("failed unban not persisted: %v\n%s", err, data)
+	}
+
+	// 退避时间内不重试
+	unbanExpired(now.Add(time.Second))
+	if record.UnbanAttempts != 1 {
+		t.Fatalf("unban retried before next_unban_at: attempts = %d", record.UnbanAttempts)
+	}
+
+	// 到达重试时间后再次执行，成功后删除记录
+	record.UnbanCommand = []string{"true"}
+	unbanExpired(record.NextUnbanAt)
+	if _, ok := ActiveBans[key]; ok {
+		t.Fatal("ban record kept after successful unban")
+	}
+	data, err = os.ReadFile(bansPath())
+	if err != nil || strings.Contains(string(data), key.IP.String()) {
+		t.Fatalf("unbanned record still persisted: %v\n%s", err, data)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TailCheckpoint tail 模式的读取进度 (持久化到 data_dir/checkpoints/<name>.json)
+type TailCheckpoint struct {
+	Path      string    `json:"path"`       // 日志文件路径
+	Inode     uint64    `json:"inode"`      // 文件 inode (Windows 下恒为 0)
+	Offset    int64     `json:"offset"`     // 已处理到的字节偏移
+	UpdatedAt time.Time `json:"updated_at"` // 写入时间
+}
+
+// unsafeFileNameChars 文件名中不安全的字符
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// safeFileName 将名称转换为可安全用作文件名的字符串
+func safeFileName(name string) string {
+	s := unsafeFileNameChars.ReplaceAllString(name, "_")
+	if s == "" {
+		s = "_"
+	}
+	return s
+}
+
+// checkpointPath 返回目标日志的 checkpoint 文件路径
+func checkpointPath(lf TargetLog) string {
+	configMutex.RLock()
+	dataDir := config.DataDir
+	configMutex.RUnlock()
+	return filepath.Join(dataDir, "checkpoints", safeFileName(lf.Name)+".json")
+}
+
+// loadCheckpoint 读取 checkpoint，不存在时返回 nil
+func loadCheckpoint(lf TargetLog) (*TailCheckpoint, error) {
+	data, err := os.ReadFile(checkpointPath(lf))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var cp TailCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint: %v", err)
+	}
+	return &cp, nil
+}
+
+// saveCheckpoint 原子写入 checkpoint (先写临时文件再重命名)
+func saveCheckpoint(lf TargetLog, cp TailCheckpoint) error {
+	cp.UpdatedAt = time.Now()
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(checkpointPath(lf), data)
+}
+
+// writeFileAtomic 原子写入文件，自动创建所在目录
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// resolveTailStart 根据 checkpoint 计算 tail 的起始偏移
+//   - 无 checkpoint 或日志路径已变更: 从文件末尾开始 (与旧行为一致)
+//   - inode 未变且文件未被截断: 从 checkpoint 偏移处继续
+//   - inode 已变 (文件被轮转) 或文件被截断: 从文件开头开始
+func resolveTailStart(lf TargetLog, info os.FileInfo) int64 {
+	inode := fileInode(info)
+	cp, err := loadCheckpoint(lf)
+	if err != nil {
+		logrus.Warnf("Failed to load checkpoint for %s: %v, starting from end of file", lf.Name, err)
+		return info.Size()
+	}
+	if cp == nil || cp.Path != lf.Path {
+		return info.Size()
+	}
+	if cp.Inode != inode {
+		logrus.Infof("File %s was rotated (inode %d -> %d), starting from beginning", lf.Path, cp.Inode, inode)
+		return 0
+	}
+	if checkpointTruncated(*cp, info) {
+		logrus.Infof("File %s was truncated (offset %d > size %d), starting from beginning", lf.Path, cp.Offset, info.Size())
+		return 0
+	}
+	logrus.Infof("Resuming %s from checkpoint offset %d", lf.Path, cp.Offset)
+	return cp.Offset
+}
+
+// checkpointTruncated 判断 checkpoint 对应的文件是否已被截断 (inode 未变但文件小于已处理的偏移)
+func checkpointTruncated(cp TailCheckpoint, info os.FileInfo) bool {
+	return fileInode(info) == cp.Inode && cp.Offset > info.Size()
+}
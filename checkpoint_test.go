@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	setTestConfig(t, Config{DataDir: t.TempDir()})
+	lf := TargetLog{Name: "nginx/access log", Path: "/var/log/nginx/access.log"}
+
+	if cp, err := loadCheckpoint(lf); cp != nil || err != nil {
+		t.Fatalf("missing checkpoint: got %+v, %v", cp, err)
+	}
+	want := TailCheckpoint{Path: lf.Path, Inode: 42, Offset: 1234}
+	if err := saveCheckpoint(lf, want); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	path := checkpointPath(lf)
+	if filepath.Base(path) != "nginx_access_log.json" {
+		t.Fatalf("unsafe checkpoint file name %s", path)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary file left behind: %v", err)
+	}
+	got, err := loadCheckpoint(lf)
+	if err != nil || got.Path != want.Path || got.Inode != want.Inode || got.Offset != want.Offset || got.UpdatedAt.IsZero() {
+		t.Fatalf("load: got %+v, %v", got, err)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCheckpoint(lf); err == nil {
+		t.Fatal("expected error for corrupt checkpoint")
+	}
+}
+
+func TestResolveTailStart(t *testing.T) {
+	setTestConfig(t, Config{DataDir: t.TempDir()})
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(logPath, []byte(strings.Repeat("x", 100)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf := TargetLog{Name: "app", Path: logPath}
+	inode := fileInode(info)
+
+	cases := []struct {
+		name string
+		cp   *TailCheckpoint // nil 表示无 checkpoint
+		want int64
+	}{
+		{"no checkpoint starts at end", nil, 100},
+		{"resume from offset", &TailCheckpoint{Path: logPath, Inode: inode, Offset: 40}, 40},
+		{"offset at end of file", &TailCheckpoint{Path: logPath, Inode: inode, Offset: 100}, 100},
+		{"path changed starts at end", &TailCheckpoint{Path: "/other.log", Inode: inode, Offset: 40}, 100},
+		{"truncated starts at beginning", &TailCheckpoint{Path: logPath, Inode: inode, Offset: 500}, 0},
+	}
+	if inode != 0 {
+		// Windows 下 inode 恒为 0，无法模拟轮转
+		cases = append(cases, struct {
+			name string
+			cp   *TailCheckpoint
+			want int64
+		}{"inode changed starts at beginning", &TailCheckpoint{Path: logPath, Inode: inode + 1, Offset: 40}, 0})
+	}
+	for _, c := range cases {
+		os.Remove(checkpointPath(lf))
+		if c.cp != nil {
+			if err := saveCheckpoint(lf, *c.cp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := resolveTailStart(lf, info); got != c.want {
+			t.Errorf("%s: start = %d, want %d", c.name, got, c.want)
+		}
+	}
+
+	if err := os.WriteFile(checkpointPath(lf), []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := resolveTailStart(lf, info); got != 100 {
+		t.Errorf("corrupt checkpoint: start = %d, want end of file", got)
+	}
+}
+
+// TestTailTruncationResetsOffset 运行中文件被截断后从头读取，checkpoint 偏移与文件实际内容一致
+func TestTailTruncationResetsOffset(t *testing.T) {
+	setTestConfig(t, Config{DataDir: t.TempDir()})
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(logPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	lf := TargetLog{Name: "truncate-test", Path: logPath, CheckpointParsed: 20 * time.Millisecond, Watch: TailWatchPoll}
+	t.Cleanup(func() { MetricLinesProcessed.DeleteLabelValues(lf.Name) })
+
+	// 首次运行从文件末尾开始：先保存偏移 0 的 checkpoint，从头读取
+	info, _ := os.Stat(logPath)
+	if err := saveCheckpoint(lf, TailCheckpoint{Path: logPath, Inode: fileInode(info)}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		processTailMode(ctx, lf)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	waitOffset := func(want int64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if cp, _ := loadCheckpoint(lf); cp != nil && cp.Offset == want {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		cp, _ := loadCheckpoint(lf)
+		t.Fatalf("checkpoint offset = %+v, want %d", cp, want)
+	}
+
+	old := strings.Repeat("old line\n", 20)
+	appendFile(t, logPath, old)
+	waitOffset(int64(len(old)))
+
+	// 截断后写入少于原大小的内容
+	if err := os.Truncate(logPath, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, logPath, "new line 1\n")
+	waitOffset(int64(len("new line 1\n")))
+
+	// 继续追加，超过截断前的大小后偏移仍然正确
+	more := strings.Repeat("new line 2\n", 30)
+	appendFile(t, logPath, more)
+	waitOffset(int64(len("new line 1\n") + len(more)))
+}
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/creasty/defaults"
@@ -16,29 +23,99 @@ var ConfigFilePath string = "config.yaml" // 默认配置文件路径
 
 // Config 表示根配置结构
 type Config struct {
-	Logging   Logging   `yaml:"logging"`    // 日志配置
-	APIServer APIServer `yaml:"api_server"` // API 服务器配置
+	Logging   Logging   `yaml:"logging"`                 // 日志配置
+	APIServer APIServer `yaml:"api_server"`              // API 服务器配置
+	DataDir   string    `yaml:"data_dir" default:"data"` // 运行状态数据目录 (如 tail checkpoint) (默认 data)
 
 	SafeList      []IPList      `yaml:"safe_list"`     // 安全 IP 列表配置 (白名单)
 	RiskList      []IPList      `yaml:"risk_list"`     // 风险 IP 列表配置
 	TargetLogs    []TargetLog   `yaml:"target_logs"`   // 监控的目标日志文件
 	Notifications Notifications `yaml:"notifications"` // 通知配置
+	Actions       []Action      `yaml:"actions"`       // 封禁动作 (通知达到阈值时执行)
 }
 
 // APIServer API 服务器配置
 type APIServer struct {
-	Enabled bool   `yaml:"enabled" default:"true"`         // 是否启用 API 服务器 (默认 true)
-	Addr    string `yaml:"addr" default:"127.0.0.1:19000"` // 监听地址 (默认 127.0.0.1:19000)
+	Enabled    bool       `yaml:"enabled" default:"true"`               // 是否启用 API 服务器 (默认 true)
+	Addr       string     `yaml:"addr" default:"127.0.0.1:19000"`       // 监听地址 (默认 127.0.0.1:19000)
+	Tokens     []APIToken `yaml:"tokens,omitempty"`                     // Bearer Token 列表 (为空表示不认证)
+	TLSCert    string     `yaml:"tls_cert,omitempty"`                   // TLS 证书文件 (与 tls_key 同时配置时启用 HTTPS)
+	TLSKey     string     `yaml:"tls_key,omitempty"`                    // TLS 私钥文件
+	ClientCA   string     `yaml:"client_ca,omitempty"`                  // 客户端 CA 证书文件 (配置后要求客户端证书, 即 mTLS)
+	Socket     string     `yaml:"socket,omitempty"`                     // Unix Socket 路径 (可选, 与 addr 同时监听)
+	SocketMode string     `yaml:"socket_mode,omitempty" default:"0600"` // Unix Socket 文件权限 (默认 0600)
+}
+
+// API Token 权限范围
+const (
+	APIScopeRead  = "read"  // 只读: /status (不含配置), /check, /export, /metrics
+	APIScopeAdmin = "admin" // 管理: 全部接口 (含 /notify 与 /status 中的配置)
+)
+
+// APIToken API 访问令牌
+type APIToken struct {
+	Name  string `yaml:"name,omitempty"`                 // 令牌名称 (用于日志)
+	Token string `yaml:"token" json:"-"`                 // 令牌值 (请求头 Authorization: Bearer <token>)
+	Scope string `yaml:"scope,omitempty" default:"read"` // 权限范围: read (默认) / admin
 }
 
 // Notifications 通知配置包装
 type Notifications struct {
 	Timeout       string         `yaml:"timeout,omitempty" default:"10s"`   // 请求超时 (默认 10s)
 	RetryCount    int            `yaml:"retry_count,omitempty" default:"5"` // 共享重试次数 (默认 5)
+	Retry         RetryPolicy    `yaml:"retry,omitempty"`                   // 共享重试策略 (通知项未配置的字段使用此处的值)
 	Services      []Notification `yaml:"services"`                          // 通知服务列表
 	TimeoutParsed time.Duration  // 解析后的超时
 }
 
+// 通知投递模式
+const (
+	DeliveryAny         = "any"         // 同一 IP 的通知中任一发送成功即视为送达, 其余失败的不再重试
+	DeliveryIndependent = "independent" // 每个通知项独立重试, 直到自身发送成功或重试耗尽
+)
+
+// 超出速率限制时的处理方式
+const (
+	RateLimitOverflowQueue   = "queue"   // 在队列中等待令牌
+	RateLimitOverflowSummary = "summary" // 合并为一条汇总消息, 有令牌时发送
+)
+
+// RetryPolicy 通知发送失败后的重试策略
+// 第 N 次失败后等待 backoff * 2^(N-1) (不超过 max_delay, 并加入 ±jitter 的随机抖动) 再重试
+type RetryPolicy struct {
+	Count          int           `yaml:"count,omitempty"`     // 最大发送次数 (默认使用 notifications.retry_count)
+	Backoff        string        `yaml:"backoff,omitempty"`   // 首次重试前的等待时间, 之后每次翻倍 (默认 5s)
+	MaxDelay       string        `yaml:"max_delay,omitempty"` // 单次等待时间上限 (默认 5m)
+	Jitter         *float64      `yaml:"jitter,omitempty"`    // 等待时间的随机抖动比例, 0-1 (默认 0.2)
+	MaxAge         string        `yaml:"max_age,omitempty"`   // 通知自入队起的最长重试时间, 超过后不再重试 (默认不限)
+	BackoffParsed  time.Duration // 解析后的首次等待时间
+	MaxDelayParsed time.Duration // 解析后的等待时间上限
+	MaxAgeParsed   time.Duration // 解析后的最长重试时间
+	JitterParsed   float64       // 解析后的抖动比例
+}
+
+// Action 封禁动作配置
+// 通知项达到触发条件时对风险 IP 执行封禁命令，封禁到期 (ttl) 后执行解封命令
+type Action struct {
+	Name          string             `yaml:"name"`                             // 动作名称 (必填, 用于日志与封禁记录)
+	Type          string             `yaml:"type"`                             // 后端类型: nftables, ipset, iptables, command
+	On            []string           `yaml:"on,omitempty"`                     // 触发的通知项 (name 或 service#配置哈希, 为空表示任一通知项)
+	RiskLevel     int                `yaml:"risk_level,omitempty" default:"1"` // 风险等级阈值 (仅封禁风险等级 >= 该值的 IP, 默认 1)
+	TTL           string             `yaml:"ttl,omitempty"`                    // 封禁时长 (如 24h, 支持 d/h/m/s, 为空表示永久封禁)
+	DryRun        bool               `yaml:"dry_run,omitempty"`                // 仅记录将要执行的命令, 不实际执行 (默认 false)
+	Table         string             `yaml:"table,omitempty"`                  // nftables 表 (默认 "inet filter")
+	Set           string             `yaml:"set,omitempty"`                    // nftables/ipset 的 IPv4 集合名 (默认 iplog_ban)
+	Set6          string             `yaml:"set6,omitempty"`                   // nftables/ipset 的 IPv6 集合名 (默认 set + "6")
+	Chain         string             `yaml:"chain,omitempty"`                  // iptables 链 (默认 INPUT)
+	BanCommand    string             `yaml:"ban_command,omitempty"`            // 封禁命令模板 (可选, 覆盖后端默认命令; command 类型必填)
+	UnbanCommand  string             `yaml:"unban_command,omitempty"`          // 解封命令模板 (可选, 覆盖后端默认命令)
+	Timeout       string             `yaml:"timeout,omitempty" default:"10s"`  // 命令执行超时 (默认 10s)
+	TTLParsed     time.Duration      // 解析后的封禁时长
+	TimeoutParsed time.Duration      // 解析后的命令执行超时
+	BanTmpl       *template.Template // 解析后的封禁命令模板
+	UnbanTmpl     *template.Template // 解析后的解封命令模板 (为空表示不执行解封命令)
+}
+
 // IPList IP 列表配置 (用于 safe_list 和 risk_list)
 type IPList struct {
 	Name                 string            `yaml:"name"`                                   // 列表名称 (用于日志输出和标记 IP 来源) - 必填
@@ -53,31 +130,58 @@ type IPList struct {
 	JSONPath             string            `yaml:"json_path,omitempty"`                    // JSON 路径 (仅 json 格式)
 	CustomHeaders        map[string]string `yaml:"custom_headers,omitempty"`               // 自定义请求头 (仅 url)
 	Level                int               `yaml:"level,omitempty" default:"1"`            // 列表等级 (仅 risk_list, 默认 1)
+	MaxAge               string            `yaml:"max_age,omitempty"`                      // 数据最长有效期 (仅 file/url, 超过后输出告警并在 /status 中标记为过期, 默认不检查)
 	UpdateIntervalParsed time.Duration     // 解析后的更新间隔
 	TimeoutParsed        time.Duration     // 解析后的超时
+	MaxAgeParsed         time.Duration     // 解析后的最长有效期
 }
 
 // TargetLog 目标日志文件配置
 type TargetLog struct {
-	Name               string        `yaml:"name"`                                       // 文件名称 (用于通知)
-	Path               string        `yaml:"path"`                                       // 日志文件路径
-	ReadMode           string        `yaml:"read_mode" default:"once"`                   // 读取模式: tail (持续监控), once (一次性) (默认 once)
-	ReadInterval       string        `yaml:"read_interval,omitempty" default:"2h"`       // 一次性读取间隔 (仅 once 模式, 支持 h/m/s/d, 默认 2h)
-	CleanAfterRead     bool          `yaml:"clean_after_read,omitempty" default:"false"` // 读取后清空 (仅 once 模式, 默认 false)
-	IgnoreKeys         []string      `yaml:"ignore_keys,omitempty"`                      // 忽略的关键字，当日志行包含这些关键字时跳过检测
-	Level              int           `yaml:"level,omitempty" default:"1"`                // 日志文件等级 (用于标记 IP 来源, 默认 1)
-	ReadIntervalParsed time.Duration // 解析后的读取间隔
+	Name               string         `yaml:"name"`                                       // 文件名称 (用于通知)
+	Path               string         `yaml:"path"`                                       // 日志文件路径
+	ReadMode           string         `yaml:"read_mode" default:"once"`                   // 读取模式: tail (持续监控), once (一次性) (默认 once)
+	ReadInterval       string         `yaml:"read_interval,omitempty" default:"2h"`       // 一次性读取间隔 (仅 once 模式, 支持 h/m/s/d, 默认 2h)
+	CleanAfterRead     bool           `yaml:"clean_after_read,omitempty" default:"false"` // 读取后清空 (仅 once 模式, 默认 false)
+	IgnoreKeys         []string       `yaml:"ignore_keys,omitempty"`                      // 忽略的关键字，当日志行包含这些关键字时跳过检测
+	Level              int            `yaml:"level,omitempty" default:"1"`                // 日志文件等级 (用于标记 IP 来源, 默认 1)
+	IPRegex            string         `yaml:"ip_regex,omitempty"`                         // 提取 IP 的正则表达式, 所有命名捕获组 (如 (?P<client>...)) 中的 IP 都会被检查 (可选, 与 ip_field 二选一)
+	IPField            int            `yaml:"ip_field,omitempty"`                         // 提取 IP 的字段序号, 按空白分隔, 从 1 开始, 负数表示倒数 (可选, 与 ip_regex 二选一)
+	Format             string         `yaml:"format,omitempty" default:"text"`            // 日志格式: text, json, nginx_combined, apache_common, syslog, logfmt (默认 text)
+	IPKeys             []string       `yaml:"ip_keys,omitempty"`                          // 结构化格式下提取 IP 的字段名, 如 remote_addr, X-Forwarded-For (可选, 非 text 格式有效)
+	CheckpointInterval string         `yaml:"checkpoint_interval,omitempty" default:"5s"` // 读取进度保存间隔 (仅 tail 模式, 支持 h/m/s, 默认 5s)
+	Watch              string         `yaml:"watch,omitempty" default:"poll"`             // 文件变化检测方式: poll (轮询), inotify (仅 tail 模式, 默认 poll)
+	ReadIntervalParsed time.Duration  // 解析后的读取间隔
+	CheckpointParsed   time.Duration  // 解析后的读取进度保存间隔
+	IPRegexParsed      *regexp.Regexp // 解析后的 IP 正则表达式
 }
 
 // Notification 通知配置
 type Notification struct {
-	Service         string         `yaml:"service"`                          // 通知服务: slack, discord, email, webhook
-	Threshold       int            `yaml:"threshold" default:"5"`            // 预警阈值 (命中次数) (默认 5)
-	PayloadTemplate string         `yaml:"payload_template"`                 // 消息模板 (使用 Go 模板语法)
-	PayloadTitle    string         `yaml:"payload_title,omitempty"`          // 消息标题 (可选)
-	Config          map[string]any `yaml:"config,omitempty"`                 // 服务配置 (如 webhook_url, token 等)
-	LogLevel        int            `yaml:"log_level,omitempty" default:"1"`  // 通知等级 (仅通知高于等于该等级的日志文件, 默认 1)
-	RiskLevel       int            `yaml:"risk_level,omitempty" default:"1"` // 风险等级 (仅通知高于等于该等级的风险 IP, 默认 1)
+	Name              string         `yaml:"name,omitempty"`                                // 通知项名称 (可选, 用于区分同类服务, 默认 service#配置哈希)
+	Service           string         `yaml:"service"`                                       // 通知服务: slack, discord, email, webhook, curl 等
+	Threshold         int            `yaml:"threshold" default:"5"`                         // 预警阈值 (命中次数) (默认 5)
+	PayloadTemplate   string         `yaml:"payload_template"`                              // 消息模板 (使用 Go 模板语法)
+	PayloadTitle      string         `yaml:"payload_title,omitempty"`                       // 消息标题 (可选)
+	HTMLTemplate      string         `yaml:"html_template,omitempty"`                       // HTML 正文模板 (可选, 仅 email 服务, 与 payload_template 使用相同的模板数据)
+	Config            map[string]any `yaml:"config,omitempty"`                              // 服务配置 (如 webhook_url, token 等)
+	LogLevel          int            `yaml:"log_level,omitempty" default:"1"`               // 通知等级 (仅通知高于等于该等级的日志文件, 默认 1)
+	RiskLevel         int            `yaml:"risk_level,omitempty" default:"1"`              // 风险等级 (仅通知高于等于该等级的风险 IP, 默认 1)
+	Window            string         `yaml:"window,omitempty"`                              // 阈值统计时间窗口 (如 5m, 支持 h/m/s/d, 默认不限时间)
+	Cooldown          string         `yaml:"cooldown,omitempty"`                            // 同一 IP 的告警冷却时间 (如 30m, 冷却期内的告警只计数, 结束时发送一条汇总, 默认不冷却)
+	FollowUpTemplate  string         `yaml:"followup_template,omitempty"`                   // 冷却结束时汇总消息的模板 (可选, 默认使用 payload_template)
+	Delivery          string         `yaml:"delivery,omitempty" default:"any"`              // 投递模式: any (同一 IP 有任一通知项发送成功即停止重试, 默认) / independent (各自重试直到成功)
+	RateLimit         string         `yaml:"rate_limit,omitempty"`                          // 发送速率限制 (令牌桶, 如 20/min、1/s、100/h, 默认不限)
+	RateLimitOverflow string         `yaml:"rate_limit_overflow,omitempty" default:"queue"` // 超出速率时的处理方式: queue (在队列中等待, 默认) / summary (合并为一条汇总消息)
+	Retry             RetryPolicy    `yaml:"retry,omitempty"`                               // 重试策略 (可选, 未配置的字段使用 notifications.retry)
+	Digest            string         `yaml:"digest,omitempty"`                              // 摘要周期 (如 15m, 周期内的告警合并为一条消息发送, 默认不合并)
+	DigestTemplate    string         `yaml:"digest_template,omitempty"`                     // 摘要消息模板 (可选, 模板数据为 DigestData)
+	WindowParsed      time.Duration  // 解析后的时间窗口
+	CooldownParsed    time.Duration  // 解析后的冷却时间
+	DigestParsed      time.Duration  // 解析后的摘要周期
+	RateLimitCount    int            // 解析后的速率限制: 每个周期允许发送的次数 (0 表示不限)
+	RateLimitPer      time.Duration  // 解析后的速率限制周期
+	ID                string         // 通知项标识 (name 或 service#配置哈希)
 }
 
 // initAppConfig 初始化应用配置（日志、IP列表等）
@@ -88,28 +192,59 @@ func initAppConfig(config *Config) error {
 	}
 
 	// 设置 safe_list 并解析时间字符串
+	seenSafe := make(map[string]bool)
 	for i := range config.SafeList {
 		// 白名单的 Level 始终为 0（风险等级为 0）
 		config.SafeList[i].Level = 0
 		if err := initIPListConfig(&config.SafeList[i]); err != nil {
 			return fmt.Errorf("invalid safe_list config for %s: %v", config.SafeList[i].Name, err)
 		}
+		if seenSafe[config.SafeList[i].Name] {
+			return fmt.Errorf("duplicate safe_list name: %s", config.SafeList[i].Name)
+		}
+		seenSafe[config.SafeList[i].Name] = true
 	}
 
 	// 设置 risk_list 并解析时间字符串
+	seenRisk := make(map[string]bool)
 	for i := range config.RiskList {
 		if err := initIPListConfig(&config.RiskList[i]); err != nil {
 			return fmt.Errorf("invalid risk_list config for %s: %v", config.RiskList[i].Name, err)
 		}
+		if seenRisk[config.RiskList[i].Name] {
+			return fmt.Errorf("duplicate risk_list name: %s", config.RiskList[i].Name)
+		}
+		seenRisk[config.RiskList[i].Name] = true
 	}
 
 	// 解析 TargetLog 的时间字符串
+	seenLogs := make(map[string]bool)
 	for i := range config.TargetLogs {
+		if seenLogs[config.TargetLogs[i].Name] {
+			return fmt.Errorf("duplicate target_logs name: %s", config.TargetLogs[i].Name)
+		}
+		seenLogs[config.TargetLogs[i].Name] = true
 		dur, err := ParseDuration(config.TargetLogs[i].ReadInterval)
 		if err != nil {
 			return fmt.Errorf("invalid read_interval for %s: %v", config.TargetLogs[i].Name, err)
 		}
 		config.TargetLogs[i].ReadIntervalParsed = dur
+		dur, err = ParseDuration(config.TargetLogs[i].CheckpointInterval)
+		if err == nil && dur <= 0 {
+			err = fmt.Errorf("must be greater than 0")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid checkpoint_interval for %s: %v", config.TargetLogs[i].Name, err)
+		}
+		config.TargetLogs[i].CheckpointParsed = dur
+		switch config.TargetLogs[i].Watch {
+		case TailWatchPoll, TailWatchInotify:
+		default:
+			return fmt.Errorf("invalid watch for %s: %s (poll, inotify)", config.TargetLogs[i].Name, config.TargetLogs[i].Watch)
+		}
+		if err := initTargetLogExtractor(&config.TargetLogs[i]); err != nil {
+			return fmt.Errorf("invalid target_logs config for %s: %v", config.TargetLogs[i].Name, err)
+		}
 	}
 
 	// 解析通知超时
@@ -121,6 +256,45 @@ func initAppConfig(config *Config) error {
 		config.Notifications.TimeoutParsed = dur
 	}
 
+	// 解析通知项配置
+	seenIDs := make(map[string]bool)
+	for i := range config.Notifications.Services {
+		notif := &config.Notifications.Services[i]
+		if err := initNotificationConfig(notif, &config.Notifications); err != nil {
+			return fmt.Errorf("invalid notification config for %s: %v", notif.Service, err)
+		}
+		if seenIDs[notif.ID] {
+			if notif.Name == "" {
+				return fmt.Errorf("duplicate notification %s: same service and config as another notification without name, set name to distinguish them", notif.ID)
+			}
+			return fmt.Errorf("duplicate notification name: %s", notif.ID)
+		}
+		seenIDs[notif.ID] = true
+	}
+
+	// 解析封禁动作配置
+	seenActions := make(map[string]bool)
+	for i := range config.Actions {
+		action := &config.Actions[i]
+		if err := initActionConfig(action); err != nil {
+			return fmt.Errorf("invalid action config for %s: %v", action.Name, err)
+		}
+		if seenActions[action.Name] {
+			return fmt.Errorf("duplicate action name: %s", action.Name)
+		}
+		seenActions[action.Name] = true
+		for _, id := range action.On {
+			if !seenIDs[id] {
+				return fmt.Errorf("action %s refers to unknown notification: %s", action.Name, id)
+			}
+		}
+	}
+
+	// 校验 API 服务器配置
+	if err := initAPIServerConfig(&config.APIServer); err != nil {
+		return fmt.Errorf("invalid api_server config: %v", err)
+	}
+
 	// 初始化日志
 	err := initLogger(&config.Logging)
 	if err != nil {
@@ -149,6 +323,12 @@ func initIPListConfig(list *IPList) error {
 			return fmt.Errorf("invalid update_interval: %v", err)
 		}
 		list.UpdateIntervalParsed = dur
+
+		dur, err = ParseDuration(list.MaxAge)
+		if err != nil {
+			return fmt.Errorf("invalid max_age: %v", err)
+		}
+		list.MaxAgeParsed = dur
 	}
 
 	// 解析 url 来源的超时
@@ -163,6 +343,207 @@ func initIPListConfig(list *IPList) error {
 	return nil
 }
 
+// initNotificationConfig 初始化 Notification 配置项, global 为共享的通知配置
+func initNotificationConfig(notif *Notification, global *Notifications) error {
+	notif.ID = notificationID(notif)
+
+	dur, err := ParseDuration(notif.Window)
+	if err != nil {
+		return fmt.Errorf("invalid window: %v", err)
+	}
+	notif.WindowParsed = dur
+
+	dur, err = ParseDuration(notif.Cooldown)
+	if err != nil {
+		return fmt.Errorf("invalid cooldown: %v", err)
+	}
+	notif.CooldownParsed = dur
+
+	dur, err = ParseDuration(notif.Digest)
+	if err != nil {
+		return fmt.Errorf("invalid digest: %v", err)
+	}
+	notif.DigestParsed = dur
+
+	notif.Delivery = strings.ToLower(notif.Delivery)
+	if notif.Delivery == "" {
+		notif.Delivery = DeliveryAny
+	}
+	if notif.Delivery != DeliveryAny && notif.Delivery != DeliveryIndependent {
+		return fmt.Errorf("unsupported delivery: %s (any, independent)", notif.Delivery)
+	}
+
+	if notif.RateLimitCount, notif.RateLimitPer, err = parseRateLimit(notif.RateLimit); err != nil {
+		return fmt.Errorf("invalid rate_limit: %v", err)
+	}
+	notif.RateLimitOverflow = strings.ToLower(notif.RateLimitOverflow)
+	if notif.RateLimitOverflow == "" {
+		notif.RateLimitOverflow = RateLimitOverflowQueue
+	}
+	if notif.RateLimitOverflow != RateLimitOverflowQueue && notif.RateLimitOverflow != RateLimitOverflowSummary {
+		return fmt.Errorf("unsupported rate_limit_overflow: %s (queue, summary)", notif.RateLimitOverflow)
+	}
+
+	if err := initRetryPolicy(&notif.Retry, global); err != nil {
+		return fmt.Errorf("invalid retry: %v", err)
+	}
+
+	// script 与 email 服务在加载配置时校验，避免到发送时才发现错误
+	switch strings.ToLower(notif.Service) {
+	case "script":
+		if _, err := parseScriptConfig(notif.Config, 0); err != nil {
+			return err
+		}
+	case "email":
+		if _, err := parseEmailConfig(notif.Config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notificationID 返回通知项标识: name，未配置时为 "service#配置哈希" (如 webhook#3f2a9c1e)
+// 持久化队列、客户端注册表、冷却与摘要状态都按标识关联，哈希只由服务类型与 config 计算，
+// 增删或调整其他通知项的顺序不会改变标识；修改未命名通知项的 config 后视为新的通知项
+func notificationID(notif *Notification) string {
+	if notif.Name != "" {
+		return notif.Name
+	}
+	// json 按键排序输出 map，相同的配置得到相同的哈希
+	data, _ := json.Marshal(notif.Config)
+	sum := sha256.Sum256(append([]byte(strings.ToLower(notif.Service)+"\n"), data...))
+	return notif.Service + "#" + hex.EncodeToString(sum[:4])
+}
+
+// parseRateLimit 解析速率限制 "N/周期"，周期支持 s/sec/second、m/min/minute、h/hour、d/day 或时长 (如 20/5m)
+// 空字符串表示不限速，返回 count 为 0
+func parseRateLimit(s string) (count int, per time.Duration, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, 0, nil
+	}
+	n, unit, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected N/period, e.g. 20/min: %s", s)
+	}
+	count, err = strconv.Atoi(strings.TrimSpace(n))
+	if err != nil || count <= 0 {
+		return 0, 0, fmt.Errorf("invalid count: %s", n)
+	}
+	switch unit = strings.ToLower(strings.TrimSpace(unit)); unit {
+	case "s", "sec", "second":
+		per = time.Second
+	case "m", "min", "minute":
+		per = time.Minute
+	case "h", "hour":
+		per = time.Hour
+	case "d", "day":
+		per = 24 * time.Hour
+	default:
+		if per, err = ParseDuration(unit); err != nil || per <= 0 {
+			return 0, 0, fmt.Errorf("invalid period: %s", unit)
+		}
+	}
+	return count, per, nil
+}
+
+// initRetryPolicy 将通知项的重试策略与共享配置合并并解析
+// 优先级: 通知项 retry > notifications.retry > notifications.retry_count / 内置默认值
+func initRetryPolicy(p *RetryPolicy, global *Notifications) error {
+	if p.Count == 0 {
+		p.Count = global.Retry.Count
+	}
+	if p.Count == 0 {
+		p.Count = global.RetryCount
+	}
+	if p.Count <= 0 {
+		p.Count = 5
+	}
+	if p.Backoff == "" {
+		p.Backoff = global.Retry.Backoff
+	}
+	if p.Backoff == "" {
+		p.Backoff = "5s"
+	}
+	if p.MaxDelay == "" {
+		p.MaxDelay = global.Retry.MaxDelay
+	}
+	if p.MaxDelay == "" {
+		p.MaxDelay = "5m"
+	}
+	if p.MaxAge == "" {
+		p.MaxAge = global.Retry.MaxAge
+	}
+	if p.Jitter == nil {
+		p.Jitter = global.Retry.Jitter
+	}
+	p.JitterParsed = 0.2
+	if p.Jitter != nil {
+		p.JitterParsed = *p.Jitter
+	}
+	if p.JitterParsed < 0 || p.JitterParsed > 1 {
+		return fmt.Errorf("jitter must be between 0 and 1: %v", p.JitterParsed)
+	}
+
+	var err error
+	if p.BackoffParsed, err = ParseDuration(p.Backoff); err != nil {
+		return fmt.Errorf("invalid backoff: %v", err)
+	}
+	if p.MaxDelayParsed, err = ParseDuration(p.MaxDelay); err != nil {
+		return fmt.Errorf("invalid max_delay: %v", err)
+	}
+	if p.MaxAgeParsed, err = ParseDuration(p.MaxAge); err != nil {
+		return fmt.Errorf("invalid max_age: %v", err)
+	}
+	if p.MaxDelayParsed < p.BackoffParsed {
+		return fmt.Errorf("max_delay (%s) must not be less than backoff (%s)", p.MaxDelay, p.Backoff)
+	}
+	return nil
+}
+
+// initTargetLogExtractor 校验并编译 TargetLog 的 IP 提取配置
+func initTargetLogExtractor(lf *TargetLog) error {
+	if !IsValidLogFormat(lf.Format) {
+		return fmt.Errorf("unsupported format: %s", lf.Format)
+	}
+	lf.Format = strings.ToLower(lf.Format)
+	if lf.Format != "" && lf.Format != LogFormatText {
+		// 结构化格式通过 ip_keys 指定 IP 字段
+		if lf.IPRegex != "" || lf.IPField != 0 {
+			return fmt.Errorf("ip_regex and ip_field are only supported with text format, use ip_keys instead")
+		}
+		if len(lf.IPKeys) == 0 {
+			lf.IPKeys = defaultIPKeys[lf.Format]
+		}
+		return nil
+	}
+	if len(lf.IPKeys) > 0 {
+		return fmt.Errorf("ip_keys is only supported with structured formats")
+	}
+	if lf.IPRegex != "" && lf.IPField != 0 {
+		return fmt.Errorf("can only specify one of: ip_regex or ip_field")
+	}
+	if lf.IPRegex == "" {
+		return nil
+	}
+	re, err := regexp.Compile(lf.IPRegex)
+	if err != nil {
+		return fmt.Errorf("invalid ip_regex: %v", err)
+	}
+	hasNamed := false
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			hasNamed = true
+			break
+		}
+	}
+	if !hasNamed {
+		return fmt.Errorf("ip_regex must contain at least one named capture group, e.g. (?P<client>\\S+)")
+	}
+	lf.IPRegexParsed = re
+	return nil
+}
+
 // watchConfigFile 监控配置文件变更并自动重载（带防抖）
 func watchConfigFile() {
 	watcher, err := fsnotify.NewWatcher()
@@ -199,8 +580,10 @@ func watchConfigFile() {
 					logrus.Info("Config file changed, reloading...")
 					err := initAPP()
 					if err != nil {
+						MetricConfigReloads.Inc("failure")
 						logrus.Errorf("Failed to reload config: %v", err)
 					} else {
+						MetricConfigReloads.Inc("success")
 						logrus.Info("Config reloaded successfully")
 					}
 				})
@@ -213,3 +596,89 @@ func watchConfigFile() {
 		}
 	}
 }
+
+// initActionConfig 校验 Action 配置并解析命令模板
+func initActionConfig(action *Action) error {
+	if action.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	action.Type = strings.ToLower(action.Type)
+	cmds, ok := actionCommandDefaults[action.Type]
+	if !ok {
+		return fmt.Errorf("unsupported type: %s (nftables, ipset, iptables, command)", action.Type)
+	}
+	if action.BanCommand == "" {
+		action.BanCommand = cmds.ban
+	}
+	if action.UnbanCommand == "" {
+		action.UnbanCommand = cmds.unban
+	}
+	if action.BanCommand == "" {
+		return fmt.Errorf("ban_command is required for type %s", action.Type)
+	}
+	if action.Table == "" {
+		action.Table = "inet filter"
+	}
+	if action.Set == "" {
+		action.Set = "iplog_ban"
+	}
+	if action.Set6 == "" {
+		action.Set6 = action.Set + "6"
+	}
+	if action.Chain == "" {
+		action.Chain = "INPUT"
+	}
+
+	var err error
+	if action.TTLParsed, err = ParseDuration(action.TTL); err != nil {
+		return fmt.Errorf("invalid ttl: %v", err)
+	}
+	if action.TimeoutParsed, err = ParseDuration(action.Timeout); err != nil {
+		return fmt.Errorf("invalid timeout: %v", err)
+	}
+	if action.BanTmpl, err = template.New("ban").Parse(action.BanCommand); err != nil {
+		return fmt.Errorf("invalid ban_command: %v", err)
+	}
+	action.UnbanTmpl = nil
+	if action.UnbanCommand != "" {
+		if action.UnbanTmpl, err = template.New("unban").Parse(action.UnbanCommand); err != nil {
+			return fmt.Errorf("invalid unban_command: %v", err)
+		}
+	}
+	return nil
+}
+
+// initAPIServerConfig 校验 API 服务器的认证、TLS 与 Unix Socket 配置
+func initAPIServerConfig(api *APIServer) error {
+	seen := make(map[string]bool)
+	for i := range api.Tokens {
+		token := &api.Tokens[i]
+		if token.Token == "" {
+			return fmt.Errorf("tokens[%d]: token is required", i)
+		}
+		if seen[token.Token] {
+			return fmt.Errorf("tokens[%d]: duplicate token", i)
+		}
+		seen[token.Token] = true
+		if token.Name == "" {
+			token.Name = fmt.Sprintf("token#%d", i)
+		}
+		token.Scope = strings.ToLower(token.Scope)
+		if token.Scope == "" {
+			token.Scope = APIScopeRead
+		}
+		if token.Scope != APIScopeRead && token.Scope != APIScopeAdmin {
+			return fmt.Errorf("tokens[%d]: unsupported scope: %s (read, admin)", i, token.Scope)
+		}
+	}
+	if (api.TLSCert == "") != (api.TLSKey == "") {
+		return fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	if api.ClientCA != "" && api.TLSCert == "" {
+		return fmt.Errorf("client_ca requires tls_cert and tls_key")
+	}
+	if _, err := strconv.ParseUint(api.SocketMode, 8, 32); err != nil {
+		return fmt.Errorf("invalid socket_mode: %s", api.SocketMode)
+	}
+	return nil
+}
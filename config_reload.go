@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 重载配置时对比新旧配置，只重启发生变化的 IP 列表加载器与目标日志处理器：
+// 未变化的列表不会重新下载，未变化的日志不会重新读取；
+// 命中统计 (NotificationMap)、冷却状态、摘要缓冲区与待发送队列在重载间保留。
+
+// reloadMutex 串行化配置的加载与重载 (防抖后的多次重载可能并发触发)
+var reloadMutex sync.Mutex
+
+// listLoader 运行中的 IP 列表加载器 (file/url 列表包含周期更新 goroutine)
+type listLoader struct {
+	list   IPList
+	cancel context.CancelFunc
+}
+
+// 运行中的 IP 列表加载器: 列表类型 (safe_list / risk_list) -> 列表名称 -> 加载器
+var listLoaders = map[string]map[string]*listLoader{
+	"safe_list": {},
+	"risk_list": {},
+}
+
+// targetProcessor 运行中的目标日志处理器
+type targetProcessor struct {
+	log    TargetLog
+	cancel context.CancelFunc
+	done   chan struct{} // 处理器退出 (tail 模式已保存 checkpoint) 后关闭
+}
+
+// 运行中的目标日志处理器: 日志名称 -> 处理器
+var targetProcessors = make(map[string]*targetProcessor)
+
+// reloadStats 一次加载/重载中启动与停止的组件数
+type reloadStats struct {
+	ListsStarted   int
+	ListsStopped   int
+	TargetsStarted int
+	TargetsStopped int
+}
+
+// reconcileIPLists 对比配置与运行中的加载器：停止已删除或已修改的列表，启动新增或已修改的列表
+// 返回已删除的列表名称，由调用方在新列表首次加载完成后一次性从 data 中移除；
+// 已修改的列表在新数据加载完成前继续使用旧数据。wg 用于等待新启动列表的首次加载 (调用方需持有 reloadMutex)
+func reconcileIPLists(ctx context.Context, lists []IPList, data *ListGroup, listType string, wg *sync.WaitGroup, stats *reloadStats) (removed []string) {
+	loaders := listLoaders[listType]
+	wanted := make(map[string]IPList, len(lists))
+	for _, list := range lists {
+		wanted[list.Name] = list
+	}
+
+	for name, loader := range loaders {
+		list, ok := wanted[name]
+		if ok && reflect.DeepEqual(list, loader.list) {
+			continue
+		}
+		loader.cancel()
+		delete(loaders, name)
+		stats.ListsStopped++
+		if !ok {
+			removed = append(removed, name)
+			deleteListState(listType, name)
+			deleteListMetrics(listType, name)
+			logrus.Infof("Removing IP list [%s] %s", listType, name)
+		}
+	}
+
+	for _, list := range lists {
+		if loaders[list.Name] != nil {
+			continue
+		}
+		listCtx, cancel := context.WithCancel(ctx)
+		loaders[list.Name] = &listLoader{list: list, cancel: cancel}
+		LoadIPList(listCtx, []IPList{list}, data, listType, wg, false)
+		stats.ListsStarted++
+	}
+	return removed
+}
+
+// reconcileTargetLogs 对比配置与运行中的处理器：停止已删除或已修改的日志处理器，启动新增或已修改的日志
+// 停止 tail 处理器时等待其保存 checkpoint，重新启动后从 checkpoint 继续读取 (调用方需持有 reloadMutex)
+func reconcileTargetLogs(ctx context.Context, logs []TargetLog, stats *reloadStats) {
+	wanted := make(map[string]TargetLog, len(logs))
+	for _, lf := range logs {
+		wanted[lf.Name] = lf
+	}
+
+	for name, p := range targetProcessors {
+		lf, ok := wanted[name]
+		if ok && sameTargetLog(lf, p.log) {
+			continue
+		}
+		p.cancel()
+		<-p.done
+		delete(targetProcessors, name)
+		stats.TargetsStopped++
+	}
+
+	for _, lf := range logs {
+		if targetProcessors[lf.Name] != nil {
+			continue
+		}
+		startTargetProcessor(ctx, lf)
+		stats.TargetsStarted++
+	}
+}
+
+// sameTargetLog 判断两个目标日志配置是否相同 (编译后的正则不参与比较, 由 ip_regex 决定)
+func sameTargetLog(a, b TargetLog) bool {
+	a.IPRegexParsed, b.IPRegexParsed = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+// startTargetProcessor 启动单个目标日志处理器 (调用方需持有 reloadMutex)
+func startTargetProcessor(ctx context.Context, lf TargetLog) {
+	ctx, cancel := context.WithCancel(ctx)
+	p := &targetProcessor{log: lf, cancel: cancel, done: make(chan struct{})}
+	targetProcessors[lf.Name] = p
+
+	if lf.ReadMode == "tail" {
+		tailProcessorsWG.Add(1)
+	}
+	go func() {
+		defer close(p.done)
+		if lf.ReadMode == "once" {
+			processOnceMode(ctx, lf)
+		} else if lf.ReadMode == "tail" {
+			defer tailProcessorsWG.Done()
+			processTailMode(ctx, lf)
+		} else {
+			logrus.Errorf("Unknown read_mode for %s: %s", lf.Name, lf.ReadMode)
+		}
+	}()
+}
+
+// refreshPendingNotifications 将待发送队列中通知的通知项配置替换为重载后的配置
+// 通知项已从配置中删除的通知被丢弃 (与启动时恢复队列的处理一致)
+func refreshPendingNotifications(services []Notification) {
+	notifByID := make(map[string]Notification, len(services))
+	for _, notif := range services {
+		notifByID[notif.ID] = notif
+	}
+
+	PendingNotificationsMutex.Lock()
+	defer PendingNotificationsMutex.Unlock()
+	kept := PendingNotifications[:0]
+	for _, pn := range PendingNotifications {
+		notif, ok := notifByID[pn.Notif.ID]
+		if !ok {
+			logrus.Warnf("Dropping queued notification for IP %s: notification [%s] no longer configured", pn.Data.IP, pn.Notif.ID)
+			MetricNotificationsDropped.Inc(pn.Notif.ID, pn.Notif.Service, DropReasonServiceRemoved)
+			NotificationQueueStore.Done(pn)
+			continue
+		}
+		pn.Notif = notif
+		kept = append(kept, pn)
+	}
+	PendingNotifications = kept
+}
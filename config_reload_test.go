@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetReloadState 使用空的加载器与处理器表，测试结束后停止启动的组件并恢复
+func resetReloadState(t *testing.T) context.Context {
+	t.Helper()
+	prevLoaders, prevTargets := listLoaders, targetProcessors
+	listLoaders = map[string]map[string]*listLoader{"safe_list": {}, "risk_list": {}}
+	targetProcessors = make(map[string]*targetProcessor)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		for _, p := range targetProcessors {
+			<-p.done
+		}
+		listLoaders, targetProcessors = prevLoaders, prevTargets
+	})
+	return ctx
+}
+
+func TestReconcileIPLists(t *testing.T) {
+	ctx := resetReloadState(t)
+	resetListStates(t)
+
+	data := NewListGroup()
+	initial := []IPList{
+		{Name: "unchanged", IPs: []string{"192.0.2.1"}, Level: 1},
+		{Name: "changed", IPs: []string{"192.0.2.2"}, Level: 1},
+		{Name: "removed", IPs: []string{"192.0.2.3"}, Level: 1},
+	}
+	var stats reloadStats
+	if removed := reconcileIPLists(ctx, initial, data, "risk_list", nil, &stats); len(removed) != 0 {
+		t.Fatalf("removed on first load: %v", removed)
+	}
+	if stats != (reloadStats{ListsStarted: 3}) {
+		t.Fatalf("first load stats = %+v", stats)
+	}
+
+	// 记录各加载器是否被停止
+	cancelled := make(map[string]bool)
+	loaders := listLoaders["risk_list"]
+	for name, loader := range loaders {
+		name, cancel := name, loader.cancel
+		loader.cancel = func() {
+			cancelled[name] = true
+			cancel()
+		}
+	}
+	unchangedLoader, changedLoader := loaders["unchanged"], loaders["changed"]
+	recordListUpdate("risk_list", IPList{Name: "removed", File: "removed.txt"}, nil)
+	observeListUpdate("risk_list", "removed", func() error { return nil })
+
+	updated := []IPList{
+		{Name: "unchanged", IPs: []string{"192.0.2.1"}, Level: 1},
+		{Name: "changed", IPs: []string{"198.51.100.2"}, Level: 2},
+		{Name: "added", IPs: []string{"192.0.2.4"}, Level: 1},
+	}
+	stats = reloadStats{}
+	removed := reconcileIPLists(ctx, updated, data, "risk_list", nil, &stats)
+	if !reflect.DeepEqual(removed, []string{"removed"}) {
+		t.Fatalf("removed = %v", removed)
+	}
+	if stats != (reloadStats{ListsStarted: 2, ListsStopped: 2}) {
+		t.Fatalf("reload stats = %+v", stats)
+	}
+	if !reflect.DeepEqual(cancelled, map[string]bool{"changed": true, "removed": true}) {
+		t.Fatalf("stopped loaders = %v", cancelled)
+	}
+	if loaders["unchanged"] != unchangedLoader {
+		t.Fatal("unchanged list restarted")
+	}
+	if loaders["changed"] == changedLoader || !reflect.DeepEqual(loaders["changed"].list, updated[1]) {
+		t.Fatal("changed list not restarted with the new configuration")
+	}
+	if loaders["removed"] != nil || loaders["added"] == nil {
+		t.Fatalf("loaders after reload: %v", loaders)
+	}
+
+	// 已删除列表的数据由调用方移除，状态与指标立即删除
+	if ok, _ := data.Contains(netip.MustParseAddr("192.0.2.3")); !ok {
+		t.Fatal("removed list data dropped before the caller removes it")
+	}
+	ListStatesMutex.Lock()
+	_, hasState := ListStates[listStateKey{Type: "risk_list", Name: "removed"}]
+	ListStatesMutex.Unlock()
+	if hasState {
+		t.Fatal("state of removed list kept")
+	}
+	if strings.Contains(metricText(MetricListUpdates), `list="removed"`) {
+		t.Fatal("metrics of removed list kept")
+	}
+	if ok, info := data.Contains(netip.MustParseAddr("198.51.100.2")); !ok || info.Level != 2 {
+		t.Fatalf("changed list not reloaded: %v %v", ok, info)
+	}
+	if ok, _ := data.Contains(netip.MustParseAddr("192.0.2.2")); ok {
+		t.Fatal("old data of changed list still loaded")
+	}
+	if ok, _ := data.Contains(netip.MustParseAddr("192.0.2.4")); !ok {
+		t.Fatal("added list not loaded")
+	}
+
+	// 配置完全相同时不重启任何列表
+	stats = reloadStats{}
+	if removed := reconcileIPLists(ctx, updated, data, "risk_list", nil, &stats); len(removed) != 0 || stats != (reloadStats{}) {
+		t.Fatalf("identical reload: removed %v, stats %+v", removed, stats)
+	}
+}
+
+// linesProcessed 返回目标日志已处理的行数
+func linesProcessed(name string) string {
+	prefix := fmt.Sprintf(`iplog_lines_processed_total{log="%s"} `, name)
+	for _, line := range strings.Split(metricText(MetricLinesProcessed), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimPrefix(line, prefix)
+		}
+	}
+	return "0"
+}
+
+func TestReconcileTargetLogs(t *testing.T) {
+	setTestConfig(t, Config{DataDir: t.TempDir()})
+	ctx := resetReloadState(t)
+	dir := t.TempDir()
+
+	// 每个日志先写入偏移 0 的 checkpoint，从文件开头读取
+	newLog := func(name string) TargetLog {
+		lf := TargetLog{Name: name, Path: filepath.Join(dir, name+".log"), ReadMode: "tail", Watch: TailWatchPoll, CheckpointParsed: 20 * time.Millisecond}
+		if err := os.WriteFile(lf.Path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		info, _ := os.Stat(lf.Path)
+		if err := saveCheckpoint(lf, TailCheckpoint{Path: lf.Path, Inode: fileInode(info)}); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { MetricLinesProcessed.DeleteLabelValues(name) })
+		return lf
+	}
+	waitLines := func(name, want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for linesProcessed(name) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("log %s: processed %s lines, want %s", name, linesProcessed(name), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	keep, change, gone := newLog("keep"), newLog("change"), newLog("gone")
+	keep.IPRegex = `\d+\.\d+\.\d+\.\d+`
+	keep.IPRegexParsed = regexp.MustCompile(keep.IPRegex)
+
+	var stats reloadStats
+	reconcileTargetLogs(ctx, []TargetLog{keep, change, gone}, &stats)
+	if stats != (reloadStats{TargetsStarted: 3}) {
+		t.Fatalf("first load stats = %+v", stats)
+	}
+	appendFile(t, keep.Path, "keep 1\nkeep 2\n")
+	appendFile(t, change.Path, "change 1\nchange 2\n")
+	waitLines("keep", "2")
+	waitLines("change", "2")
+
+	keepProc, changeProc, goneProc := targetProcessors["keep"], targetProcessors["change"], targetProcessors["gone"]
+	keepCP, err := loadCheckpoint(keep)
+	if err != nil || keepCP == nil {
+		t.Fatalf("no checkpoint for keep: %v", err)
+	}
+
+	// 修改 change (忽略编译后的正则)、删除 gone、新增 added
+	changed := change
+	changed.CheckpointParsed = 30 * time.Millisecond
+	unchanged := keep
+	unchanged.IPRegexParsed = regexp.MustCompile(keep.IPRegex)
+	added := newLog("added")
+	stats = reloadStats{}
+	reconcileTargetLogs(ctx, []TargetLog{unchanged, changed, added}, &stats)
+	if stats != (reloadStats{TargetsStarted: 2, TargetsStopped: 2}) {
+		t.Fatalf("reload stats = %+v", stats)
+	}
+
+	// 未变化的日志保留原 tail goroutine
+	if targetProcessors["keep"] != keepProc {
+		t.Fatal("unchanged target log restarted")
+	}
+	select {
+	case <-keepProc.done:
+		t.Fatal("tail goroutine of unchanged target log stopped")
+	default:
+	}
+	// 已修改与已删除的日志在返回前停止
+	for name, p := range map[string]*targetProcessor{"change": changeProc, "gone": goneProc} {
+		select {
+		case <-p.done:
+		default:
+			t.Fatalf("processor %s still running after reload", name)
+		}
+	}
+	if targetProcessors["gone"] != nil || targetProcessors["added"] == nil || targetProcessors["change"] == changeProc {
+		t.Fatalf("processors after reload: %v", targetProcessors)
+	}
+	names := make([]string, 0, len(targetProcessors))
+	for name := range targetProcessors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"added", "change", "keep"}) {
+		t.Fatalf("running processors = %v", names)
+	}
+
+	// 未变化的日志继续读取新行，checkpoint 未被重置
+	if cp, _ := loadCheckpoint(keep); cp == nil || cp.Offset < keepCP.Offset || cp.Inode != keepCP.Inode {
+		t.Fatalf("checkpoint of unchanged log reset: %+v, before %+v", cp, keepCP)
+	}
+	appendFile(t, keep.Path, "keep 3\n")
+	waitLines("keep", "3")
+
+	// 重新启动的日志从 checkpoint 继续，不重复读取已处理的行
+	appendFile(t, change.Path, "change 3\n")
+	waitLines("change", "3")
+	appendFile(t, added.Path, "added 1\n")
+	waitLines("added", "1")
+	time.Sleep(100 * time.Millisecond)
+	if got := linesProcessed("change"); got != "3" {
+		t.Fatalf("restarted log re-read lines: processed %s", got)
+	}
+}
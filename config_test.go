@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// initTestNotifications 初始化通知项配置，返回 url -> 通知项标识
+func initTestNotifications(t *testing.T, services ...Notification) map[string]string {
+	t.Helper()
+	ids := make(map[string]string)
+	for i := range services {
+		if err := initNotificationConfig(&services[i], &Notifications{}); err != nil {
+			t.Fatalf("init notification: %v", err)
+		}
+		ids[services[i].Config["url"].(string)] = services[i].ID
+	}
+	return ids
+}
+
+func TestNotificationIDStableAcrossReorder(t *testing.T) {
+	webhookA := Notification{Service: "webhook", Config: map[string]any{"url": "http://a.example.com/hook"}}
+	webhookB := Notification{Service: "webhook", Config: map[string]any{"url": "http://b.example.com/hook"}}
+	slack := Notification{Service: "slack", Config: map[string]any{"url": "http://slack.example.com", "token": "t"}}
+
+	before := initTestNotifications(t, slack, webhookA, webhookB)
+	after := initTestNotifications(t, webhookB, webhookA)
+	for url, id := range after {
+		if before[url] != id {
+			t.Fatalf("%s: id changed from %s to %s after reorder", url, before[url], id)
+		}
+		if !strings.HasPrefix(id, "webhook#") {
+			t.Fatalf("unexpected id %s", id)
+		}
+	}
+	if after["http://a.example.com/hook"] == after["http://b.example.com/hook"] {
+		t.Fatal("different configs must get different ids")
+	}
+
+	named := webhookA
+	named.Name = "ops"
+	if id := initTestNotifications(t, named)["http://a.example.com/hook"]; id != "ops" {
+		t.Fatalf("named notification id = %s, want ops", id)
+	}
+
+	cfg := Config{
+		Notifications: Notifications{Services: []Notification{webhookA, webhookA}},
+	}
+	if err := initAppConfig(&cfg); err == nil || !strings.Contains(err.Error(), "set name") {
+		t.Fatalf("expected duplicate error for identical unnamed notifications, got %v", err)
+	}
+}
+
+// setTestConfig 在测试期间替换全局配置，测试结束后恢复
+func setTestConfig(t *testing.T, c Config) {
+	t.Helper()
+	configMutex.Lock()
+	prev := config
+	config = c
+	configMutex.Unlock()
+	t.Cleanup(func() {
+		configMutex.Lock()
+		config = prev
+		configMutex.Unlock()
+	})
+}
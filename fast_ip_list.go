@@ -1,8 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"net/netip"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 // countCIDRNodes 递归统计 CIDR trie 树中的节点数（即 CIDR 条目数）
@@ -19,10 +23,11 @@ func countCIDRNodes(node *CIDRNode) int {
 	return count
 }
 
-// NetList 存储单 IP 和 CIDR 的混合列表
+// NetList 存储单 IP 和 CIDR 的混合列表 (同时支持 IPv4 与 IPv6)
 type NetList struct {
-	ips      map[uint32]struct{} // HASH Set 存储精确 IP
-	cidrRoot *CIDRNode
+	ips       map[netip.Addr]struct{} // HASH Set 存储精确 IP
+	cidrRoot4 *CIDRNode               // IPv4 CIDR 字典树
+	cidrRoot6 *CIDRNode               // IPv6 CIDR 字典树
 }
 
 // CIDRNode 字典树节点
@@ -38,43 +43,70 @@ type ListInfo struct {
 }
 
 // ListGroup 管理多个 NetList
+// 读取使用不可变快照 (无锁)；写入时复制映射、修改后原子替换，
+// 因此查询要么看到一次更新前的全部列表，要么看到更新后的全部列表，不会看到更新到一半的状态
 type ListGroup struct {
-	mu      sync.RWMutex
-	AllList map[ListInfo]*NetList
+	mu       sync.Mutex                            // 串行化写入 (复制-修改-替换)
+	snapshot atomic.Pointer[map[ListInfo]*NetList] // 当前快照 (替换后不再修改)
+}
+
+// addrBit 返回地址第 i 位 (从最高位开始计数) 的值，用于 Trie 查找
+func addrBit(b []byte, i int) byte {
+	return (b[i/8] >> (7 - i%8)) & 1
 }
 
 // NewNetList 创建新的 NetList
-func NewNetList(ips []uint32, cidrs []netip.Prefix) *NetList {
+// IPv4-mapped IPv6 地址 (::ffff:a.b.c.d) 会被统一为 IPv4 存储
+func NewNetList(ips []netip.Addr, cidrs []netip.Prefix) *NetList {
 	nl := &NetList{
-		ips:      make(map[uint32]struct{}),
-		cidrRoot: &CIDRNode{},
+		ips:       make(map[netip.Addr]struct{}),
+		cidrRoot4: &CIDRNode{},
+		cidrRoot6: &CIDRNode{},
 	}
 
-	for _, ip32 := range ips {
-		nl.ips[ip32] = struct{}{}
+	for _, ip := range ips {
+		if ip.IsValid() {
+			nl.ips[ip.Unmap()] = struct{}{}
+		}
 	}
 
 	for _, prefix := range cidrs {
-		// ipToUint32 将 netip.Addr 转换为 uint32 (大端序逻辑适配 Trie)
-		if prefix.IsValid() && prefix.Addr().Is4() {
-			addr := prefix.Addr()
-			bits := prefix.Bits()
-			node := nl.cidrRoot
-			ip32 := ipToUint32(addr)
-			for i := 31; i >= 32-bits; i-- {
-				bit := (ip32 >> i) & 1
-				if node.children[bit] == nil {
-					node.children[bit] = &CIDRNode{}
-				}
-				node = node.children[bit]
+		prefix = normalizePrefix(prefix)
+		if !prefix.IsValid() {
+			continue
+		}
+		node := nl.cidrRoot6
+		if prefix.Addr().Is4() {
+			node = nl.cidrRoot4
+		}
+		b := prefix.Addr().AsSlice()
+		for i := 0; i < prefix.Bits(); i++ {
+			bit := addrBit(b, i)
+			if node.children[bit] == nil {
+				node.children[bit] = &CIDRNode{}
 			}
-			node.end = true
+			node = node.children[bit]
 		}
+		node.end = true
 	}
 
 	return nl
 }
 
+// normalizePrefix 规范化 CIDR：去掉主机位，并将 IPv4-mapped IPv6 前缀转换为 IPv4 前缀
+func normalizePrefix(prefix netip.Prefix) netip.Prefix {
+	if !prefix.IsValid() {
+		return prefix
+	}
+	addr := prefix.Addr().WithZone("")
+	bits := prefix.Bits()
+	if addr.Is4In6() && bits >= 96 {
+		addr = addr.Unmap()
+		bits -= 96
+	}
+	return netip.PrefixFrom(addr, bits).Masked()
+}
+
 // NewNetListInfo 创建新的 NetListInfo
 func NewNetListInfo(name string, level int) ListInfo {
 	return ListInfo{
@@ -85,64 +117,107 @@ func NewNetListInfo(name string, level int) ListInfo {
 
 // NewListGroup 创建新的 ListGroup
 func NewListGroup() *ListGroup {
-	return &ListGroup{
-		AllList: make(map[ListInfo]*NetList),
+	lg := &ListGroup{}
+	lists := make(map[ListInfo]*NetList)
+	lg.snapshot.Store(&lists)
+	return lg
+}
+
+// lists 返回当前快照 (只读, 不得修改)
+func (lg *ListGroup) lists() map[ListInfo]*NetList {
+	return *lg.snapshot.Load()
+}
+
+// update 复制当前快照，由 fn 修改副本后原子替换 (线程安全)
+func (lg *ListGroup) update(fn func(lists map[ListInfo]*NetList)) {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	current := lg.lists()
+	next := make(map[ListInfo]*NetList, len(current)+1)
+	for info, nl := range current {
+		next[info] = nl
 	}
+	fn(next)
+	lg.snapshot.Store(&next)
 }
 
 // Contains 检查 IP 是否在列表中
-func (nl *NetList) Contains(ip uint32) bool {
+func (nl *NetList) Contains(ip netip.Addr) bool {
+	if !ip.IsValid() {
+		return false
+	}
+	ip = ip.WithZone("").Unmap()
+
 	// 先检查精确 IP
 	if _, ok := nl.ips[ip]; ok {
 		return true
 	}
 
 	// tire 查找 cidr
-	node := nl.cidrRoot
-	matched := false
-	for i := 31; i >= 0; i-- {
+	node := nl.cidrRoot6
+	if ip.Is4() {
+		node = nl.cidrRoot4
+	}
+	b := ip.AsSlice()
+	for i := 0; i < ip.BitLen(); i++ {
 		if node == nil {
-			break
+			return false
 		}
 		if node.end {
-			matched = true
+			return true
 		}
-		bit := (ip >> i) & 1
-		node = node.children[bit]
+		node = node.children[addrBit(b, i)]
 	}
-	if node != nil && node.end {
-		matched = true
-	}
-	return matched
+	return node != nil && node.end
 }
 
-// AddList 添加新的 NetList 到 ListGroup (线程安全)
-func (lg *ListGroup) AddList(info ListInfo, ips []uint32, cidrs []netip.Prefix) {
+// AddList 添加新的 NetList 到 ListGroup，同名列表 (无论等级) 在同一次替换中被覆盖 (线程安全)
+// NetList 在替换前构建完成，查询不会看到列表缺失或只加载了一部分的状态
+func (lg *ListGroup) AddList(info ListInfo, ips []netip.Addr, cidrs []netip.Prefix) {
 	nl := NewNetList(ips, cidrs)
-	lg.mu.Lock()
-	lg.AllList[info] = nl
-	lg.mu.Unlock()
+	lg.update(func(lists map[ListInfo]*NetList) {
+		for k := range lists {
+			if k.Name == info.Name {
+				delete(lists, k)
+			}
+		}
+		lists[info] = nl
+	})
 }
 
-// DelList 从 ListGroup 中删除指定名称的 NetList (线程安全)
-func (lg *ListGroup) DelList(name string) {
-	lg.mu.Lock()
-	defer lg.mu.Unlock()
-	for k := range lg.AllList {
-		if k.Name == name {
-			delete(lg.AllList, k)
-			return
+// DelList 从 ListGroup 中删除指定名称的 NetList，多个名称在同一次替换中删除 (线程安全)
+func (lg *ListGroup) DelList(names ...string) {
+	if len(names) == 0 {
+		return
+	}
+	remove := make(map[string]bool, len(names))
+	for _, name := range names {
+		remove[name] = true
+	}
+	lg.update(func(lists map[ListInfo]*NetList) {
+		for k := range lists {
+			if remove[k.Name] {
+				delete(lists, k)
+			}
 		}
+	})
+}
+
+// Names 返回所有列表的名称 (线程安全, 按名称排序)
+func (lg *ListGroup) Names() []string {
+	lists := lg.lists()
+	names := make([]string, 0, len(lists))
+	for info := range lists {
+		names = append(names, info.Name)
 	}
+	sort.Strings(names)
+	return names
 }
 
 // Contains 检查 IP 是否在任何 NetList 中 (线程安全)
 // 顺序检查所有 NetList，map+trie 查找本身极快，无需 goroutine 开销
-func (lg *ListGroup) Contains(ip uint32) (bool, ListInfo) {
-	lg.mu.RLock()
-	defer lg.mu.RUnlock()
-
-	for info, nl := range lg.AllList {
+func (lg *ListGroup) Contains(ip netip.Addr) (bool, ListInfo) {
+	for info, nl := range lg.lists() {
 		if nl.Contains(ip) {
 			return true, info
 		}
@@ -150,18 +225,108 @@ func (lg *ListGroup) Contains(ip uint32) (bool, ListInfo) {
 	return false, ListInfo{}
 }
 
+// Lookup 返回包含该 IP 的所有 NetList 信息 (线程安全)
+// 结果按等级从高到低排序，等级相同时按名称排序，保证每次调用结果顺序一致
+func (lg *ListGroup) Lookup(ip netip.Addr) []ListInfo {
+	var matches []ListInfo
+	for info, nl := range lg.lists() {
+		if nl.Contains(ip) {
+			matches = append(matches, info)
+		}
+	}
+	sortListInfos(matches)
+	return matches
+}
+
+// sortListInfos 按等级降序、名称升序排序
+func sortListInfos(infos []ListInfo) {
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Level != infos[j].Level {
+			return infos[i].Level > infos[j].Level
+		}
+		return infos[i].Name < infos[j].Name
+	})
+}
+
+// formatListInfos 将列表信息格式化为 "name(level), ..." 形式，用于日志输出
+func formatListInfos(infos []ListInfo) string {
+	parts := make([]string, 0, len(infos))
+	for _, info := range infos {
+		parts = append(parts, fmt.Sprintf("%s(%d)", info.Name, info.Level))
+	}
+	return strings.Join(parts, ", ")
+}
+
 // Stats 返回统计信息：总条目数和每个列表的条目数 (线程安全)
 func (lg *ListGroup) Stats() (totalCount int, perList map[string]int) {
-	lg.mu.RLock()
-	defer lg.mu.RUnlock()
-
 	perList = make(map[string]int)
-	for info, nl := range lg.AllList {
+	for info, nl := range lg.lists() {
 		ipCount := len(nl.ips)
-		cidrCount := countCIDRNodes(nl.cidrRoot)
+		cidrCount := countCIDRNodes(nl.cidrRoot4) + countCIDRNodes(nl.cidrRoot6)
 		total := ipCount + cidrCount
 		totalCount += total
 		perList[info.Name] = total
 	}
 	return
 }
+
+// Prefixes 返回 NetList 中的所有条目 (单 IP 以 /32 或 /128 前缀表示)
+// 已被上层 CIDR 覆盖的子 CIDR 不再重复返回
+func (nl *NetList) Prefixes() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(nl.ips))
+	for ip := range nl.ips {
+		prefixes = append(prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+	var v4 [4]byte
+	collectCIDRPrefixes(nl.cidrRoot4, v4[:], 0, &prefixes)
+	var v6 [16]byte
+	collectCIDRPrefixes(nl.cidrRoot6, v6[:], 0, &prefixes)
+	return prefixes
+}
+
+// collectCIDRPrefixes 深度优先遍历 CIDR 字典树，将终止节点还原为前缀
+func collectCIDRPrefixes(node *CIDRNode, b []byte, depth int, out *[]netip.Prefix) {
+	if node == nil {
+		return
+	}
+	if node.end {
+		addr, _ := netip.AddrFromSlice(b)
+		*out = append(*out, netip.PrefixFrom(addr, depth))
+		return
+	}
+	for bit := 0; bit < 2; bit++ {
+		if node.children[bit] == nil {
+			continue
+		}
+		if bit == 1 {
+			b[depth/8] |= 1 << (7 - depth%8)
+		}
+		collectCIDRPrefixes(node.children[bit], b, depth+1, out)
+		b[depth/8] &^= 1 << (7 - depth%8)
+	}
+}
+
+// Prefixes 返回指定名称的列表中的所有条目 (线程安全)
+// names 为空时返回所有列表；存在未知的列表名称时返回错误
+func (lg *ListGroup) Prefixes(names []string) ([]netip.Prefix, error) {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = false
+	}
+	var prefixes []netip.Prefix
+	for info, nl := range lg.lists() {
+		if len(names) > 0 {
+			if _, ok := wanted[info.Name]; !ok {
+				continue
+			}
+			wanted[info.Name] = true
+		}
+		prefixes = append(prefixes, nl.Prefixes()...)
+	}
+	for _, name := range names {
+		if !wanted[name] {
+			return nil, fmt.Errorf("unknown list: %s", name)
+		}
+	}
+	return prefixes, nil
+}
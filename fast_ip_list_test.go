@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestNetListContains(t *testing.T) {
+	ips := []netip.Addr{
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("2001:db8::1"),
+		netip.MustParseAddr("::ffff:198.51.100.1"), // 映射地址按 IPv4 存储
+	}
+	cidrs := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("10.1.0.0/16"),   // 与 10.0.0.0/8 重叠
+		netip.MustParsePrefix("172.16.5.9/12"), // 主机位被清除
+		netip.MustParsePrefix("203.0.113.7/32"),
+		netip.MustParsePrefix("2001:db8:1::/48"),
+		netip.MustParsePrefix("2001:db8:1:2::/64"), // 与 /48 重叠
+		netip.MustParsePrefix("fe80::/10"),
+		netip.MustParsePrefix("2001:db8:ffff::5/128"),
+		netip.MustParsePrefix("::ffff:100.64.0.0/106"), // 映射前缀按 IPv4 100.64.0.0/10 存储
+	}
+	nl := NewNetList(ips, cidrs)
+
+	cases := []struct {
+		ip   string
+		want bool
+	}{
+		{"192.0.2.1", true},
+		{"192.0.2.2", false},
+		{"::ffff:192.0.2.1", true}, // 映射地址查询 IPv4 条目
+		{"198.51.100.1", true},
+		{"2001:db8::1", true},
+		{"2001:db8::2", false},
+		{"10.200.0.1", true},
+		{"10.1.2.3", true},
+		{"11.0.0.0", false},
+		{"9.255.255.255", false},
+		{"172.31.255.255", true},
+		{"172.32.0.0", false},
+		{"203.0.113.7", true},
+		{"203.0.113.8", false},
+		{"::ffff:10.9.9.9", true},
+		{"::a00:1", false}, // IPv4 兼容地址 (非映射) 不匹配 IPv4 前缀
+		{"2001:db8:1:ffff::1", true},
+		{"2001:db8:1:2::1", true},
+		{"2001:db8:2::1", false},
+		{"fe80::1%eth0", true}, // zone 被忽略
+		{"febf:ffff::1", true},
+		{"fec0::1", false},
+		{"2001:db8:ffff::5", true},
+		{"2001:db8:ffff::6", false},
+		{"100.127.255.255", true},
+		{"100.128.0.0", false},
+	}
+	for _, c := range cases {
+		if got := nl.Contains(netip.MustParseAddr(c.ip)); got != c.want {
+			t.Errorf("Contains(%s) = %v, want %v", c.ip, got, c.want)
+		}
+	}
+	if nl.Contains(netip.Addr{}) {
+		t.Error("invalid address must not match")
+	}
+}
+
+func TestNetListDefaultRoutes(t *testing.T) {
+	v4 := NewNetList(nil, []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0")})
+	v6 := NewNetList(nil, []netip.Prefix{netip.MustParsePrefix("::/0")})
+	for _, c := range []struct {
+		nl   *NetList
+		ip   string
+		want bool
+	}{
+		{v4, "255.255.255.255", true},
+		{v4, "0.0.0.0", true},
+		{v4, "2001:db8::1", false},
+		{v4, "::ffff:1.2.3.4", true},
+		{v6, "2001:db8::1", true},
+		{v6, "::", true},
+		{v6, "1.2.3.4", false},
+	} {
+		if got := c.nl.Contains(netip.MustParseAddr(c.ip)); got != c.want {
+			t.Errorf("Contains(%s) = %v, want %v", c.ip, got, c.want)
+		}
+	}
+}
+
+func TestNetListPrefixes(t *testing.T) {
+	nl := NewNetList(
+		[]netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")},
+		[]netip.Prefix{netip.MustParsePrefix("10.1.0.0/16"), netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8:1::/48")},
+	)
+	got := make(map[netip.Prefix]bool)
+	for _, p := range nl.Prefixes() {
+		got[p] = true
+	}
+	// 10.1.0.0/16 已被 10.0.0.0/8 覆盖，不再返回
+	for _, want := range []string{"192.0.2.1/32", "2001:db8::1/128", "10.0.0.0/8", "2001:db8:1::/48"} {
+		if !got[netip.MustParsePrefix(want)] {
+			t.Errorf("Prefixes() missing %s: %v", want, nl.Prefixes())
+		}
+	}
+	if len(got) != 4 {
+		t.Errorf("Prefixes() = %v, want 4 entries", nl.Prefixes())
+	}
+}
+
+func TestListGroupLookup(t *testing.T) {
+	lg := NewListGroup()
+	lg.AddList(NewNetListInfo("low", 1), nil, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	lg.AddList(NewNetListInfo("high", 3), nil, []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")})
+	lg.AddList(NewNetListInfo("also-high", 3), []netip.Addr{netip.MustParseAddr("10.1.2.3")}, nil)
+
+	want := []ListInfo{{"also-high", 3}, {"high", 3}, {"low", 1}}
+	if got := lg.Lookup(netip.MustParseAddr("10.1.2.3")); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Lookup = %v, want %v", got, want)
+	}
+
+	// 同名列表 (不同等级) 被整体替换
+	lg.AddList(NewNetListInfo("low", 2), nil, []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")})
+	if got := lg.Lookup(netip.MustParseAddr("10.200.0.1")); len(got) != 0 {
+		t.Fatalf("replaced list still matches: %v", got)
+	}
+	if ok, info := lg.Contains(netip.MustParseAddr("192.0.2.9")); !ok || info != (ListInfo{"low", 2}) {
+		t.Fatalf("Contains = %v %v", ok, info)
+	}
+
+	lg.DelList("high", "also-high")
+	if got := lg.Names(); !reflect.DeepEqual(got, []string{"low"}) {
+		t.Fatalf("Names after DelList = %v", got)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// fileInode Windows 下不提供 inode，返回 0 (仅依赖文件大小判断截断)
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -1,4 +1,4 @@
-module main
+module github.com/xfzka/iplog_checker
 
 go 1.25.6
 
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// 查询结论
+const (
+	VerdictSafe  = "safe"  // 在安全列表中 (不会告警或封禁)
+	VerdictRisk  = "risk"  // 在风险列表中
+	VerdictClean = "clean" // 不在任何列表中
+)
+
+// maxBulkCheck 单次批量查询的最大 IP 数
+const maxBulkCheck = 1000
+
+// ListMatch 命中的列表
+type ListMatch struct {
+	Name  string `json:"name"`  // 列表名称
+	Level int    `json:"level"` // 列表等级
+}
+
+// CheckHits IP 在日志中的命中统计 (来自 NotificationMap, 通知触发后在 tail 模式下会被重置)
+type CheckHits struct {
+	Total    int            `json:"total"`     // 累计命中次数
+	PerLog   map[string]int `json:"per_log"`   // 各目标日志的累计命中次数
+	LastSeen time.Time      `json:"last_seen"` // 最近一次命中时间
+	LastLog  string         `json:"last_log"`  // 最近一次命中的日志
+}
+
+// CheckResult 单个 IP 的查询结果
+type CheckResult struct {
+	IP            string               `json:"ip"`                // 查询的 IP (规范化后)
+	Error         string               `json:"error,omitempty"`   // IP 无法解析时的错误信息
+	Verdict       string               `json:"verdict,omitempty"` // safe / risk / clean
+	SafeLists     []ListMatch          `json:"safe_lists"`        // 命中的安全列表
+	RiskLists     []ListMatch          `json:"risk_lists"`        // 命中的风险列表 (按等级从高到低排序)
+	Hits          *CheckHits           `json:"hits,omitempty"`    // 日志命中统计 (无命中时为空)
+	Notifications []RecentNotification `json:"notifications"`     // 最近触发的通知 (按时间从新到旧)
+	Bans          []BanRecord          `json:"bans,omitempty"`    // 生效中的封禁
+}
+
+// CheckIP 查询 IP 的安全/风险状态、日志命中统计、最近通知与封禁记录 (线程安全)
+func CheckIP(s string) CheckResult {
+	ip, err := ParseIP(s)
+	if err != nil {
+		return CheckResult{IP: s, Error: err.Error(), SafeLists: []ListMatch{}, RiskLists: []ListMatch{}, Notifications: []RecentNotification{}}
+	}
+	result := CheckResult{
+		IP:        ip.String(),
+		Verdict:   VerdictClean,
+		SafeLists: []ListMatch{},
+		RiskLists: []ListMatch{},
+	}
+
+	if SafeListData != nil {
+		for _, info := range SafeListData.Lookup(ip) {
+			result.SafeLists = append(result.SafeLists, ListMatch{Name: info.Name, Level: info.Level})
+		}
+	}
+	if RiskListData != nil {
+		for _, info := range RiskListData.Lookup(ip) {
+			result.RiskLists = append(result.RiskLists, ListMatch{Name: info.Name, Level: info.Level})
+		}
+	}
+	switch {
+	case len(result.SafeLists) > 0:
+		result.Verdict = VerdictSafe
+	case len(result.RiskLists) > 0:
+		result.Verdict = VerdictRisk
+	}
+
+	NotificationMapMutex.Lock()
+	if record := NotificationMap[ip]; record != nil {
+		hits := &CheckHits{
+			Total:    record.Total,
+			PerLog:   make(map[string]int, len(record.PerLog)),
+			LastSeen: time.Unix(record.Latest.Timestamp, 0),
+			LastLog:  record.Latest.SourceLogInfo.Name,
+		}
+		for name, count := range record.PerLog {
+			hits.PerLog[name] = count
+		}
+		result.Hits = hits
+	}
+	NotificationMapMutex.Unlock()
+
+	result.Notifications = RecentNotificationsFor(result.IP)
+
+	ActiveBansMutex.Lock()
+	for key, r := range ActiveBans {
+		if key.IP == ip {
+			result.Bans = append(result.Bans, *r)
+		}
+	}
+	ActiveBansMutex.Unlock()
+	sort.Slice(result.Bans, func(i, j int) bool { return result.Bans[i].Action < result.Bans[j].Action })
+	return result
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ListCacheMeta URL 列表磁盘缓存的元数据 (data_dir/list_cache/<type>/<name>.json)
+// 条目本身保存在同名的 .txt 文件中 (每行一个 IP 或 CIDR)
+type ListCacheMeta struct {
+	URL       string    `json:"url"`                  // 下载地址
+	Format    string    `json:"format"`               // 解析格式
+	CSVColumn string    `json:"csv_column,omitempty"` // CSV 列名
+	JSONPath  string    `json:"json_path,omitempty"`  // JSON 路径
+	FetchedAt time.Time `json:"fetched_at"`           // 下载时间 (返回 304 时更新)
+	Entries   int       `json:"entries"`              // 条目数
+
+	listValidators
+}
+
+// listValidators URL 列表的 HTTP 缓存校验信息，下次下载时用于条件请求 (If-None-Match / If-Modified-Since)
+type listValidators struct {
+	ETag         string `json:"etag,omitempty"`          // 响应头 ETag
+	LastModified string `json:"last_modified,omitempty"` // 响应头 Last-Modified
+}
+
+// listCachePath 返回列表缓存文件的路径 (不含扩展名)
+func listCachePath(listType, name string) string {
+	configMutex.RLock()
+	dataDir := config.DataDir
+	configMutex.RUnlock()
+	return filepath.Join(dataDir, "list_cache", listType, safeFileName(name))
+}
+
+// matches 判断缓存是否对应当前的列表配置 (下载地址或解析方式变化后缓存失效)
+func (m ListCacheMeta) matches(list IPList) bool {
+	return m.URL == list.URL && strings.EqualFold(m.Format, list.Format) &&
+		m.CSVColumn == list.CSVColumn && m.JSONPath == list.JSONPath
+}
+
+// saveListCache 将下载成功的列表写入磁盘缓存 (先写条目再写元数据)
+func saveListCache(listType string, list IPList, ips []netip.Addr, cidrs []netip.Prefix, fetchedAt time.Time, validators listValidators) error {
+	var sb strings.Builder
+	for _, ip := range ips {
+		sb.WriteString(ip.String())
+		sb.WriteByte('\n')
+	}
+	for _, cidr := range cidrs {
+		sb.WriteString(cidr.String())
+		sb.WriteByte('\n')
+	}
+	path := listCachePath(listType, list.Name)
+	if err := writeFileAtomic(path+".txt", []byte(sb.String())); err != nil {
+		return err
+	}
+	meta := ListCacheMeta{
+		URL:            list.URL,
+		Format:         list.Format,
+		CSVColumn:      list.CSVColumn,
+		JSONPath:       list.JSONPath,
+		FetchedAt:      fetchedAt,
+		Entries:        len(ips) + len(cidrs),
+		listValidators: validators,
+	}
+	return writeListCacheMeta(path, meta)
+}
+
+// writeListCacheMeta 写入缓存元数据
+func writeListCacheMeta(path string, meta ListCacheMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path+".json", data)
+}
+
+// touchListCache 列表未修改 (HTTP 304) 时更新缓存的下载时间与校验信息，缓存不存在时忽略
+func touchListCache(listType string, list IPList, fetchedAt time.Time, validators listValidators) error {
+	path := listCachePath(listType, list.Name)
+	data, err := os.ReadFile(path + ".json")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var meta ListCacheMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("invalid cache metadata: %v", err)
+	}
+	if !meta.matches(list) {
+		return nil
+	}
+	meta.FetchedAt = fetchedAt
+	meta.listValidators = validators
+	return writeListCacheMeta(path, meta)
+}
+
+// readListCache 读取列表的磁盘缓存，缓存不存在或与当前配置不匹配时返回 nil
+func readListCache(listType string, list IPList) (*ListCacheMeta, []netip.Addr, []netip.Prefix, error) {
+	path := listCachePath(listType, list.Name)
+	data, err := os.ReadFile(path + ".json")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil, nil
+		}
+		return nil, nil, nil, err
+	}
+	var meta ListCacheMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid cache metadata: %v", err)
+	}
+	if !meta.matches(list) {
+		return nil, nil, nil, nil
+	}
+	body, err := os.ReadFile(path + ".txt")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ips, cidrs, err := parseText(string(body))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return &meta, ips, cidrs, nil
+}
+
+// loadListCache 在首次下载前加载磁盘缓存 (ctx 取消后不再更新 data)
+// 返回缓存的元数据，未从缓存加载时返回 nil
+func loadListCache(ctx context.Context, list IPList, data *ListGroup, listType string) *ListCacheMeta {
+	meta, ips, cidrs, err := readListCache(listType, list)
+	if err != nil {
+		logrus.Warnf("Failed to load cached list [%s] %s: %v", listType, list.Name, err)
+		return nil
+	}
+	if meta == nil || ctx.Err() != nil {
+		return nil
+	}
+	data.AddList(NewNetListInfo(list.Name, list.Level), ips, cidrs)
+	recordListCacheLoad(listType, list, meta.FetchedAt)
+	logrus.Infof("Loaded %d IPs and %d CIDRs from cache [%s] %s, fetched at %s",
+		len(ips), len(cidrs), listType, list.Name, meta.FetchedAt.Format(time.RFC3339))
+	return meta
+}
+
+// ListState file/url 列表的数据新鲜度 (用于 /status 与过期告警)
+type ListState struct {
+	Type        string        `json:"type"`                  // safe_list / risk_list
+	Name        string        `json:"name"`                  // 列表名称
+	Source      string        `json:"source"`                // url / file
+	UpdatedAt   time.Time     `json:"updated_at,omitzero"`   // 当前数据的获取时间 (来自缓存时为缓存的下载时间)
+	LastAttempt time.Time     `json:"last_attempt,omitzero"` // 最近一次下载/加载的时间
+	LastError   string        `json:"last_error,omitempty"`  // 最近一次下载/加载失败的错误 (成功后清空)
+	FromCache   bool          `json:"from_cache"`            // 当前数据来自磁盘缓存 (下载尚未成功)
+	AgeSeconds  int64         `json:"age_seconds"`           // 当前数据的年龄 (秒, 无数据时为 0)
+	MaxAge      string        `json:"max_age,omitempty"`     // 配置的最长有效期
+	Stale       bool          `json:"stale"`                 // 超过 max_age 或从未加载成功
+	maxAge      time.Duration // 解析后的最长有效期
+	warned      bool          // 已输出过期告警 (更新成功后重置)
+}
+
+// listStateKey 列表状态的键：类型 + 名称
+type listStateKey struct {
+	Type string
+	Name string
+}
+
+// file/url 列表的数据新鲜度
+var ListStates = make(map[listStateKey]*ListState)
+var ListStatesMutex sync.Mutex
+
+// listStateLocked 返回列表的状态，不存在时创建 (调用方需持有 ListStatesMutex)
+func listStateLocked(listType string, list IPList) *ListState {
+	key := listStateKey{Type: listType, Name: list.Name}
+	s := ListStates[key]
+	if s == nil {
+		s = &ListState{Type: listType, Name: list.Name}
+		ListStates[key] = s
+	}
+	s.Source = "url"
+	if list.File != "" {
+		s.Source = "file"
+	}
+	s.MaxAge = list.MaxAge
+	s.maxAge = list.MaxAgeParsed
+	return s
+}
+
+// recordListUpdate 记录一次下载/加载的结果 (失败时保留上次成功的数据与时间)
+func recordListUpdate(listType string, list IPList, err error) {
+	ListStatesMutex.Lock()
+	defer ListStatesMutex.Unlock()
+	s := listStateLocked(listType, list)
+	now := time.Now()
+	s.LastAttempt = now
+	if err != nil {
+		s.LastError = err.Error()
+		return
+	}
+	s.UpdatedAt = now
+	s.LastError = ""
+	s.FromCache = false
+	s.warned = false
+}
+
+// recordListCacheLoad 记录列表已从磁盘缓存加载
+func recordListCacheLoad(listType string, list IPList, fetchedAt time.Time) {
+	ListStatesMutex.Lock()
+	defer ListStatesMutex.Unlock()
+	s := listStateLocked(listType, list)
+	s.UpdatedAt = fetchedAt
+	s.FromCache = true
+	s.warned = false
+}
+
+// deleteListState 删除列表的状态 (列表从配置中删除时调用)
+func deleteListState(listType, name string) {
+	ListStatesMutex.Lock()
+	delete(ListStates, listStateKey{Type: listType, Name: name})
+	ListStatesMutex.Unlock()
+}
+
+// refreshLocked 根据当前时间计算年龄与是否过期 (调用方需持有 ListStatesMutex)
+func (s *ListState) refreshLocked(now time.Time) {
+	s.AgeSeconds = 0
+	if !s.UpdatedAt.IsZero() {
+		s.AgeSeconds = int64(now.Sub(s.UpdatedAt) / time.Second)
+	}
+	switch {
+	case s.UpdatedAt.IsZero():
+		// 已尝试加载但从未成功
+		s.Stale = s.LastError != ""
+	case s.maxAge > 0:
+		s.Stale = now.Sub(s.UpdatedAt) > s.maxAge
+	default:
+		s.Stale = false
+	}
+}
+
+// ListStatesSnapshot 返回所有列表状态的副本 (线程安全, 按类型、名称排序)
+func ListStatesSnapshot() []ListState {
+	ListStatesMutex.Lock()
+	defer ListStatesMutex.Unlock()
+	now := time.Now()
+	states := make([]ListState, 0, len(ListStates))
+	for _, s := range ListStates {
+		s.refreshLocked(now)
+		states = append(states, *s)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Type != states[j].Type {
+			return states[i].Type < states[j].Type
+		}
+		return states[i].Name < states[j].Name
+	})
+	return states
+}
+
+// StartListStateMonitor 启动列表过期检查
+// 每 1 秒检查一次，列表超过 max_age 或从未加载成功时输出一次告警 (更新成功后重新计算)
+func StartListStateMonitor(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checkListStates(time.Now())
+			}
+		}
+	}()
+}
+
+// checkListStates 对新变为过期的列表输出告警
+func checkListStates(now time.Time) {
+	ListStatesMutex.Lock()
+	defer ListStatesMutex.Unlock()
+	for _, s := range ListStates {
+		s.refreshLocked(now)
+		if !s.Stale || s.warned {
+			continue
+		}
+		s.warned = true
+		if s.UpdatedAt.IsZero() {
+			logrus.Warnf("IP list [%s] %s has never been loaded successfully: %s", s.Type, s.Name, s.LastError)
+			continue
+		}
+		logrus.Warnf("IP list [%s] %s is stale: last updated %s ago (max_age %s), last error: %s",
+			s.Type, s.Name, now.Sub(s.UpdatedAt).Truncate(time.Second), s.MaxAge, s.LastError)
+	}
+}
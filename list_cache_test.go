@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// resetListStates 清空列表新鲜度状态 (测试结束后恢复)
+func resetListStates(t *testing.T) {
+	t.Helper()
+	ListStatesMutex.Lock()
+	prev := ListStates
+	ListStates = make(map[listStateKey]*ListState)
+	ListStatesMutex.Unlock()
+	t.Cleanup(func() {
+		ListStatesMutex.Lock()
+		ListStates = prev
+		ListStatesMutex.Unlock()
+	})
+}
+
+// listStateOf 返回列表状态的快照
+func listStateOf(t *testing.T, listType, name string) ListState {
+	t.Helper()
+	for _, s := range ListStatesSnapshot() {
+		if s.Type == listType && s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("no state for list [%s] %s", listType, name)
+	return ListState{}
+}
+
+func TestListCacheRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	setTestConfig(t, Config{DataDir: dir})
+
+	list := IPList{Name: "drop/v4", URL: "https://example.com/drop.txt", Format: "text"}
+	ips := []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")}
+	cidrs := []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")}
+	fetchedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	validators := listValidators{ETag: `"abc"`, LastModified: "Fri, 02 Jan 2026 03:04:05 GMT"}
+	if err := saveListCache("risk_list", list, ips, cidrs, fetchedAt, validators); err != nil {
+		t.Fatal(err)
+	}
+
+	// 条目与元数据分别写入 data_dir/list_cache/<type>/<name>.txt / .json
+	base := listCachePath("risk_list", list.Name)
+	if filepath.Dir(base) != filepath.Join(dir, "list_cache", "risk_list") {
+		t.Fatalf("unexpected cache path %s", base)
+	}
+	txt, err := os.ReadFile(base + ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "192.0.2.1\n2001:db8::1\n198.51.100.0/24\n"; string(txt) != want {
+		t.Fatalf("cache data = %q, want %q", txt, want)
+	}
+	raw, err := os.ReadFile(base + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["url"] != list.URL || fields["entries"] != float64(3) || fields["etag"] != `"abc"` || fields["last_modified"] != validators.LastModified {
+		t.Fatalf("unexpected cache metadata: %s", raw)
+	}
+
+	meta, gotIPs, gotCIDRs, err := readListCache("risk_list", list)
+	if err != nil || meta == nil {
+		t.Fatalf("readListCache: %v, %v", meta, err)
+	}
+	if !meta.FetchedAt.Equal(fetchedAt) || meta.listValidators != validators {
+		t.Fatalf("metadata = %+v", meta)
+	}
+	if !reflect.DeepEqual(gotIPs, ips) || !reflect.DeepEqual(gotCIDRs, cidrs) {
+		t.Fatalf("cached entries = %v %v", gotIPs, gotCIDRs)
+	}
+
+	// 304 时只更新下载时间与校验信息
+	touched := fetchedAt.Add(time.Hour)
+	if err := touchListCache("risk_list", list, touched, listValidators{ETag: `"def"`}); err != nil {
+		t.Fatal(err)
+	}
+	meta, _, _, _ = readListCache("risk_list", list)
+	if !meta.FetchedAt.Equal(touched) || meta.ETag != `"def"` || meta.Entries != 3 {
+		t.Fatalf("touched metadata = %+v", meta)
+	}
+
+	// 下载地址或解析方式变化后缓存失效
+	for _, changed := range []IPList{
+		{Name: list.Name, URL: "https://example.com/other.txt", Format: "text"},
+		{Name: list.Name, URL: list.URL, Format: "csv"},
+		{Name: list.Name, URL: list.URL, Format: "text", JSONPath: "$.ips"},
+	} {
+		if meta, _, _, err := readListCache("risk_list", changed); meta != nil || err != nil {
+			t.Errorf("cache used for changed list %+v: %v", changed, err)
+		}
+	}
+	// 格式名称不区分大小写
+	if meta, _, _, _ := readListCache("risk_list", IPList{Name: list.Name, URL: list.URL, Format: "TEXT"}); meta == nil {
+		t.Error("cache not matched for format in different case")
+	}
+
+	// 没有缓存时不报错
+	missing := IPList{Name: "missing", URL: list.URL, Format: "text"}
+	if meta, _, _, err := readListCache("risk_list", missing); meta != nil || err != nil {
+		t.Fatalf("missing cache: %v, %v", meta, err)
+	}
+	if err := touchListCache("risk_list", missing, touched, validators); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(listCachePath("risk_list", "missing") + ".json"); !os.IsNotExist(err) {
+		t.Fatalf("touch created cache for missing list: %v", err)
+	}
+
+	// 损坏的元数据返回错误
+	if err := os.WriteFile(base+".json", []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := readListCache("risk_list", list); err == nil {
+		t.Fatal("expected error for corrupt metadata")
+	}
+}
+
+// TestLoadIPListUsesCacheBeforeDownload 启动时先加载磁盘缓存，首次加载不必等待下载；waitDownload 时等待下载完成
+func TestLoadIPListUsesCacheBeforeDownload(t *testing.T) {
+	setTestConfig(t, Config{DataDir: t.TempDir()})
+	resetListStates(t)
+
+	release := make(chan struct{})
+	var body atomic.Value
+	body.Store("192.0.2.2\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte(body.Load().(string)))
+	}))
+	defer srv.Close()
+
+	list := IPList{Name: "remote", URL: srv.URL + "/list.txt", Format: "text", Level: 1}
+	cachedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := saveListCache("risk_list", list, []netip.Addr{netip.MustParseAddr("192.0.2.1")}, nil, cachedAt, listValidators{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	data := NewListGroup()
+	var wg sync.WaitGroup
+	LoadIPList(ctx, []IPList{list}, data, "risk_list", &wg, false)
+	waitTimeout(t, &wg, "first load with cache")
+
+	// 下载仍在进行：使用缓存数据，状态标记为来自缓存
+	if ok, _ := data.Contains(netip.MustParseAddr("192.0.2.1")); !ok {
+		t.Fatal("cached list not loaded before download")
+	}
+	s := listStateOf(t, "risk_list", "remote")
+	if !s.FromCache || !s.UpdatedAt.Equal(cachedAt) || s.Source != "url" {
+		t.Fatalf("state after cache load = %+v", s)
+	}
+
+	// 下载完成后替换缓存数据 (下载结果与缓存均已更新后才记录状态)
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for listStateOf(t, "risk_list", "remote").FromCache {
+		if time.Now().After(deadline) {
+			t.Fatal("download did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ok, _ := data.Contains(netip.MustParseAddr("192.0.2.2")); !ok {
+		t.Fatal("downloaded list not loaded")
+	}
+	if _, cachedIPs, _, _ := readListCache("risk_list", list); !reflect.DeepEqual(cachedIPs, []netip.Addr{netip.MustParseAddr("192.0.2.2")}) {
+		t.Fatalf("cache not replaced by download: %v", cachedIPs)
+	}
+
+	// waitDownload: 命中缓存时仍等待下载完成
+	body.Store("192.0.2.3\n")
+	waited := NewListGroup()
+	var wg2 sync.WaitGroup
+	LoadIPList(ctx, []IPList{list}, waited, "risk_list", &wg2, true)
+	waitTimeout(t, &wg2, "first load with waitDownload")
+	if ok, _ := waited.Contains(netip.MustParseAddr("192.0.2.3")); !ok {
+		t.Fatal("waitDownload returned before the download finished")
+	}
+	if s := listStateOf(t, "risk_list", "remote"); s.FromCache || s.LastError != "" {
+		t.Fatalf("state after download = %+v", s)
+	}
+}
+
+// waitTimeout 等待 WaitGroup，超时则测试失败
+func waitTimeout(t *testing.T, wg *sync.WaitGroup, what string) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func TestListStateFreshness(t *testing.T) {
+	resetListStates(t)
+
+	hourly := IPList{Name: "hourly", URL: "https://example.com/a", MaxAge: "1h", MaxAgeParsed: time.Hour}
+	unchecked := IPList{Name: "unchecked", File: "/tmp/list.txt"}
+	broken := IPList{Name: "broken", URL: "https://example.com/b", MaxAge: "1h", MaxAgeParsed: time.Hour}
+
+	// 从缓存加载的旧数据超过 max_age
+	recordListCacheLoad("risk_list", hourly, time.Now().Add(-2*time.Hour))
+	s := listStateOf(t, "risk_list", "hourly")
+	if !s.Stale || !s.FromCache || s.AgeSeconds < 7199 || s.MaxAge != "1h" {
+		t.Fatalf("stale cached state = %+v", s)
+	}
+
+	// 下载失败时保留缓存数据的时间，仍然过期
+	recordListUpdate("risk_list", hourly, os.ErrDeadlineExceeded)
+	s = listStateOf(t, "risk_list", "hourly")
+	if !s.Stale || !s.FromCache || s.LastError == "" || s.LastAttempt.IsZero() {
+		t.Fatalf("state after failed update = %+v", s)
+	}
+
+	// 下载成功后恢复
+	recordListUpdate("risk_list", hourly, nil)
+	s = listStateOf(t, "risk_list", "hourly")
+	if s.Stale || s.FromCache || s.LastError != "" || s.AgeSeconds > 1 {
+		t.Fatalf("state after successful update = %+v", s)
+	}
+
+	// 未配置 max_age 时不过期
+	recordListCacheLoad("safe_list", unchecked, time.Now().Add(-30*24*time.Hour))
+	if s := listStateOf(t, "safe_list", "unchecked"); s.Stale || s.Source != "file" {
+		t.Fatalf("list without max_age = %+v", s)
+	}
+
+	// 从未加载成功
+	recordListUpdate("risk_list", broken, os.ErrNotExist)
+	if s := listStateOf(t, "risk_list", "broken"); !s.Stale || !s.UpdatedAt.IsZero() || s.AgeSeconds != 0 {
+		t.Fatalf("never loaded list = %+v", s)
+	}
+
+	// 过期告警只输出一次，更新成功后重新计算
+	checkListStates(time.Now())
+	ListStatesMutex.Lock()
+	warned := ListStates[listStateKey{Type: "risk_list", Name: "broken"}].warned
+	ListStatesMutex.Unlock()
+	if !warned {
+		t.Fatal("stale list not warned")
+	}
+	recordListUpdate("risk_list", broken, nil)
+	ListStatesMutex.Lock()
+	warned = ListStates[listStateKey{Type: "risk_list", Name: "broken"}].warned
+	ListStatesMutex.Unlock()
+	if warned {
+		t.Fatal("warning not reset after successful update")
+	}
+
+	deleteListState("risk_list", "broken")
+	for _, s := range ListStatesSnapshot() {
+		if s.Name == "broken" {
+			t.Fatal("state kept after deleteListState")
+		}
+	}
+	// 快照按类型、名称排序
+	names := []string{}
+	for _, s := range ListStatesSnapshot() {
+		names = append(names, s.Type+"/"+s.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"risk_list/hourly", "safe_list/unchecked"}) {
+		t.Fatalf("snapshot order = %v", names)
+	}
+}
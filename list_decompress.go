@@ -0,0 +1,150 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxListSize 解压后列表内容的大小上限 (字节)，防止压缩炸弹 (测试中可调小)
+var maxListSize = 512 << 20
+
+// 列表内容的压缩格式
+const (
+	compressionNone  = ""
+	compressionGzip  = "gzip"
+	compressionBzip2 = "bzip2"
+	compressionZip   = "zip"
+)
+
+// compressionMagic 各压缩格式的文件头 (不含任何文件的 zip 以目录结束标记开始)
+var compressionMagic = map[string][][]byte{
+	compressionGzip:  {{0x1f, 0x8b}},
+	compressionBzip2: {[]byte("BZh")},
+	compressionZip:   {[]byte("PK\x03\x04"), []byte("PK\x05\x06")},
+}
+
+// hasCompressionMagic 判断内容是否以压缩格式的文件头开始
+func hasCompressionMagic(compression string, body []byte) bool {
+	for _, magic := range compressionMagic[compression] {
+		if bytes.HasPrefix(body, magic) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectCompression 根据文件扩展名 (.gz/.bz2/.zip) 或 Content-Type 判断压缩格式
+// name 为本地文件路径或 URL；contentType 为响应的 Content-Type (本地文件为空)
+func detectCompression(name, contentType string) string {
+	if u, err := url.Parse(name); err == nil && u.Scheme != "" {
+		name = u.Path
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".gz", ".gzip":
+		return compressionGzip
+	case ".bz2":
+		return compressionBzip2
+	case ".zip":
+		return compressionZip
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch strings.ToLower(mediaType) {
+	case "application/gzip", "application/x-gzip":
+		return compressionGzip
+	case "application/x-bzip2", "application/x-bzip":
+		return compressionBzip2
+	case "application/zip", "application/x-zip-compressed":
+		return compressionZip
+	}
+	return compressionNone
+}
+
+// decompressListBody 按扩展名或 Content-Type 解压列表内容
+// 内容不以对应格式的文件头开始时 (如服务器已按 Content-Encoding 压缩传输并被自动解压) 原样返回；
+// zip 中的多个文件: text 格式按顺序拼接，其他格式只使用第一个文件
+func decompressListBody(name, contentType, format string, body []byte) ([]byte, error) {
+	compression := detectCompression(name, contentType)
+	if compression == compressionNone {
+		return body, nil
+	}
+	if !hasCompressionMagic(compression, body) {
+		logrus.Debugf("Content of %s is not %s compressed, using it as is", name, compression)
+		return body, nil
+	}
+
+	switch compression {
+	case compressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip content: %v", err)
+		}
+		defer r.Close()
+		return readLimited(r, compression)
+	case compressionBzip2:
+		return readLimited(bzip2.NewReader(bytes.NewReader(body)), compression)
+	default:
+		return readZip(body, format)
+	}
+}
+
+// readZip 读取 zip 中的文件内容
+func readZip(body []byte, format string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip content: %v", err)
+	}
+	var out bytes.Buffer
+	files := 0
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if files > 0 && !strings.EqualFold(format, "text") && format != "" {
+			logrus.Warnf("Zip archive contains multiple files, only the first one (%s format) is used", format)
+			break
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s in zip: %v", f.Name, err)
+		}
+		data, err := readLimited(rc, compressionZip)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		out.Write(data)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			out.WriteByte('\n')
+		}
+		if out.Len() > maxListSize {
+			return nil, fmt.Errorf("decompressed zip content exceeds %d bytes", maxListSize)
+		}
+		files++
+	}
+	if files == 0 {
+		return nil, fmt.Errorf("zip archive contains no files")
+	}
+	return out.Bytes(), nil
+}
+
+// readLimited 读取解压后的内容，超过 maxListSize 时返回错误
+func readLimited(r io.Reader, compression string) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(maxListSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s content: %v", compression, err)
+	}
+	if len(data) > maxListSize {
+		return nil, fmt.Errorf("decompressed %s content exceeds %d bytes", compression, maxListSize)
+	}
+	return data, nil
+}
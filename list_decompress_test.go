@@ -0,0 +1,249 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/imroc/req/v3"
+)
+
+// bzip2 压缩的 "192.0.2.1\n198.51.100.0/24\n" (标准库只提供 bzip2 解压)
+var testBzip2List = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x2a, 0x14, 0x86, 0x8e, 0x00, 0x00,
+	0x08, 0x58, 0x00, 0x00, 0x10, 0x00, 0x01, 0xf6, 0x60, 0x20, 0x00, 0x31, 0x00, 0x30, 0x1a, 0x64,
+	0x9b, 0x4d, 0x23, 0xc6, 0xca, 0x50, 0x86, 0x2c, 0xc3, 0xa3, 0x97, 0x45, 0x6c, 0x7c, 0x5d, 0xc9,
+	0x14, 0xe1, 0x42, 0x40, 0xa8, 0x52, 0x1a, 0x38,
+}
+
+// gzipBytes 返回 gzip 压缩后的内容
+func gzipBytes(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// zipBytes 返回包含给定文件的 zip 内容 (文件名以 / 结尾时创建目录)
+func zipBytes(t *testing.T, files ...[2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, f := range files {
+		fw, err := w.Create(f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(f[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectCompression(t *testing.T) {
+	cases := []struct {
+		name, contentType, want string
+	}{
+		{"/var/lib/lists/drop.txt.gz", "", compressionGzip},
+		{"drop.GZIP", "", compressionGzip},
+		{"drop.bz2", "", compressionBzip2},
+		{"drop.zip", "", compressionZip},
+		{"https://example.com/drop.zip?token=a.gz", "", compressionZip},
+		{"https://example.com/drop.txt", "", compressionNone},
+		{"https://example.com/download", "application/gzip", compressionGzip},
+		{"https://example.com/download", "application/x-gzip; charset=binary", compressionGzip},
+		{"https://example.com/download", "application/x-bzip2", compressionBzip2},
+		{"https://example.com/download", "Application/Zip", compressionZip},
+		{"https://example.com/download", "application/x-zip-compressed", compressionZip},
+		{"https://example.com/download", "text/plain; charset=utf-8", compressionNone},
+		{"https://example.com/download", "", compressionNone},
+		// 扩展名优先于 Content-Type
+		{"https://example.com/drop.bz2", "application/zip", compressionBzip2},
+	}
+	for _, c := range cases {
+		if got := detectCompression(c.name, c.contentType); got != c.want {
+			t.Errorf("detectCompression(%q, %q) = %q, want %q", c.name, c.contentType, got, c.want)
+		}
+	}
+}
+
+func TestDecompressListBody(t *testing.T) {
+	const list = "192.0.2.1\n198.51.100.0/24\n"
+	cases := []struct {
+		name        string
+		source      string
+		contentType string
+		format      string
+		body        []byte
+		want        string
+		wantErr     string
+	}{
+		{name: "gzip by extension", source: "list.txt.gz", body: gzipBytes(t, list), want: list},
+		{name: "gzip by content type", source: "https://example.com/list", contentType: "application/gzip", body: gzipBytes(t, list), want: list},
+		{name: "bzip2 by extension", source: "list.bz2", body: testBzip2List, want: list},
+		{name: "bzip2 by content type", source: "https://example.com/list", contentType: "application/x-bzip2", body: testBzip2List, want: list},
+		{name: "zip by extension", source: "list.zip", body: zipBytes(t, [2]string{"list.txt", list}), want: list},
+		{name: "zip by content type", source: "https://example.com/list", contentType: "application/zip", body: zipBytes(t, [2]string{"list.txt", list}), want: list},
+		{name: "uncompressed", source: "list.txt", body: []byte(list), want: list},
+		// 文件头不匹配 (如传输时已按 Content-Encoding 解压) 时原样使用
+		{name: "missing gzip magic", source: "list.gz", body: []byte(list), want: list},
+		{name: "missing zip magic", source: "https://example.com/list", contentType: "application/zip", body: []byte(list), want: list},
+		{name: "corrupt gzip", source: "list.gz", body: []byte{0x1f, 0x8b, 0x00}, wantErr: "invalid gzip content"},
+		{name: "corrupt bzip2", source: "list.bz2", body: []byte("BZh9garbage"), wantErr: "failed to decompress bzip2 content"},
+		{name: "zip text members concatenated", source: "list.zip", format: "text",
+			body: zipBytes(t, [2]string{"a.txt", "192.0.2.1"}, [2]string{"dir/", ""}, [2]string{"b.txt", "192.0.2.2\n"}),
+			want: "192.0.2.1\n192.0.2.2\n"},
+		{name: "zip json uses first member", source: "list.zip", format: "json",
+			body: zipBytes(t, [2]string{"a.json", `["192.0.2.1"]`}, [2]string{"b.json", `["192.0.2.2"]`}),
+			want: "[\"192.0.2.1\"]\n"},
+		{name: "corrupt zip", source: "list.zip", body: []byte("PK\x03\x04garbage"), wantErr: "invalid zip content"},
+		{name: "zip without members", source: "list.zip", body: zipBytes(t), wantErr: "contains no files"},
+		{name: "zip with only directories", source: "list.zip", body: zipBytes(t, [2]string{"dir/", ""}), wantErr: "contains no files"},
+	}
+	for _, c := range cases {
+		got, err := decompressListBody(c.source, c.contentType, c.format, c.body)
+		if c.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("%s: error = %v, want %q", c.name, err, c.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if string(got) != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// TestDecompressSizeLimit 解压后超过 maxListSize 的内容被拒绝 (测试中将上限调小)
+func TestDecompressSizeLimit(t *testing.T) {
+	prev := maxListSize
+	maxListSize = 64
+	t.Cleanup(func() { maxListSize = prev })
+
+	exact := strings.Repeat("a", 64)
+	over := exact + "a"
+	cases := []struct {
+		name    string
+		source  string
+		body    []byte
+		wantErr bool
+	}{
+		{"gzip at limit", "list.gz", gzipBytes(t, exact), false},
+		{"gzip over limit", "list.gz", gzipBytes(t, over), true},
+		{"zip member over limit", "list.zip", zipBytes(t, [2]string{"a.txt", over}), true},
+		{"zip members together over limit", "list.zip", zipBytes(t, [2]string{"a.txt", exact[:40]}, [2]string{"b.txt", exact[:40]}), true},
+	}
+	for _, c := range cases {
+		_, err := decompressListBody(c.source, "", "text", c.body)
+		if c.wantErr && (err == nil || !strings.Contains(err.Error(), "exceeds 64 bytes")) {
+			t.Errorf("%s: error = %v, want size limit error", c.name, err)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+}
+
+// TestDownloadConditionalGet 携带 ETag/Last-Modified 的条件请求返回 304 时保留当前数据并更新缓存时间
+func TestDownloadConditionalGet(t *testing.T) {
+	setTestConfig(t, Config{DataDir: t.TempDir()})
+	const lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+	var body atomic.Value
+	body.Store("192.0.2.1\n")
+	var requests, notModified atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		current := etag.Load().(string)
+		if r.Header.Get("If-None-Match") == current && r.Header.Get("If-Modified-Since") == lastModified {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", current)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Write(gzipBytes(t, body.Load().(string)))
+	}))
+	defer srv.Close()
+
+	list := IPList{Name: "remote", URL: srv.URL + "/list", Format: "text", Level: 1}
+	data := NewListGroup()
+	var validators listValidators
+	ctx := context.Background()
+	client := req.C()
+
+	if err := downloadAndParse(ctx, client, list, data, "risk_list", &validators); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := data.Contains(netip.MustParseAddr("192.0.2.1")); !ok {
+		t.Fatal("downloaded list not loaded")
+	}
+	if validators != (listValidators{ETag: `"v1"`, LastModified: lastModified}) {
+		t.Fatalf("validators = %+v", validators)
+	}
+	meta, _, _, err := readListCache("risk_list", list)
+	if err != nil || meta == nil {
+		t.Fatalf("list not cached: %v", err)
+	}
+	firstFetch := meta.FetchedAt
+
+	// 内容已变化但 ETag 未变：服务器返回 304，保留当前数据，只更新缓存的下载时间
+	body.Store("192.0.2.2\n")
+	time.Sleep(10 * time.Millisecond)
+	if err := downloadAndParse(ctx, client, list, data, "risk_list", &validators); err != nil {
+		t.Fatal(err)
+	}
+	if notModified.Load() != 1 {
+		t.Fatalf("conditional request not sent: %d of %d requests answered with 304", notModified.Load(), requests.Load())
+	}
+	if ok, _ := data.Contains(netip.MustParseAddr("192.0.2.1")); !ok {
+		t.Fatal("current data dropped after 304")
+	}
+	if ok, _ := data.Contains(netip.MustParseAddr("192.0.2.2")); ok {
+		t.Fatal("list parsed although the server answered 304")
+	}
+	meta, _, _, err = readListCache("risk_list", list)
+	if err != nil || meta == nil || !meta.FetchedAt.After(firstFetch) || meta.ETag != `"v1"` {
+		t.Fatalf("cache not touched after 304: %+v, %v", meta, err)
+	}
+
+	// ETag 变化后重新下载并解析
+	etag.Store(`"v2"`)
+	if err := downloadAndParse(ctx, client, list, data, "risk_list", &validators); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := data.Contains(netip.MustParseAddr("192.0.2.2")); !ok {
+		t.Fatal("modified list not loaded")
+	}
+	if validators.ETag != `"v2"` {
+		t.Fatalf("validators not updated: %+v", validators)
+	}
+	if _, err := os.Stat(listCachePath("risk_list", list.Name) + ".txt"); err != nil {
+		t.Fatal(err)
+	}
+}
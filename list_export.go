@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 导出格式
+const (
+	ExportFormatText     = "text"     // 每行一个 IP 或 CIDR
+	ExportFormatNftables = "nftables" // nft -f 可加载的脚本
+	ExportFormatIPSet    = "ipset"    // ipset restore 可加载的脚本
+	ExportFormatJSON     = "json"     // JSON 对象
+)
+
+// exportChunkSize nftables 脚本中每条 add element 语句包含的最大条目数
+const exportChunkSize = 1000
+
+// ExportOptions 导出参数
+type ExportOptions struct {
+	Lists  []string // 导出的风险列表名称 (为空表示所有风险列表)
+	Format string   // 导出格式: text / nftables / ipset / json
+	Table  string   // nftables 表 (默认 "inet filter")
+	Set    string   // nftables/ipset 的 IPv4 集合名 (默认 iplog_risk, 与封禁动作的集合区分)
+	Set6   string   // nftables/ipset 的 IPv6 集合名 (默认 set + "6")
+}
+
+// ExportResult 导出结果: 所选风险列表的并集减去安全列表，重叠与相邻的 CIDR 已合并
+type ExportResult struct {
+	GeneratedAt time.Time      `json:"generated_at"` // 生成时间
+	Lists       []string       `json:"lists"`        // 导出的风险列表名称
+	Count       int            `json:"count"`        // 条目总数
+	IPv4        []netip.Prefix `json:"ipv4"`         // IPv4 条目
+	IPv6        []netip.Prefix `json:"ipv6"`         // IPv6 条目
+}
+
+// normalize 校验导出格式并填充默认值
+func (o *ExportOptions) normalize() error {
+	o.Format = strings.ToLower(o.Format)
+	if o.Format == "" {
+		o.Format = ExportFormatText
+	}
+	switch o.Format {
+	case ExportFormatText, ExportFormatNftables, ExportFormatIPSet, ExportFormatJSON:
+	default:
+		return fmt.Errorf("unsupported export format: %s (text, nftables, ipset, json)", o.Format)
+	}
+	if o.Table == "" {
+		o.Table = "inet filter"
+	}
+	if o.Set == "" {
+		o.Set = "iplog_risk"
+	}
+	if o.Set6 == "" {
+		o.Set6 = o.Set + "6"
+	}
+	return nil
+}
+
+// splitListNames 拆分列表名称参数 (支持重复参数与逗号分隔)
+func splitListNames(values []string) []string {
+	var names []string
+	for _, v := range values {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// ExportRiskList 导出风险列表: 所选风险列表的并集减去安全列表，并合并重叠与相邻的 CIDR
+func ExportRiskList(risk, safe *ListGroup, names []string) (*ExportResult, error) {
+	riskPrefixes, err := risk.Prefixes(names)
+	if err != nil {
+		return nil, err
+	}
+	var safePrefixes []netip.Prefix
+	if safe != nil {
+		if safePrefixes, err = safe.Prefixes(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	lists := names
+	if len(lists) == 0 {
+		lists = risk.Names()
+	}
+	sort.Strings(lists)
+
+	ranges := subtractRanges(mergeRanges(prefixesToRanges(riskPrefixes)), mergeRanges(prefixesToRanges(safePrefixes)))
+	result := &ExportResult{GeneratedAt: time.Now(), Lists: lists, IPv4: []netip.Prefix{}, IPv6: []netip.Prefix{}}
+	for _, r := range ranges {
+		for _, p := range r.prefixes() {
+			if p.Addr().Is4() {
+				result.IPv4 = append(result.IPv4, p)
+			} else {
+				result.IPv6 = append(result.IPv6, p)
+			}
+		}
+	}
+	result.Count = len(result.IPv4) + len(result.IPv6)
+	return result, nil
+}
+
+// WriteExport 按格式输出导出结果
+func WriteExport(w io.Writer, result *ExportResult, opts ExportOptions) error {
+	switch opts.Format {
+	case ExportFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	case ExportFormatNftables:
+		return writeNftablesExport(w, result, opts)
+	case ExportFormatIPSet:
+		return writeIPSetExport(w, result, opts)
+	default:
+		for _, p := range append(result.IPv4, result.IPv6...) {
+			if _, err := fmt.Fprintln(w, formatExportPrefix(p)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// writeNftablesExport 输出 nft -f 可加载的脚本: 创建 (已存在时忽略) 表与集合，清空集合后添加所有条目
+func writeNftablesExport(w io.Writer, result *ExportResult, opts ExportOptions) error {
+	fmt.Fprintf(w, "# Generated by iplog_checker at %s: %d entries from %s\n",
+		result.GeneratedAt.Format(time.RFC3339), result.Count, strings.Join(result.Lists, ", "))
+	fmt.Fprintf(w, "add table %s\n", opts.Table)
+	for _, set := range []struct {
+		name, typ string
+		entries   []netip.Prefix
+	}{
+		{opts.Set, "ipv4_addr", result.IPv4},
+		{opts.Set6, "ipv6_addr", result.IPv6},
+	} {
+		fmt.Fprintf(w, "add set %s %s { type %s; flags interval; }\n", opts.Table, set.name, set.typ)
+		fmt.Fprintf(w, "flush set %s %s\n", opts.Table, set.name)
+		for i := 0; i < len(set.entries); i += exportChunkSize {
+			chunk := set.entries[i:min(i+exportChunkSize, len(set.entries))]
+			elems := make([]string, len(chunk))
+			for j, p := range chunk {
+				elems[j] = formatExportPrefix(p)
+			}
+			if _, err := fmt.Fprintf(w, "add element %s %s { %s }\n", opts.Table, set.name, strings.Join(elems, ", ")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeIPSetExport 输出 ipset restore 可加载的脚本: 创建 (已存在时忽略) 并清空 hash:net 集合后添加所有条目
+func writeIPSetExport(w io.Writer, result *ExportResult, opts ExportOptions) error {
+	fmt.Fprintf(w, "# Generated by iplog_checker at %s: %d entries from %s\n",
+		result.GeneratedAt.Format(time.RFC3339), result.Count, strings.Join(result.Lists, ", "))
+	for _, set := range []struct {
+		name, family string
+		entries      []netip.Prefix
+	}{
+		{opts.Set, "inet", result.IPv4},
+		{opts.Set6, "inet6", result.IPv6},
+	} {
+		// ipset 默认 maxelem 为 65536
+		fmt.Fprintf(w, "create %s hash:net family %s maxelem %d -exist\n", set.name, set.family, max(65536, len(set.entries)))
+		fmt.Fprintf(w, "flush %s\n", set.name)
+		for _, p := range set.entries {
+			if _, err := fmt.Fprintf(w, "add %s %s\n", set.name, formatExportPrefix(p)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// formatExportPrefix 格式化导出条目，单个 IP 不带前缀长度
+func formatExportPrefix(p netip.Prefix) string {
+	if p.IsSingleIP() {
+		return p.Addr().String()
+	}
+	return p.String()
+}
+
+// runExportCommand 执行 export 子命令: 加载配置中的列表后输出导出结果
+// 用法: iplog_checker [-c config.yaml] export [-list name[,name]] [-format text|nftables|ipset|json] [-o file]
+func runExportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	var lists stringList
+	var opts ExportOptions
+	var output string
+	fs.Var(&lists, "list", "risk list name to export (repeatable or comma-separated, default all)")
+	fs.StringVar(&opts.Format, "format", ExportFormatText, "output format: text, nftables, ipset, json")
+	fs.StringVar(&opts.Table, "table", "", "nftables table (default \"inet filter\")")
+	fs.StringVar(&opts.Set, "set", "", "nftables/ipset IPv4 set name (default iplog_risk)")
+	fs.StringVar(&opts.Set6, "set6", "", "nftables/ipset IPv6 set name (default set + \"6\")")
+	fs.StringVar(&output, "o", "", "output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	opts.Lists = splitListNames(lists)
+	if err := opts.normalize(); err != nil {
+		return err
+	}
+
+	newConfig, err := loadConfigFile(ConfigFilePath)
+	if err != nil {
+		return err
+	}
+	// 导出内容可能输出到标准输出，日志只输出警告及以上级别到标准错误
+	logrus.SetOutput(os.Stderr)
+	logrus.SetLevel(logrus.WarnLevel)
+
+	// 相对路径的 data_dir 按配置文件所在目录解析，不依赖执行命令时的工作目录
+	if !filepath.IsAbs(newConfig.DataDir) {
+		newConfig.DataDir = filepath.Join(filepath.Dir(ConfigFilePath), newConfig.DataDir)
+	}
+
+	configMutex.Lock()
+	config = newConfig
+	configMutex.Unlock()
+
+	// 等待 URL 列表下载完成 (下载失败时使用磁盘缓存) 后导出，随后停止周期性更新
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	safe, risk := NewListGroup(), NewListGroup()
+	var wg sync.WaitGroup
+	LoadIPList(ctx, newConfig.SafeList, safe, "safe_list", &wg, true)
+	LoadIPList(ctx, newConfig.RiskList, risk, "risk_list", &wg, true)
+	wg.Wait()
+
+	result, err := ExportRiskList(risk, safe, opts.Lists)
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	return WriteExport(w, result, opts)
+}
+
+// stringList 可重复的字符串命令行参数
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// ipRange 连续的 IP 地址区间 [from, to] (同一地址族)
+type ipRange struct {
+	from, to netip.Addr
+}
+
+// prefixesToRanges 将前缀转换为地址区间
+func prefixesToRanges(prefixes []netip.Prefix) []ipRange {
+	ranges := make([]ipRange, 0, len(prefixes))
+	for _, p := range prefixes {
+		p = p.Masked()
+		ranges = append(ranges, ipRange{from: p.Addr(), to: lastAddr(p)})
+	}
+	return ranges
+}
+
+// lastAddr 返回前缀中的最后一个地址
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// mergeRanges 排序并合并重叠或相邻的区间 (IPv4 排在 IPv6 之前)
+func mergeRanges(ranges []ipRange) []ipRange {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].from.Less(ranges[j].from) })
+	merged := []ipRange{ranges[0]}
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		next := last.to.Next()
+		if last.from.BitLen() == r.from.BitLen() && (!next.IsValid() || !next.Less(r.from)) {
+			if last.to.Less(r.to) {
+				last.to = r.to
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// subtractRanges 从已合并的区间中减去已合并的 exclude 区间
+func subtractRanges(ranges, exclude []ipRange) []ipRange {
+	var result []ipRange
+	j := 0
+	for _, r := range ranges {
+		// 跳过完全位于 r 之前的排除区间
+		for j < len(exclude) && exclude[j].to.Less(r.from) {
+			j++
+		}
+		cur := r
+		valid := true
+		for k := j; k < len(exclude) && valid; k++ {
+			ex := exclude[k]
+			if cur.to.Less(ex.from) {
+				break
+			}
+			if cur.from.BitLen() != ex.from.BitLen() {
+				continue
+			}
+			if cur.from.Less(ex.from) {
+				result = append(result, ipRange{from: cur.from, to: ex.from.Prev()})
+			}
+			next := ex.to.Next()
+			if !next.IsValid() || cur.to.Less(next) {
+				valid = false
+				break
+			}
+			cur.from = next
+		}
+		if valid {
+			result = append(result, cur)
+		}
+	}
+	return result
+}
+
+// prefixes 将区间拆分为最少数量的 CIDR
+func (r ipRange) prefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	from := r.from
+	for {
+		// 从最大的块开始，找到以 from 对齐且不超过 to 的前缀
+		bits := 0
+		for ; bits < from.BitLen(); bits++ {
+			p := netip.PrefixFrom(from, bits)
+			if p.Masked().Addr() == from && !r.to.Less(lastAddr(p)) {
+				break
+			}
+		}
+		p := netip.PrefixFrom(from, bits)
+		prefixes = append(prefixes, p)
+		end := lastAddr(p)
+		if end == r.to {
+			return prefixes
+		}
+		from = end.Next()
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parsePrefixes 解析测试用的前缀列表 (单个 IP 视为 /32 或 /128)
+func parsePrefixes(t *testing.T, entries []string) []netip.Prefix {
+	t.Helper()
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, s := range entries {
+		p, err := parseRuntimeEntry(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes
+}
+
+// rangesToStrings 将区间拆分为 CIDR 并格式化
+func rangesToStrings(ranges []ipRange) []string {
+	var out []string
+	for _, r := range ranges {
+		for _, p := range r.prefixes() {
+			out = append(out, formatExportPrefix(p))
+		}
+	}
+	return out
+}
+
+func TestMergeRanges(t *testing.T) {
+	cases := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{"adjacent halves", []string{"10.0.1.0/24", "10.0.0.0/24"}, []string{"10.0.0.0/23"}},
+		{"adjacent single IPs", []string{"192.0.2.1", "192.0.2.0", "192.0.2.2", "192.0.2.3"}, []string{"192.0.2.0/30"}},
+		{"overlapping", []string{"10.0.0.0/8", "10.1.0.0/16", "10.255.255.255"}, []string{"10.0.0.0/8"}},
+		{"partial overlap", []string{"192.0.2.0/25", "192.0.2.64/26", "192.0.2.128/26"}, []string{"192.0.2.0/25", "192.0.2.128/26"}},
+		{"not adjacent", []string{"192.0.2.0/24", "192.0.4.0/24"}, []string{"192.0.2.0/24", "192.0.4.0/24"}},
+		{"unaligned merge", []string{"192.0.2.1", "192.0.2.2"}, []string{"192.0.2.1", "192.0.2.2"}},
+		{"ipv6 adjacent", []string{"2001:db8::/33", "2001:db8:8000::/33"}, []string{"2001:db8::/32"}},
+		{"families kept apart", []string{"::/0", "255.255.255.255", "0.0.0.0"}, []string{"0.0.0.0", "255.255.255.255", "::/0"}},
+		{"top of ipv4 space", []string{"255.255.255.254", "255.255.255.255", "255.255.255.0/24"}, []string{"255.255.255.0/24"}},
+		{"top of ipv6 space", []string{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe"}, []string{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe/127"}},
+		{"full ranges", []string{"0.0.0.0/1", "128.0.0.0/1", "::/1", "8000::/1"}, []string{"0.0.0.0/0", "::/0"}},
+	}
+	for _, c := range cases {
+		got := rangesToStrings(mergeRanges(prefixesToRanges(parsePrefixes(t, c.in))))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSubtractRanges(t *testing.T) {
+	cases := []struct {
+		name          string
+		risk, exclude []string
+		want          []string
+	}{
+		{"split in the middle", []string{"192.0.2.0/24"}, []string{"192.0.2.128"},
+			[]string{"192.0.2.0/25", "192.0.2.129", "192.0.2.130/31", "192.0.2.132/30", "192.0.2.136/29", "192.0.2.144/28", "192.0.2.160/27", "192.0.2.192/26"}},
+		{"remove first address", []string{"10.0.0.0/30"}, []string{"10.0.0.0"}, []string{"10.0.0.1", "10.0.0.2/31"}},
+		{"remove last address", []string{"10.0.0.0/30"}, []string{"10.0.0.3"}, []string{"10.0.0.0/31", "10.0.0.2"}},
+		{"full range", []string{"192.0.2.0/24", "2001:db8::/64"}, []string{"192.0.2.0/24"}, []string{"2001:db8::/64"}},
+		{"exclude covers more", []string{"192.0.2.0/24"}, []string{"192.0.0.0/16"}, nil},
+		{"exclude spans two ranges", []string{"10.0.0.0/24", "10.0.2.0/24"}, []string{"10.0.0.128/25", "10.0.1.0/24", "10.0.2.0/25"},
+			[]string{"10.0.0.0/25", "10.0.2.128/25"}},
+		{"several holes", []string{"10.0.0.0/29"}, []string{"10.0.0.1", "10.0.0.4"}, []string{"10.0.0.0", "10.0.0.2/31", "10.0.0.5", "10.0.0.6/31"}},
+		{"disjoint exclude", []string{"10.0.0.0/24"}, []string{"172.16.0.0/12", "2001:db8::/32"}, []string{"10.0.0.0/24"}},
+		{"ipv4 exclude does not touch ipv6", []string{"::/0"}, []string{"0.0.0.0/0"}, []string{"::/0"}},
+		{"whole ipv4 space minus one", []string{"0.0.0.0/0"}, []string{"0.0.0.0/1"}, []string{"128.0.0.0/1"}},
+		{"whole ipv4 space minus top address", []string{"255.255.255.0/24"}, []string{"255.255.255.255"},
+			[]string{"255.255.255.0/25", "255.255.255.128/26", "255.255.255.192/27", "255.255.255.224/28", "255.255.255.240/29", "255.255.255.248/30", "255.255.255.252/31", "255.255.255.254"}},
+		{"ipv6 /128 hole", []string{"2001:db8::/126"}, []string{"2001:db8::2"}, []string{"2001:db8::/127", "2001:db8::3"}},
+		{"ipv6 full range", []string{"::/0"}, []string{"::/0"}, nil},
+		{"ipv6 split", []string{"::/0"}, []string{"8000::/1"}, []string{"::/1"}},
+	}
+	for _, c := range cases {
+		risk := mergeRanges(prefixesToRanges(parsePrefixes(t, c.risk)))
+		exclude := mergeRanges(prefixesToRanges(parsePrefixes(t, c.exclude)))
+		got := rangesToStrings(subtractRanges(risk, exclude))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRangePrefixes(t *testing.T) {
+	cases := []struct {
+		from, to string
+		want     []string
+	}{
+		{"0.0.0.0", "255.255.255.255", []string{"0.0.0.0/0"}},
+		{"::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", []string{"::/0"}},
+		{"192.0.2.7", "192.0.2.7", []string{"192.0.2.7"}},
+		{"2001:db8::1", "2001:db8::1", []string{"2001:db8::1"}},
+		{"192.0.2.1", "192.0.2.6", []string{"192.0.2.1", "192.0.2.2/31", "192.0.2.4/31", "192.0.2.6"}},
+		{"0.0.0.1", "255.255.255.255", []string{"0.0.0.1", "0.0.0.2/31", "0.0.0.4/30", "0.0.0.8/29", "0.0.0.16/28", "0.0.0.32/27", "0.0.0.64/26",
+			"0.0.0.128/25", "0.0.1.0/24", "0.0.2.0/23", "0.0.4.0/22", "0.0.8.0/21", "0.0.16.0/20", "0.0.32.0/19", "0.0.64.0/18", "0.0.128.0/17",
+			"0.1.0.0/16", "0.2.0.0/15", "0.4.0.0/14", "0.8.0.0/13", "0.16.0.0/12", "0.32.0.0/11", "0.64.0.0/10", "0.128.0.0/9",
+			"1.0.0.0/8", "2.0.0.0/7", "4.0.0.0/6", "8.0.0.0/5", "16.0.0.0/4", "32.0.0.0/3", "64.0.0.0/2", "128.0.0.0/1"}},
+	}
+	for _, c := range cases {
+		r := ipRange{from: netip.MustParseAddr(c.from), to: netip.MustParseAddr(c.to)}
+		if got := rangesToStrings([]ipRange{r}); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s-%s: got %v, want %v", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+func TestExportRiskList(t *testing.T) {
+	risk, safe := NewListGroup(), NewListGroup()
+	risk.AddList(NewNetListInfo("a", 1), nil, parsePrefixes(t, []string{"10.0.0.0/24", "2001:db8::/127"}))
+	risk.AddList(NewNetListInfo("b", 2), parseAddrs(t, "10.0.1.0", "192.0.2.9"), parsePrefixes(t, []string{"10.0.1.0/24"}))
+	safe.AddList(NewNetListInfo("office", 0), parseAddrs(t, "10.0.0.255", "2001:db8::1"), nil)
+
+	result, err := ExportRiskList(risk, safe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Lists, []string{"a", "b"}) || result.Count != len(result.IPv4)+len(result.IPv6) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	var buf bytes.Buffer
+	if err := WriteExport(&buf, result, ExportOptions{Format: ExportFormatText}); err != nil {
+		t.Fatal(err)
+	}
+	want := "10.0.0.0/25\n10.0.0.128/26\n10.0.0.192/27\n10.0.0.224/28\n10.0.0.240/29\n10.0.0.248/30\n10.0.0.252/31\n10.0.0.254\n10.0.1.0/24\n192.0.2.9\n2001:db8::\n"
+	if buf.String() != want {
+		t.Fatalf("text export:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	if _, err := ExportRiskList(risk, safe, []string{"a", "missing"}); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected unknown list error, got %v", err)
+	}
+	only, err := ExportRiskList(risk, nil, []string{"b"})
+	if err != nil || len(only.IPv6) != 0 || len(only.IPv4) != 2 {
+		t.Fatalf("export of list b: %+v, %v", only, err)
+	}
+}
+
+// parseAddrs 解析测试用的 IP 地址列表
+func parseAddrs(t *testing.T, entries ...string) []netip.Addr {
+	t.Helper()
+	addrs := make([]netip.Addr, 0, len(entries))
+	for _, p := range parsePrefixes(t, entries) {
+		addrs = append(addrs, p.Addr())
+	}
+	return addrs
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/netip"
 	"os"
 	"strings"
@@ -21,13 +22,13 @@ var RiskListData *ListGroup // 全局风险 IP 数据实例
 
 // LoadIPList 加载IP列表（通用函数，用于 safe_list 和 risk_list）
 // wg: 可选的WaitGroup，用于等待初始加载完成（仅首次加载时使用）
-func LoadIPList(ctx context.Context, lists []IPList, data *ListGroup, listType string, wg *sync.WaitGroup) {
+// waitDownload: URL 列表命中磁盘缓存时是否仍等待首次下载完成 (或失败) 后才通知 wg
+func LoadIPList(ctx context.Context, lists []IPList, data *ListGroup, listType string, wg *sync.WaitGroup, waitDownload bool) {
 	client := req.C()
 
 	for _, list := range lists {
 		if len(list.IPs) > 0 {
 			ips, cidrs := parseLines(list.IPs)
-			data.DelList(list.Name)
 			data.AddList(NewNetListInfo(list.Name, list.Level), ips, cidrs)
 			logrus.Infof("Loaded %d IPs and %d CIDRs from manual list [%s] %s", len(ips), len(cidrs), listType, list.Name)
 			// 手动 IP 列表是同步加载的，不需要 WaitGroup
@@ -38,7 +39,10 @@ func LoadIPList(ctx context.Context, lists []IPList, data *ListGroup, listType s
 			}
 			go func(list IPList) {
 				// 首次加载
-				err := loadFromFile(list, data, listType)
+				err := observeListUpdate(listType, list.Name, func() error { return loadFromFile(ctx, list, data, listType) })
+				if ctx.Err() == nil {
+					recordListUpdate(listType, list, err)
+				}
 				if err != nil {
 					logrus.Errorf("Failed to load from file %s: %v", list.File, err)
 				}
@@ -62,7 +66,10 @@ func LoadIPList(ctx context.Context, lists []IPList, data *ListGroup, listType s
 							return
 						case <-time.After(list.UpdateIntervalParsed):
 						}
-						err := loadFromFile(list, data, listType)
+						err := observeListUpdate(listType, list.Name, func() error { return loadFromFile(ctx, list, data, listType) })
+						if ctx.Err() == nil {
+							recordListUpdate(listType, list, err)
+						}
 						if err != nil {
 							logrus.Errorf("Failed to load from file %s: %v", list.File, err)
 						}
@@ -75,13 +82,27 @@ func LoadIPList(ctx context.Context, lists []IPList, data *ListGroup, listType s
 				wg.Add(1)
 			}
 			go func(list IPList) {
-				// 首次加载
-				err := downloadAndParse(client, list, data, listType)
+				// 先加载磁盘缓存：命中时首次加载即完成，不必等待下载 (waitDownload 除外)；下载失败时继续使用缓存
+				// validators 为当前数据对应的 ETag/Last-Modified，用于条件请求 (未修改时跳过解析)
+				var validators listValidators
+				meta := loadListCache(ctx, list, data, listType)
+				doneEarly := meta != nil && !waitDownload
+				if meta != nil {
+					validators = meta.listValidators
+				}
+				if doneEarly && wg != nil {
+					wg.Done()
+				}
+				// 首次下载
+				err := observeListUpdate(listType, list.Name, func() error { return downloadAndParse(ctx, client, list, data, listType, &validators) })
+				if ctx.Err() == nil {
+					recordListUpdate(listType, list, err)
+				}
 				if err != nil {
 					logrus.Errorf("Failed to download %s: %v", list.URL, err)
 				}
 				// 首次加载完成，通知 WaitGroup
-				if wg != nil {
+				if !doneEarly && wg != nil {
 					wg.Done()
 				}
 				// 如果需要周期性更新，继续循环
@@ -94,7 +115,10 @@ func LoadIPList(ctx context.Context, lists []IPList, data *ListGroup, listType s
 							return
 						case <-time.After(list.UpdateIntervalParsed):
 						}
-						err := downloadAndParse(client, list, data, listType)
+						err := observeListUpdate(listType, list.Name, func() error { return downloadAndParse(ctx, client, list, data, listType, &validators) })
+						if ctx.Err() == nil {
+							recordListUpdate(listType, list, err)
+						}
 						if err != nil {
 							logrus.Errorf("Failed to download %s: %v", list.URL, err)
 						}
@@ -107,44 +131,71 @@ func LoadIPList(ctx context.Context, lists []IPList, data *ListGroup, listType s
 	}
 }
 
-// ipToUint32 将 netip.Addr 转换为 uint32 (大端序逻辑适配 Trie)
-func ipToUint32(addr netip.Addr) uint32 {
-	b := addr.As4()
-	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
-}
-
 // downloadAndParse 下载并解析IP列表
-func downloadAndParse(client *req.Client, list IPList, data *ListGroup, listType string) error {
+// ctx 取消时 (列表在重载配置时被修改或删除) 中止下载，且不再更新 data
+// validators 非空时发送条件请求，服务器返回 304 时保留当前数据不再解析；下载成功后更新为新的 ETag/Last-Modified
+// .gz/.bz2/.zip 内容 (按 URL 扩展名或 Content-Type 判断) 会先解压
+func downloadAndParse(ctx context.Context, client *req.Client, list IPList, data *ListGroup, listType string, validators *listValidators) error {
 	// 创建独立的 Client 副本避免并发修改共享实例
 	c := client.Clone()
 	if list.TimeoutParsed > 0 {
 		c.SetTimeout(list.TimeoutParsed)
 	}
 
-	req := c.R().SetHeaders(list.CustomHeaders)
+	req := c.R().SetContext(ctx).SetHeaders(list.CustomHeaders)
 	if list.RetryCount > 0 {
 		req.SetRetryCount(list.RetryCount)
 	}
+	if validators.ETag != "" {
+		req.SetHeader("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.SetHeader("If-Modified-Since", validators.LastModified)
+	}
 
 	resp, err := req.Get(list.URL)
 	if err != nil {
 		return err
 	}
 
+	if resp.StatusCode == http.StatusNotModified {
+		// 服务器未返回新的校验信息时沿用原值
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			validators.ETag = etag
+		}
+		if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+			validators.LastModified = lastModified
+		}
+		if err := touchListCache(listType, list, time.Now(), *validators); err != nil {
+			logrus.Warnf("Failed to update cache of list [%s] %s: %v", listType, list.Name, err)
+		}
+		logrus.Infof("List [%s] %s not modified, keeping current data, %s", listType, list.Name, list.URL)
+		return nil
+	}
+
 	if resp.StatusCode != 200 {
 		return fmt.Errorf("HTTP %d", resp.StatusCode)
 	}
 
-	body := resp.String()
+	body, err := decompressListBody(list.URL, resp.Header.Get("Content-Type"), list.Format, resp.Bytes())
+	if err != nil {
+		return err
+	}
 
-	ips, cidrs, err := parseIPsFromContent(list.Format, body, list.CSVColumn, list.JSONPath)
+	ips, cidrs, err := parseIPsFromContent(list.Format, string(body), list.CSVColumn, list.JSONPath)
 	if err != nil {
 		return err
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	data.DelList(list.Name)
 	data.AddList(NewNetListInfo(list.Name, list.Level), ips, cidrs)
 	logrus.Infof("Downloaded %d IPs and %d CIDRs from [%s] %s, %s", len(ips), len(cidrs), listType, list.Name, list.URL)
+	*validators = listValidators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	if err := saveListCache(listType, list, ips, cidrs, time.Now(), *validators); err != nil {
+		logrus.Warnf("Failed to cache list [%s] %s: %v", listType, list.Name, err)
+	}
 	configMutex.RLock()
 	isDebug := config.Logging.Level == "debug"
 	configMutex.RUnlock()
@@ -154,32 +205,39 @@ func downloadAndParse(client *req.Client, list IPList, data *ListGroup, listType
 			if i >= 10 {
 				break
 			}
-			logrus.Debugf(" - %s", Uint32ToIPv4(ip).String())
+			logrus.Debugf(" - %s", ip.String())
 		}
 	}
 	return nil
 }
 
 // loadFromFile 从文件加载IP列表
-func loadFromFile(list IPList, data *ListGroup, listType string) error {
+// ctx 取消时 (列表在重载配置时被修改或删除) 不再更新 data；.gz/.bz2/.zip 文件会先解压
+func loadFromFile(ctx context.Context, list IPList, data *ListGroup, listType string) error {
 	body, err := os.ReadFile(list.File)
 	if err != nil {
 		return err
 	}
+	body, err = decompressListBody(list.File, "", list.Format, body)
+	if err != nil {
+		return err
+	}
 
 	ips, cidrs, err := parseIPsFromContent(list.Format, string(body), list.CSVColumn, list.JSONPath)
 	if err != nil {
 		return err
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	data.DelList(list.Name)
 	data.AddList(NewNetListInfo(list.Name, list.Level), ips, cidrs)
 	logrus.Infof("Loaded %d IPs and %d CIDRs from file [%s] %s", len(ips), len(cidrs), listType, list.Name)
 	return nil
 }
 
 // parseIPsFromContent 根据格式解析IP列表（支持CIDR）
-func parseIPsFromContent(format, body, csvColumn, jsonPath string) ([]uint32, []netip.Prefix, error) {
+func parseIPsFromContent(format, body, csvColumn, jsonPath string) ([]netip.Addr, []netip.Prefix, error) {
 	switch strings.ToLower(format) {
 	case "text", "": // 默认文本格式
 		return parseText(body)
@@ -195,7 +253,7 @@ func parseIPsFromContent(format, body, csvColumn, jsonPath string) ([]uint32, []
 }
 
 // parseText 解析文本格式，每行一个IP（支持CIDR）
-func parseText(body string) ([]uint32, []netip.Prefix, error) {
+func parseText(body string) ([]netip.Addr, []netip.Prefix, error) {
 	var lines []string
 	scanner := bufio.NewScanner(strings.NewReader(body))
 	for scanner.Scan() {
@@ -215,7 +273,7 @@ func parseText(body string) ([]uint32, []netip.Prefix, error) {
 }
 
 // parseCSV 解析CSV格式
-func parseCSV(body, column string) ([]uint32, []netip.Prefix, error) {
+func parseCSV(body, column string) ([]netip.Addr, []netip.Prefix, error) {
 	reader := csv.NewReader(strings.NewReader(body))
 	records, err := reader.ReadAll()
 	if err != nil {
@@ -254,7 +312,7 @@ func parseCSV(body, column string) ([]uint32, []netip.Prefix, error) {
 }
 
 // parseJSON 解析JSON格式，支持点号分隔的路径 (如 "data.ips", "servers.list")
-func parseJSON(body, path string) ([]uint32, []netip.Prefix, error) {
+func parseJSON(body, path string) ([]netip.Addr, []netip.Prefix, error) {
 	var data interface{}
 	err := json.Unmarshal([]byte(body), &data)
 	if err != nil {
@@ -295,22 +353,21 @@ func parseJSON(body, path string) ([]uint32, []netip.Prefix, error) {
 	return ips, cidrs, nil
 }
 
-// parseLines 解析多行文本，返回IP和CIDR列表
-func parseLines(text []string) ([]uint32, []netip.Prefix) {
-	var ips []uint32
+// parseLines 解析多行文本，返回IP和CIDR列表 (支持 IPv4/IPv6)
+func parseLines(text []string) ([]netip.Addr, []netip.Prefix) {
+	var ips []netip.Addr
 	var cidrs []netip.Prefix
 	for _, line := range text {
 		if strings.Contains(line, "/") {
-			// CIDR格式
-			// 暂时不处理CIDR
-			perfix, err := netip.ParsePrefix(line)
+			// CIDR格式 (IPv4/IPv6)
+			perfix, err := netip.ParsePrefix(strings.TrimSpace(line))
 			if err != nil {
 				logrus.Warnf("Invalid CIDR: %s, skipping", line)
 				continue
 			}
-			cidrs = append(cidrs, perfix)
+			cidrs = append(cidrs, normalizePrefix(perfix))
 		} else {
-			ip, err := IPv4ToUint32(line)
+			ip, err := ParseIP(line)
 			if err != nil {
 				logrus.Warnf("Invalid IP: %s, skipping", line)
 				continue
@@ -322,7 +379,7 @@ func parseLines(text []string) ([]uint32, []netip.Prefix) {
 }
 
 // IsIPInSafeList 检查 IP 是否在安全列表中
-func IsIPInSafeList(ip uint32) bool {
+func IsIPInSafeList(ip netip.Addr) bool {
 	if SafeListData == nil {
 		return false
 	}
@@ -331,18 +388,19 @@ func IsIPInSafeList(ip uint32) bool {
 }
 
 // IsSensitiveIP 检测IP是否敏感
-func IsSensitiveIP(ip uint32) (bool, ListInfo) {
+// 返回所有命中的风险列表，按等级从高到低排序 (第一个即为最高等级)
+func IsSensitiveIP(ip netip.Addr) (bool, []ListInfo) {
 	// 判断是否在安全列表中（白名单）
 	if IsIPInSafeList(ip) {
-		return false, ListInfo{}
+		return false, nil
 	}
 	// 判断是否在风险IP列表中
 	if RiskListData == nil {
-		return false, ListInfo{}
+		return false, nil
 	}
-	found, info := RiskListData.Contains(ip)
-	if found {
-		return true, info
+	matches := RiskListData.Lookup(ip)
+	if len(matches) > 0 {
+		return true, matches
 	}
-	return false, ListInfo{}
+	return false, nil
 }
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LogFields 结构化日志行解析后的字段 (字段名 -> 字段值)
+type LogFields map[string]string
+
+// 支持的 target_logs 日志格式
+const (
+	LogFormatText          = "text"           // 纯文本 (默认), 不解析字段
+	LogFormatJSON          = "json"           // JSON lines, 嵌套对象以点号展开 (如 request.path)
+	LogFormatNginxCombined = "nginx_combined" // nginx combined 格式 (可选追加 "$http_x_forwarded_for")
+	LogFormatApacheCommon  = "apache_common"  // apache common 格式
+	LogFormatSyslog        = "syslog"         // syslog (RFC 3164 / RFC 5424)
+	LogFormatLogfmt        = "logfmt"         // logfmt (key=value key2="value 2")
+)
+
+// defaultIPKeys 各日志格式默认用于提取 IP 的字段, 未列出的格式默认检查所有字段
+var defaultIPKeys = map[string][]string{
+	LogFormatNginxCombined: {"remote_addr"},
+	LogFormatApacheCommon:  {"remote_addr"},
+}
+
+// nginxCombinedRegex nginx combined 格式:
+// $remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" ["$http_x_forwarded_for"]
+var nginxCombinedRegex = regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\S+) "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)"(?: "((?:[^"\\]|\\.)*)")?`)
+
+// apacheCommonRegex apache common 格式: %h %l %u %t "%r" %>s %b
+var apacheCommonRegex = regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\S+)`)
+
+// syslog3164Regex RFC 3164: <PRI>Mmm dd hh:mm:ss host tag[pid]: message
+var syslog3164Regex = regexp.MustCompile(`^(?:<(\d{1,3})>)?([A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}) (\S+) ([^:\[\s]+)(?:\[(\d+)\])?: ?(.*)$`)
+
+// syslog5424Regex RFC 5424: <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] MSG
+var syslog5424Regex = regexp.MustCompile(`^<(\d{1,3})>\d{1,2} (\S+) (\S+) (\S+) (\S+) (\S+) (-|(?:\[[^\]]*\])+) ?(.*)$`)
+
+// IsValidLogFormat 检查日志格式是否受支持
+func IsValidLogFormat(format string) bool {
+	switch strings.ToLower(format) {
+	case "", LogFormatText, LogFormatJSON, LogFormatNginxCombined, LogFormatApacheCommon, LogFormatSyslog, LogFormatLogfmt:
+		return true
+	}
+	return false
+}
+
+// ParseLogLine 按格式解析日志行, text 格式返回 nil
+func ParseLogLine(format, line string) (LogFields, error) {
+	switch strings.ToLower(format) {
+	case "", LogFormatText:
+		return nil, nil
+	case LogFormatJSON:
+		return parseJSONLine(line)
+	case LogFormatNginxCombined:
+		return parseNginxCombined(line)
+	case LogFormatApacheCommon:
+		return parseApacheCommon(line)
+	case LogFormatSyslog:
+		return parseSyslog(line)
+	case LogFormatLogfmt:
+		return parseLogfmt(line)
+	default:
+		return nil, fmt.Errorf("unsupported log format: %s", format)
+	}
+}
+
+// Get 获取字段值, 依次尝试: 原始名称、忽略大小写、规范化名称 (小写, "-" 转 "_")、
+// 以及 nginx 请求头变量形式 (如 "X-Forwarded-For" -> "http_x_forwarded_for")
+func (f LogFields) Get(key string) (string, bool) {
+	if v, ok := f[key]; ok {
+		return v, true
+	}
+	normalized := strings.ReplaceAll(strings.ToLower(key), "-", "_")
+	for _, candidate := range []string{normalized, "http_" + normalized} {
+		for k, v := range f {
+			if strings.ReplaceAll(strings.ToLower(k), "-", "_") == candidate {
+				return v, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Keys 返回排序后的字段名, 保证遍历顺序一致
+func (f LogFields) Keys() []string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// splitRequest 将 "$request" (如 "GET /index.html HTTP/1.1") 拆分为 method, path, protocol
+func splitRequest(fields LogFields) {
+	parts := strings.SplitN(fields["request"], " ", 3)
+	if len(parts) >= 2 {
+		fields["method"] = parts[0]
+		fields["path"] = parts[1]
+	}
+	if len(parts) == 3 {
+		fields["protocol"] = parts[2]
+	}
+}
+
+// parseNginxCombined 解析 nginx combined 格式
+func parseNginxCombined(line string) (LogFields, error) {
+	m := nginxCombinedRegex.FindStringSubmatch(line)
+	if m == nil {
+		return nil, fmt.Errorf("line does not match nginx_combined format")
+	}
+	fields := LogFields{
+		"remote_addr":     m[1],
+		"remote_user":     m[2],
+		"time_local":      m[3],
+		"request":         m[4],
+		"status":          m[5],
+		"body_bytes_sent": m[6],
+		"http_referer":    m[7],
+		"http_user_agent": m[8],
+	}
+	if m[9] != "" {
+		fields["http_x_forwarded_for"] = m[9]
+	}
+	splitRequest(fields)
+	return fields, nil
+}
+
+// parseApacheCommon 解析 apache common 格式
+func parseApacheCommon(line string) (LogFields, error) {
+	m := apacheCommonRegex.FindStringSubmatch(line)
+	if m == nil {
+		return nil, fmt.Errorf("line does not match apache_common format")
+	}
+	fields := LogFields{
+		"remote_addr":     m[1],
+		"remote_user":     m[2],
+		"time_local":      m[3],
+		"request":         m[4],
+		"status":          m[5],
+		"body_bytes_sent": m[6],
+	}
+	splitRequest(fields)
+	return fields, nil
+}
+
+// parseSyslog 解析 syslog 格式 (RFC 5424 优先, 其次 RFC 3164)
+// 字段: priority, timestamp, hostname, app_name, pid, msg_id, message
+func parseSyslog(line string) (LogFields, error) {
+	if m := syslog5424Regex.FindStringSubmatch(line); m != nil {
+		fields := LogFields{
+			"priority":  m[1],
+			"timestamp": m[2],
+			"hostname":  m[3],
+			"app_name":  m[4],
+			"pid":       m[5],
+			"msg_id":    m[6],
+			"message":   m[8],
+		}
+		if m[7] != "-" {
+			fields["structured_data"] = m[7]
+		}
+		return fields, nil
+	}
+	if m := syslog3164Regex.FindStringSubmatch(line); m != nil {
+		fields := LogFields{
+			"timestamp": m[2],
+			"hostname":  m[3],
+			"app_name":  m[4],
+			"message":   m[6],
+		}
+		if m[1] != "" {
+			fields["priority"] = m[1]
+		}
+		if m[5] != "" {
+			fields["pid"] = m[5]
+		}
+		return fields, nil
+	}
+	return nil, fmt.Errorf("line does not match syslog format")
+}
+
+// parseJSONLine 解析 JSON 行, 嵌套对象以点号展开, 数组以逗号拼接
+func parseJSONLine(line string) (LogFields, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &data); err != nil {
+		return nil, err
+	}
+	fields := make(LogFields)
+	flattenJSON("", data, fields)
+	return fields, nil
+}
+
+// flattenJSON 递归展开 JSON 对象
+func flattenJSON(prefix string, value interface{}, fields LogFields) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flattenJSON(key, child, fields)
+		}
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			parts = append(parts, jsonScalarString(item))
+		}
+		fields[prefix] = strings.Join(parts, ", ")
+	default:
+		fields[prefix] = jsonScalarString(v)
+	}
+}
+
+// jsonScalarString 将 JSON 标量值转换为字符串
+func jsonScalarString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	default:
+		b, _ := json.Marshal(val)
+		return string(b)
+	}
+}
+
+// parseLogfmt 解析 logfmt 格式: key=value key2="quoted value" flag
+func parseLogfmt(line string) (LogFields, error) {
+	fields := make(LogFields)
+	i := 0
+	for i < len(line) {
+		// 跳过空白
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' {
+			i++
+		}
+		key := line[start:i]
+		if key == "" {
+			i++
+			continue
+		}
+		if i >= len(line) || line[i] != '=' {
+			// 无值的 key 视为布尔标记
+			fields[key] = "true"
+			continue
+		}
+		i++ // 跳过 '='
+		if i < len(line) && line[i] == '"' {
+			i++
+			var sb strings.Builder
+			for i < len(line) && line[i] != '"' {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				sb.WriteByte(line[i])
+				i++
+			}
+			i++ // 跳过结尾 '"'
+			fields[key] = sb.String()
+		} else {
+			start = i
+			for i < len(line) && line[i] != ' ' {
+				i++
+			}
+			fields[key] = line[start:i]
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("line does not match logfmt format")
+	}
+	return fields, nil
+}
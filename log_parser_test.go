@@ -0,0 +1,155 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLogLine(t *testing.T) {
+	cases := []struct {
+		name   string
+		format string
+		line   string
+		want   LogFields // 只检查列出的字段
+		absent []string  // 不应存在的字段
+	}{
+		{
+			name:   "nginx combined",
+			format: LogFormatNginxCombined,
+			line:   `203.0.113.9 - alice [10/Oct/2026:13:55:36 +0000] "GET /index.html?q=\"x\" HTTP/1.1" 200 512 "-" "curl/8.0"`,
+			want: LogFields{
+				"remote_addr": "203.0.113.9", "remote_user": "alice", "time_local": "10/Oct/2026:13:55:36 +0000",
+				"method": "GET", "path": `/index.html?q=\"x\"`, "protocol": "HTTP/1.1",
+				"status": "200", "body_bytes_sent": "512", "http_referer": "-", "http_user_agent": "curl/8.0",
+			},
+			absent: []string{"http_x_forwarded_for"},
+		},
+		{
+			name:   "nginx combined with x-forwarded-for",
+			format: LogFormatNginxCombined,
+			line:   `10.0.0.1 - - [10/Oct/2026:13:55:36 +0000] "POST /login HTTP/2.0" 401 0 "https://example.com/" "Mozilla/5.0" "198.51.100.7, 10.0.0.2"`,
+			want:   LogFields{"remote_addr": "10.0.0.1", "method": "POST", "path": "/login", "http_x_forwarded_for": "198.51.100.7, 10.0.0.2"},
+		},
+		{
+			name:   "apache common",
+			format: LogFormatApacheCommon,
+			line:   `2001:db8::1 - - [10/Oct/2026:13:55:36 -0700] "GET /a.gif HTTP/1.0" 304 -`,
+			want:   LogFields{"remote_addr": "2001:db8::1", "method": "GET", "path": "/a.gif", "status": "304", "body_bytes_sent": "-"},
+		},
+		{
+			name:   "syslog rfc 3164",
+			format: LogFormatSyslog,
+			line:   `<34>Oct 11 22:14:15 mymachine sshd[4721]: Failed password for root from 192.0.2.10 port 22`,
+			want: LogFields{
+				"priority": "34", "timestamp": "Oct 11 22:14:15", "hostname": "mymachine", "app_name": "sshd",
+				"pid": "4721", "message": "Failed password for root from 192.0.2.10 port 22",
+			},
+		},
+		{
+			name:   "syslog rfc 3164 without priority and pid",
+			format: LogFormatSyslog,
+			line:   `Oct  1 02:03:04 host kernel: DROP SRC=192.0.2.11`,
+			want:   LogFields{"timestamp": "Oct  1 02:03:04", "hostname": "host", "app_name": "kernel", "message": "DROP SRC=192.0.2.11"},
+			absent: []string{"priority", "pid"},
+		},
+		{
+			name:   "syslog rfc 5424",
+			format: LogFormatSyslog,
+			line:   `<165>1 2026-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3"] login from 192.0.2.12`,
+			want: LogFields{
+				"priority": "165", "timestamp": "2026-10-11T22:14:15.003Z", "hostname": "mymachine.example.com",
+				"app_name": "evntslog", "pid": "-", "msg_id": "ID47",
+				"structured_data": `[exampleSDID@32473 iut="3"]`, "message": "login from 192.0.2.12",
+			},
+		},
+		{
+			name:   "syslog rfc 5424 without structured data",
+			format: LogFormatSyslog,
+			line:   `<13>1 2026-10-11T22:14:15Z host app 123 - - hello`,
+			want:   LogFields{"pid": "123", "msg_id": "-", "message": "hello"},
+			absent: []string{"structured_data"},
+		},
+		{
+			name:   "logfmt",
+			format: LogFormatLogfmt,
+			line:   `level=warn msg="login failed for \"bob\"" client_ip=192.0.2.13 dry_run`,
+			want:   LogFields{"level": "warn", "msg": `login failed for "bob"`, "client_ip": "192.0.2.13", "dry_run": "true"},
+		},
+		{
+			name:   "json nested",
+			format: LogFormatJSON,
+			line:   `{"client":{"ip":"192.0.2.14","port":443},"tags":["a","b"],"ok":true,"x":null}`,
+			want:   LogFields{"client.ip": "192.0.2.14", "client.port": "443", "tags": "a, b", "ok": "true", "x": ""},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fields, err := ParseLogLine(c.format, c.line)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			for k, v := range c.want {
+				if fields[k] != v {
+					t.Errorf("%s = %q, want %q", k, fields[k], v)
+				}
+			}
+			for _, k := range c.absent {
+				if _, ok := fields[k]; ok {
+					t.Errorf("unexpected field %s = %q", k, fields[k])
+				}
+			}
+		})
+	}
+}
+
+func TestParseLogLineMismatch(t *testing.T) {
+	cases := []struct{ format, line string }{
+		{LogFormatNginxCombined, `not an access log line`},
+		{LogFormatApacheCommon, `192.0.2.1 GET /`},
+		{LogFormatSyslog, `2026-10-11 plain text 192.0.2.1`},
+		{LogFormatJSON, `{"truncated": "192.0.2.1"`},
+		{LogFormatLogfmt, ``},
+		{"xml", `<ip>192.0.2.1</ip>`},
+	}
+	for _, c := range cases {
+		if fields, err := ParseLogLine(c.format, c.line); err == nil {
+			t.Errorf("%s: expected error for %q, got %v", c.format, c.line, fields)
+		}
+	}
+	if fields, err := ParseLogLine(LogFormatText, "192.0.2.1"); err != nil || fields != nil {
+		t.Errorf("text format: got %v, %v", fields, err)
+	}
+}
+
+func TestLogFieldsGet(t *testing.T) {
+	fields := LogFields{
+		"remote_addr":          "192.0.2.1",
+		"http_x_forwarded_for": "192.0.2.2",
+		"Client-IP":            "192.0.2.3",
+		"User.Agent":           "curl",
+	}
+	cases := []struct {
+		key, want string
+		ok        bool
+	}{
+		{"remote_addr", "192.0.2.1", true},
+		{"REMOTE_ADDR", "192.0.2.1", true},
+		{"remote-addr", "192.0.2.1", true},
+		{"X-Forwarded-For", "192.0.2.2", true},
+		{"x_forwarded_for", "192.0.2.2", true},
+		{"http_x_forwarded_for", "192.0.2.2", true},
+		{"client_ip", "192.0.2.3", true},
+		{"Client-IP", "192.0.2.3", true},
+		{"user.agent", "curl", true},
+		{"forwarded_for", "", false},
+	}
+	for _, c := range cases {
+		got, ok := fields.Get(c.key)
+		if got != c.want || ok != c.ok {
+			t.Errorf("Get(%q) = %q, %v; want %q, %v", c.key, got, ok, c.want, c.ok)
+		}
+	}
+	if keys := fields.Keys(); !reflect.DeepEqual(keys, []string{"Client-IP", "User.Agent", "http_x_forwarded_for", "remote_addr"}) {
+		t.Errorf("Keys() = %v", keys)
+	}
+}
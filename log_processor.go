@@ -5,27 +5,115 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/netip"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hpcloud/tail"
 	"github.com/sirupsen/logrus"
 )
 
-// ipv4Regex 用于匹配IPv4地址的正则表达式
-var ipv4Regex = regexp.MustCompile(`\b((25[0-5]|(2[0-4]|1\d|[1-9]|)\d)\.?\b){4}\b`)
+// ipRegex 用于匹配 IPv4 地址以及 IPv6 候选串的正则表达式
+// IPv6 候选串 (含内嵌 IPv4 形式, 如 ::ffff:1.2.3.4) 需再经 netip 校验，以排除时间戳、MAC 地址等误匹配
+var ipRegex = regexp.MustCompile(`\b((25[0-5]|(2[0-4]|1\d|[1-9]|)\d)\.?\b){4}\b|[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}(?:\.\d{1,3}){0,3}`)
 
-// ExtractIPFromLine 从日志行中提取IP地址
-func ExtractIPFromLine(line string) (uint32, error) {
-	// 正则表达式匹配IPv4地址
-	ip := ipv4Regex.FindString(line)
-	if ip == "" {
-		return 0, fmt.Errorf("no valid IPv4 address found")
+// isIPTokenChar 判断字符是否可能属于 IP 地址 token 的一部分
+func isIPTokenChar(c byte) bool {
+	return c == ':' || c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// ExtractedIP 从日志行中提取出的 IP 及其来源字段
+type ExtractedIP struct {
+	IP    netip.Addr // IP 地址
+	Field string     // 来源字段: 结构化格式的字段名, ip_regex 的命名捕获组名, ip_field 的 "$N", 或未配置时的 "#N" (行内第 N 个地址)
+}
+
+// FindIPs 查找文本中的所有 IP 地址 (IPv4/IPv6)，按出现顺序返回
+func FindIPs(text string) []netip.Addr {
+	var ips []netip.Addr
+	for _, loc := range ipRegex.FindAllStringIndex(text, -1) {
+		candidate := text[loc[0]:loc[1]]
+		if strings.Contains(candidate, ":") {
+			// IPv6 候选串必须是完整 token，避免从 "Class::ab" 之类的文本中截取
+			if (loc[0] > 0 && isIPTokenChar(text[loc[0]-1])) || (loc[1] < len(text) && isIPTokenChar(text[loc[1]])) {
+				continue
+			}
+		}
+		ip, err := ParseIP(candidate)
+		if err != nil {
+			continue
+		}
+		ips = append(ips, ip)
 	}
-	ip32, _ := IPv4ToUint32(ip)
-	return ip32, nil
+	return ips
+}
+
+// ExtractIPsFromLine 按目标日志的配置从日志行中提取需要检查的 IP
+//   - 结构化格式 (fields 非 nil): 提取 ip_keys 指定字段中的 IP，未指定时检查所有字段，字段名即来源字段
+//   - 配置了 ip_regex: 提取所有命名捕获组中的 IP，字段名为捕获组名
+//   - 配置了 ip_field: 提取按空白分隔后第 N 个字段中的 IP (负数表示倒数)，字段名为 "$N"
+//   - 均未配置: 提取行内所有 IP，字段名为 "#N"
+//
+// 同一行内重复出现的 IP 只保留第一次出现的字段
+func ExtractIPsFromLine(line string, fields LogFields, lf *TargetLog) []ExtractedIP {
+	var result []ExtractedIP
+	seen := make(map[netip.Addr]struct{})
+	add := func(ip netip.Addr, field string) {
+		if _, ok := seen[ip]; ok {
+			return
+		}
+		seen[ip] = struct{}{}
+		result = append(result, ExtractedIP{IP: ip, Field: field})
+	}
+
+	switch {
+	case fields != nil:
+		keys := lf.IPKeys
+		if len(keys) == 0 {
+			keys = fields.Keys()
+		}
+		for _, key := range keys {
+			value, ok := fields.Get(key)
+			if !ok {
+				continue
+			}
+			for _, ip := range FindIPs(value) {
+				add(ip, key)
+			}
+		}
+	case lf.IPRegexParsed != nil:
+		names := lf.IPRegexParsed.SubexpNames()
+		for _, match := range lf.IPRegexParsed.FindAllStringSubmatch(line, -1) {
+			for i, name := range names {
+				if name == "" || match[i] == "" {
+					continue
+				}
+				for _, ip := range FindIPs(match[i]) {
+					add(ip, name)
+				}
+			}
+		}
+	case lf.IPField != 0:
+		fields := strings.Fields(line)
+		idx := lf.IPField - 1
+		if lf.IPField < 0 {
+			idx = len(fields) + lf.IPField
+		}
+		if idx >= 0 && idx < len(fields) {
+			for _, ip := range FindIPs(fields[idx]) {
+				add(ip, fmt.Sprintf("$%d", lf.IPField))
+			}
+		}
+	default:
+		for i, ip := range FindIPs(line) {
+			add(ip, fmt.Sprintf("#%d", i+1))
+		}
+	}
+	return result
 }
 
 // processOnceMode 处理once模式
@@ -63,7 +151,7 @@ func processFileOnce(lf TargetLog) {
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
-		processLine(line, info, lf.IgnoreKeys)
+		processLine(line, info, &lf)
 	}
 	if err := scanner.Err(); err != nil {
 		logrus.Errorf("Error reading file %s: %v", lf.Path, err)
@@ -81,15 +169,24 @@ func processFileOnce(lf TargetLog) {
 	}
 }
 
+// tail 模式的文件变化检测方式 (target_logs.watch)
+const (
+	TailWatchPoll    = "poll"    // 定期检查文件大小与修改时间 (默认)
+	TailWatchInotify = "inotify" // 使用 inotify (Linux) 等系统通知
+)
+
 // processTailMode 处理tail模式
+// 读取进度 (inode + offset) 会定期以及退出时写入 checkpoint，重启或重载配置后从 checkpoint 继续读取
 func processTailMode(ctx context.Context, lf TargetLog) {
 	for {
 		// 每次循环重新创建info，避免重复计数
 		var info = NewNetListInfo(lf.Name, lf.Level)
 
 		// 等待文件存在
+		var stat os.FileInfo
 		for {
-			if _, err := os.Stat(lf.Path); err == nil {
+			var err error
+			if stat, err = os.Stat(lf.Path); err == nil {
 				break
 			}
 			logrus.Warnf("File %s does not exist, retrying in 1 second...", lf.Path)
@@ -101,10 +198,17 @@ func processTailMode(ctx context.Context, lf TargetLog) {
 			}
 		}
 
+		// 根据 checkpoint 确定起始位置
+		cp := TailCheckpoint{Path: lf.Path, Inode: fileInode(stat), Offset: resolveTailStart(lf, stat)}
+
+		// 不自动 ReOpen：文件被轮转 (移动/删除) 时 tail 退出，由外层循环重新打开并根据 inode 从头读取
+		// 默认使用轮询而非 inotify：tail 库共享的 inotify watch 在同一文件被反复 tail 时 (重载/轮转) 会丢失事件
 		t, err := tail.TailFile(lf.Path, tail.Config{
-			ReOpen:   true,
+			ReOpen:   false,
+			Poll:     lf.Watch != TailWatchInotify,
 			Follow:   true,
-			Location: &tail.SeekInfo{Offset: 0, Whence: io.SeekEnd}, // 从尾部开始, 从头开始 io.SeekStart
+			Location: &tail.SeekInfo{Offset: cp.Offset, Whence: io.SeekStart},
+			Logger:   tail.DiscardingLogger,
 		})
 		if err != nil {
 			logrus.Errorf("Failed to tail file %s: %v, retrying in 1 second...", lf.Path, err)
@@ -118,24 +222,71 @@ func processTailMode(ctx context.Context, lf TargetLog) {
 		}
 
 		// 当 context 取消时，停止 tailer
+		tailDone := make(chan struct{})
 		go func() {
-			<-ctx.Done()
-			t.Stop()
+			select {
+			case <-ctx.Done():
+				t.Stop()
+			case <-tailDone:
+			}
 		}()
 
-		for line := range t.Lines {
-			if line.Err != nil {
-				logrus.Errorf("Error reading line from %s: %v", lf.Path, line.Err)
-				continue
+		ticker := time.NewTicker(lf.CheckpointParsed)
+		lastSaved := int64(-1)
+		save := func() {
+			if cp.Offset == lastSaved {
+				return
+			}
+			if err := saveCheckpoint(lf, cp); err != nil {
+				logrus.Errorf("Failed to save checkpoint for %s: %v", lf.Name, err)
+				return
 			}
-			logrus.Debugf("Read line from %s, level: %d, line: %s", lf.Name, info.Level, line.Text)
-			processLine(line.Text, info, lf.IgnoreKeys)
-			// tail 模式下，每行后检查通知
-			CheckAndNotify(info, false)
+			lastSaved = cp.Offset
 		}
+		// truncated 文件被截断后 tail 会自动从头重新读取，但不会告知新的位置
+		// 因此截断后不再处理 tail 的输出，停止 tail 并从偏移 0 重新开始，保证偏移与实际读取的内容一致
+		truncated := false
 
-		// 如果 tail 退出（例如文件被删除），清理并重新开始循环
-		t.Cleanup()
+	readLoop:
+		for {
+			select {
+			case line, ok := <-t.Lines:
+				if !ok {
+					break readLoop
+				}
+				if truncated {
+					continue
+				}
+				if line.Err != nil {
+					logrus.Errorf("Error reading line from %s: %v", lf.Path, line.Err)
+					continue
+				}
+				// tail 只在读到文件末尾后检测截断并从头重新读取，因此截断后的第一行到达时文件通常小于已处理的偏移
+				if stat, err := os.Stat(lf.Path); err == nil && checkpointTruncated(cp, stat) {
+					logrus.Infof("File %s was truncated (offset %d > size %d), reading from beginning", lf.Path, cp.Offset, stat.Size())
+					truncated = true
+					cp.Offset = 0
+					save()
+					go t.Stop()
+					continue
+				}
+				// 行尾的 '\n' 已被 tail 去除
+				cp.Offset += int64(len(line.Text)) + 1
+				logrus.Debugf("Read line from %s, level: %d, line: %s", lf.Name, info.Level, line.Text)
+				processLine(line.Text, info, &lf)
+				// tail 模式下，每行后检查通知
+				CheckAndNotify(info, false)
+			case <-ticker.C:
+				save()
+			}
+		}
+		ticker.Stop()
+		save()
+
+		close(tailDone)
+
+		// 如果 tail 退出（例如文件被删除），重新开始循环
+		// 不调用 t.Cleanup()：它仅用于进程退出时清理 inotify 状态，重复调用会破坏共享 watch 的计数
 
 		// 检查是否是 context 取消导致的退出
 		select {
@@ -145,28 +296,81 @@ func processTailMode(ctx context.Context, lf TargetLog) {
 		default:
 		}
 
-		logrus.Warnf("Tail for %s ended, will retry with fresh state...", lf.Path)
+		if !truncated {
+			logrus.Warnf("Tail for %s ended, will retry with fresh state...", lf.Path)
+		}
 		// info 会在下次循环开始时重新创建，避免累积旧数据
 	}
 }
 
+// parseWarnInterval 同一目标日志解析失败告警的最小间隔 (期间的失败只计数)
+const parseWarnInterval = time.Minute
+
+// parseWarnState 目标日志的解析失败告警状态
+type parseWarnState struct {
+	lastWarn   time.Time
+	suppressed int
+}
+
+var parseWarnStates = make(map[string]*parseWarnState)
+var parseWarnMutex sync.Mutex
+
+// warnParseFailure 按目标日志限速输出解析失败告警，并记录解析失败指标
+func warnParseFailure(name, format string, err error) {
+	MetricLineParseFailures.Inc(name)
+	parseWarnMutex.Lock()
+	defer parseWarnMutex.Unlock()
+	st := parseWarnStates[name]
+	if st == nil {
+		st = &parseWarnState{}
+		parseWarnStates[name] = st
+	}
+	now := time.Now()
+	if now.Sub(st.lastWarn) < parseWarnInterval {
+		st.suppressed++
+		return
+	}
+	logrus.Warnf("Failed to parse line from %s as %s: %v (%d more failures since last warning), extracting IPs from the whole line", name, format, err, st.suppressed)
+	st.lastWarn = now
+	st.suppressed = 0
+}
+
+// parseAndExtractIPs 按目标日志的格式解析日志行并提取 IP
+// 无法按格式解析时 (格式不匹配、日志轮转后格式变化等) 退回按 text 格式从整行提取，避免漏检风险 IP
+func parseAndExtractIPs(line, name string, lf *TargetLog) (LogFields, []ExtractedIP) {
+	fields, err := ParseLogLine(lf.Format, line)
+	if err != nil {
+		warnParseFailure(name, lf.Format, err)
+		return nil, ExtractIPsFromLine(line, nil, lf)
+	}
+	return fields, ExtractIPsFromLine(line, fields, lf)
+}
+
 // processLine 处理单行日志
-func processLine(line string, finfo ListInfo, ignoreKeys []string) {
-	for _, key := range ignoreKeys {
+// 检查行内提取出的每一个 IP，命中风险列表的 IP 均会加入通知统计
+func processLine(line string, finfo ListInfo, lf *TargetLog) {
+	MetricLinesProcessed.Inc(finfo.Name)
+	for _, key := range lf.IgnoreKeys {
 		if strings.Contains(line, key) {
 			logrus.Debugf("Line contains ignore key %q, skipping: %s", key, line)
 			return
 		}
 	}
 
-	ip, err := ExtractIPFromLine(line)
-	if err != nil {
+	fields, extracted := parseAndExtractIPs(line, finfo.Name, lf)
+	if len(extracted) == 0 {
 		// 没有找到有效IP，记录调试信息后跳过
-		logrus.Debugf("No valid IP in line from %s: %v", finfo.Name, err)
+		logrus.Debugf("No valid IP in line from %s", finfo.Name)
 		return
 	}
-	if isSensitive, linfo := IsSensitiveIP(ip); isSensitive {
-		logrus.Warnf("Found sensitive IP %s from %s, level: %d in line: %s", Uint32ToIPv4(ip).String(), linfo.Name, linfo.Level, line)
-		AddNotificationItem(ip, finfo, linfo)
+	MetricIPsExtracted.Add(float64(len(extracted)), finfo.Name)
+	for _, e := range extracted {
+		if isSensitive, matches := IsSensitiveIP(e.IP); isSensitive {
+			for _, m := range matches {
+				MetricRiskHits.Inc(m.Name, strconv.Itoa(m.Level))
+			}
+			logrus.Warnf("Found sensitive IP %s (field %s) from %s, level: %d in line: %s", e.IP.String(), e.Field, formatListInfos(matches), matches[0].Level, line)
+			AddNotificationItem(e.IP, finfo, matches, e.Field, fields)
+		}
 	}
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseFailureFallsBackToWholeLine 无法按格式解析的行退回从整行提取 IP，并计入解析失败指标
+func TestParseFailureFallsBackToWholeLine(t *testing.T) {
+	lf := &TargetLog{Name: "fallback-test", Format: LogFormatNginxCombined}
+	t.Cleanup(func() {
+		MetricLineParseFailures.DeleteLabelValues(lf.Name)
+		parseWarnMutex.Lock()
+		delete(parseWarnStates, lf.Name)
+		parseWarnMutex.Unlock()
+	})
+
+	fields, extracted := parseAndExtractIPs(`{"client": "198.51.100.7"} rotated to json`, lf.Name, lf)
+	if fields != nil || len(extracted) != 1 || extracted[0].IP != netip.MustParseAddr("198.51.100.7") || extracted[0].Field != "#1" {
+		t.Fatalf("fallback extraction: fields=%v extracted=%+v", fields, extracted)
+	}
+	parseAndExtractIPs(`still not nginx 198.51.100.8`, lf.Name, lf)
+	if out := metricText(MetricLineParseFailures); !strings.Contains(out, `iplog_line_parse_failures_total{log="fallback-test"} 2`) {
+		t.Fatalf("parse failures not counted:\n%s", out)
+	}
+	parseWarnMutex.Lock()
+	suppressed := parseWarnStates[lf.Name].suppressed
+	parseWarnMutex.Unlock()
+	if suppressed != 1 {
+		t.Fatalf("second failure within the warn interval should be suppressed, got %d", suppressed)
+	}
+
+	fields, extracted = parseAndExtractIPs(`198.51.100.9 - - [10/Oct/2026:13:55:36 +0000] "GET / HTTP/1.1" 200 1 "-" "-"`, lf.Name, lf)
+	if fields == nil || len(extracted) != 1 || extracted[0].Field != "remote_addr" {
+		t.Fatalf("parsed line: fields=%v extracted=%+v", fields, extracted)
+	}
+}
+
+func TestFindIPs(t *testing.T) {
+	cases := []struct {
+		text string
+		want []string
+	}{
+		{"client 192.0.2.1 connected", []string{"192.0.2.1"}},
+		{"192.0.2.1:8080 -> 198.51.100.2:443", []string{"192.0.2.1", "198.51.100.2"}},
+		{"from 2001:db8::1 port 22", []string{"2001:db8::1"}},
+		{"[2001:db8::2]:443 GET /", []string{"2001:db8::2"}},
+		{"peer ::ffff:192.0.2.3 via fe80::1%eth0", []string{"192.0.2.3", "fe80::1"}},
+		{"x-forwarded-for: 2001:db8::a, 192.0.2.4", []string{"2001:db8::a", "192.0.2.4"}},
+		{"loopback ::1 ok", []string{"::1"}},
+		{"full 2001:0db8:0000:0000:0000:ff00:0042:8329 form", []string{"2001:db8::ff00:42:8329"}},
+		// 误匹配的 IPv6 候选串
+		{"2026-10-17 12:34:56 started", nil},
+		{"at 12:34:56.789 done", nil},
+		{"elapsed 01:02:03:04", nil},
+		{"eth0 link/ether 00:1a:2b:3c:4d:5e brd ff:ff:ff:ff:ff:ff", nil},
+		{"called std::vector::push_back", nil},
+		{"hash deadbeef:cafe:babe", nil},
+		{"version 1.2.3 build 999.1.1.1", nil},
+	}
+	for _, c := range cases {
+		var got []string
+		for _, ip := range FindIPs(c.text) {
+			got = append(got, ip.String())
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("FindIPs(%q) = %v, want %v", c.text, got, c.want)
+		}
+	}
+}
@@ -21,27 +21,39 @@ var Version string = "dev"
 var appCtx context.Context
 var appCancel context.CancelFunc
 
-func initAPP() error {
-	// 如果已有运行中的 goroutine，先取消旧 context
-	if appCancel != nil {
-		appCancel()
-	}
+// tailProcessorsWG 等待 tail 模式处理器退出 (退出前会保存 checkpoint)
+var tailProcessorsWG sync.WaitGroup
 
-	data, err := os.ReadFile(ConfigFilePath)
+// loadConfigFile 读取、解析并初始化配置文件
+func loadConfigFile(path string) (Config, error) {
+	var newConfig Config
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("Error reading config file: %v\n", err)
+		return newConfig, fmt.Errorf("Error reading config file: %v\n", err)
 	}
 
-	var newConfig Config
 	err = yaml.Unmarshal(data, &newConfig)
 	if err != nil {
-		return fmt.Errorf("Error parsing YAML: %v\n", err)
+		return newConfig, fmt.Errorf("Error parsing YAML: %v\n", err)
 	}
 
 	// 初始化应用配置
 	err = initAppConfig(&newConfig)
 	if err != nil {
-		return fmt.Errorf("Error initializing app config: %v\n", err)
+		return newConfig, fmt.Errorf("Error initializing app config: %v\n", err)
+	}
+	return newConfig, nil
+}
+
+// initAPP 加载配置并启动 (首次调用) 或增量重载 (后续调用) 后台任务
+// 重载时只重启配置发生变化的列表与目标日志，配置文件无效时保持当前状态继续运行
+func initAPP() error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	newConfig, err := loadConfigFile(ConfigFilePath)
+	if err != nil {
+		return err
 	}
 
 	// 原子更新全局配置
@@ -49,55 +61,67 @@ func initAPP() error {
 	config = newConfig
 	configMutex.Unlock()
 
-	// 创建新的 context 控制所有后台 goroutine
-	appCtx, appCancel = context.WithCancel(context.Background())
+	// 重建通知客户端注册表 (配置未变化的通知项复用原客户端)
+	BuildNotifierRegistry(newConfig.Notifications.Services, newConfig.Notifications.TimeoutParsed)
 
-	// 初始化安全IP数据 (白名单)
-	SafeListData = NewListGroup()
-	// 初始化风险IP数据
-	RiskListData = NewListGroup()
+	firstStart := appCtx == nil
+	if firstStart {
+		// 创建 context 控制所有后台 goroutine
+		appCtx, appCancel = context.WithCancel(context.Background())
+
+		// 初始化安全IP数据 (白名单)
+		SafeListData = NewListGroup()
+		// 初始化风险IP数据
+		RiskListData = NewListGroup()
+
+		// 恢复通过 API 添加的运行时列表
+		LoadRuntimeLists()
+	} else {
+		// 待发送队列中的通知使用重载后的通知项配置
+		refreshPendingNotifications(newConfig.Notifications.Services)
+	}
 
-	// 启动加载goroutines，使用WaitGroup等待初始加载完成
+	// 启动新增或已修改列表的加载goroutines，使用WaitGroup等待其首次加载完成
+	var stats reloadStats
 	var wg sync.WaitGroup
-	configMutex.RLock()
-	LoadIPList(appCtx, config.SafeList, SafeListData, "safe_list", &wg)
-	LoadIPList(appCtx, config.RiskList, RiskListData, "risk_list", &wg)
-	configMutex.RUnlock()
+	removedSafe := reconcileIPLists(appCtx, newConfig.SafeList, SafeListData, "safe_list", &wg, &stats)
+	removedRisk := reconcileIPLists(appCtx, newConfig.RiskList, RiskListData, "risk_list", &wg, &stats)
 
 	// 等待初始加载完成
 	logrus.Info("Waiting for IP lists to load...")
 	wg.Wait()
 	logrus.Info("IP lists loaded successfully")
 
-	// 启动通知工作器 (独立 goroutine, 每 1s 检查一次，不阻塞)
-	StartNotificationWorker(appCtx)
+	// 新列表加载完成后再一次性移除已删除的列表 (重命名列表时不会出现新旧列表都缺失的窗口)
+	SafeListData.DelList(removedSafe...)
+	RiskListData.DelList(removedRisk...)
+	// 运行时列表与配置中的列表同名时以配置为准 (配置中的列表删除后恢复运行时列表)
+	ApplyRuntimeLists()
+
+	if firstStart {
+		// 恢复上次退出时未发送完成的通知
+		initNotificationQueue()
+
+		// 启动通知工作器 (独立 goroutine, 每 1s 检查一次，不阻塞)
+		StartNotificationWorker(appCtx)
+
+		// 恢复生效中的封禁记录并启动解封调度器
+		LoadBans()
+		StartBanScheduler(appCtx)
+		// 运行时列表条目的过期清理
+		StartRuntimeListScheduler(appCtx)
+		// file/url 列表的过期检查 (max_age)
+		StartListStateMonitor(appCtx)
+	}
 
-	// 启动目标日志文件处理goroutines
-	StartTargetLogProcessors(appCtx, &config)
+	// 启动新增或已修改的目标日志文件处理goroutines
+	reconcileTargetLogs(appCtx, newConfig.TargetLogs, &stats)
 
+	logrus.Infof("IP lists: %d started, %d stopped; target logs: %d started, %d stopped",
+		stats.ListsStarted, stats.ListsStopped, stats.TargetsStarted, stats.TargetsStopped)
 	return nil
 }
 
-// StartTargetLogProcessors 启动目标日志文件处理器
-func StartTargetLogProcessors(ctx context.Context, config *Config) {
-	configMutex.RLock()
-	targetLogs := make([]TargetLog, len(config.TargetLogs))
-	copy(targetLogs, config.TargetLogs)
-	configMutex.RUnlock()
-
-	for _, logFile := range targetLogs {
-		go func(lf TargetLog) {
-			if lf.ReadMode == "once" {
-				processOnceMode(ctx, lf)
-			} else if lf.ReadMode == "tail" {
-				processTailMode(ctx, lf)
-			} else {
-				logrus.Errorf("Unknown read_mode for %s: %s", lf.Name, lf.ReadMode)
-			}
-		}(logFile)
-	}
-}
-
 func main() {
 	flag.StringVar(&ConfigFilePath, "config", ConfigFilePath, "path to config file")
 	flag.StringVar(&ConfigFilePath, "c", ConfigFilePath, "path to config file")
@@ -113,6 +137,21 @@ func main() {
 		return
 	}
 
+	// 子命令
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "export":
+			if err := runExportCommand(flag.Args()[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "export failed: %v\n", err)
+				os.Exit(1)
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command: %s\n", flag.Arg(0))
+			os.Exit(2)
+		}
+		return
+	}
+
 	err := initAPP()
 	if err != nil {
 		fmt.Printf("Failed to initialize app: %v\n", err)
@@ -124,14 +163,12 @@ func main() {
 
 	// 启动 API 服务器
 	configMutex.RLock()
-	apiEnabled := config.APIServer.Enabled
-	apiAddr := config.APIServer.Addr
+	apiConfig := config.APIServer
 	configMutex.RUnlock()
 
-	if apiEnabled {
+	if apiConfig.Enabled {
 		go func() {
-			logrus.Infof("Starting API server on %s", apiAddr)
-			err := StartAPIServer(apiAddr)
+			err := StartAPIServer(apiConfig)
 			if err != nil {
 				logrus.Errorf("API server error: %v", err)
 			}
@@ -151,5 +188,9 @@ func main() {
 	if appCancel != nil {
 		appCancel()
 	}
+	// 等待 tail 处理器保存 checkpoint
+	tailProcessorsWG.Wait()
+	// 未发送完成的通知已写入持久化队列，下次启动时恢复
+	NotificationQueueStore.Close()
 	logrus.Info("Shutdown complete")
 }
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// metricVec 带标签的指标 (Prometheus 文本格式输出, 不依赖外部库)
+type metricVec struct {
+	name   string
+	help   string
+	typ    string // counter / gauge
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*metricValue
+}
+
+// metricValue 一组标签值对应的指标值
+type metricValue struct {
+	labelValues []string
+	value       float64
+}
+
+func newMetricVec(name, typ, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, typ: typ, labels: labels, values: make(map[string]*metricValue)}
+}
+
+// get 返回标签值对应的指标值 (调用方需持有 mu)
+func (m *metricVec) get(labelValues []string) *metricValue {
+	key := strings.Join(labelValues, "\xff")
+	v := m.values[key]
+	if v == nil {
+		v = &metricValue{labelValues: append([]string(nil), labelValues...)}
+		m.values[key] = v
+	}
+	return v
+}
+
+// Add 增加指标值 (线程安全)
+func (m *metricVec) Add(delta float64, labelValues ...string) {
+	m.mu.Lock()
+	m.get(labelValues).value += delta
+	m.mu.Unlock()
+}
+
+// Inc 指标值加 1 (线程安全)
+func (m *metricVec) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+// Set 设置指标值 (线程安全)
+func (m *metricVec) Set(value float64, labelValues ...string) {
+	m.mu.Lock()
+	m.get(labelValues).value = value
+	m.mu.Unlock()
+}
+
+// Reset 清空所有指标值 (线程安全, 用于每次抓取时重新计算的 gauge)
+func (m *metricVec) Reset() {
+	m.mu.Lock()
+	m.values = make(map[string]*metricValue)
+	m.mu.Unlock()
+}
+
+// DeleteLabelValues 删除标签值完全匹配的指标 (线程安全)
+func (m *metricVec) DeleteLabelValues(labelValues ...string) {
+	m.mu.Lock()
+	delete(m.values, strings.Join(labelValues, "\xff"))
+	m.mu.Unlock()
+}
+
+// DeletePartialMatch 删除指定标签为给定值的所有指标 (线程安全)
+func (m *metricVec) DeletePartialMatch(label, value string) {
+	idx := -1
+	for i, l := range m.labels {
+		if l == label {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return
+	}
+	m.mu.Lock()
+	for key, v := range m.values {
+		if v.labelValues[idx] == value {
+			delete(m.values, key)
+		}
+	}
+	m.mu.Unlock()
+}
+
+// write 以 Prometheus 文本格式输出指标 (按标签值排序)
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	values := make([]metricValue, 0, len(m.values))
+	for _, v := range m.values {
+		values = append(values, *v)
+	}
+	m.mu.Unlock()
+	sort.Slice(values, func(i, j int) bool {
+		return strings.Join(values[i].labelValues, "\xff") < strings.Join(values[j].labelValues, "\xff")
+	})
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+	for _, v := range values {
+		fmt.Fprint(w, m.name)
+		if len(m.labels) > 0 {
+			pairs := make([]string, len(m.labels))
+			for i, label := range m.labels {
+				pairs[i] = fmt.Sprintf(`%s="%s"`, label, labelValueEscaper.Replace(v.labelValues[i]))
+			}
+			fmt.Fprintf(w, "{%s}", strings.Join(pairs, ","))
+		}
+		fmt.Fprintf(w, " %s\n", strconv.FormatFloat(v.value, 'g', -1, 64))
+	}
+}
+
+// labelValueEscaper 转义标签值中的反斜杠、双引号与换行
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// 日志处理指标
+var (
+	MetricLinesProcessed    = newMetricVec("iplog_lines_processed_total", "counter", "Log lines processed per target log.", "log")
+	MetricIPsExtracted      = newMetricVec("iplog_ips_extracted_total", "counter", "IP addresses extracted from log lines per target log.", "log")
+	MetricLineParseFailures = newMetricVec("iplog_line_parse_failures_total", "counter", "Log lines that did not match the configured format per target log (IPs are extracted from the whole line).", "log")
+	MetricRiskHits          = newMetricVec("iplog_risk_hits_total", "counter", "Extracted IPs matching a risk list, per list and level.", "list", "level")
+)
+
+// 通知指标
+var (
+	MetricNotificationsSent    = newMetricVec("iplog_notifications_sent_total", "counter", "Notifications sent successfully per service.", "id", "service")
+	MetricNotificationsFailed  = newMetricVec("iplog_notifications_failed_total", "counter", "Failed notification send attempts per service.", "id", "service")
+	MetricNotificationsDropped = newMetricVec("iplog_notifications_dropped_total", "counter", "Notifications dropped without being sent per service and reason.", "id", "service", "reason")
+	MetricQueueDepth           = newMetricVec("iplog_notification_queue_depth", "gauge", "Notifications waiting in the send queue.")
+)
+
+// 通知被丢弃的原因
+const (
+	DropReasonRetriesExhausted = "retries_exhausted" // 重试耗尽 (已写入死信日志)
+	DropReasonDeliveredOther   = "delivered_other"   // delivery: any 且同组其他通知项已发送成功
+	DropReasonServiceRemoved   = "service_removed"   // 恢复队列时通知项已从配置中删除
+)
+
+// IP 列表指标
+var (
+	MetricListEntries        = newMetricVec("iplog_list_entries", "gauge", "Entries (IPs and CIDRs) currently loaded per list.", "type", "list")
+	MetricListUpdates        = newMetricVec("iplog_list_updates_total", "counter", "List download or file load attempts per list and result.", "type", "list", "result")
+	MetricListUpdateDuration = newMetricVec("iplog_list_update_duration_seconds", "gauge", "Duration of the most recent list download or file load.", "type", "list")
+	MetricListLastSuccess    = newMetricVec("iplog_list_last_success_timestamp_seconds", "gauge", "Unix time of the most recent successful list update.", "type", "list")
+	MetricListAge            = newMetricVec("iplog_list_age_seconds", "gauge", "Age of the data currently loaded for file and URL lists (cached data keeps its download time).", "type", "list")
+	MetricListStale          = newMetricVec("iplog_list_stale", "gauge", "Whether a file or URL list is older than its max_age or has never loaded (1) or not (0).", "type", "list")
+)
+
+// 其他指标
+var (
+	MetricConfigReloads = newMetricVec("iplog_config_reloads_total", "counter", "Config file reloads per result.", "result")
+	MetricActiveBans    = newMetricVec("iplog_active_bans", "gauge", "Active bans per action.", "action")
+	MetricBuildInfo     = newMetricVec("iplog_build_info", "gauge", "Build information.", "version")
+)
+
+// allMetrics 按输出顺序排列的所有指标
+var allMetrics = []*metricVec{
+	MetricLinesProcessed, MetricIPsExtracted, MetricLineParseFailures, MetricRiskHits,
+	MetricNotificationsSent, MetricNotificationsFailed, MetricNotificationsDropped, MetricQueueDepth,
+	MetricListEntries, MetricListUpdates, MetricListUpdateDuration, MetricListLastSuccess, MetricListAge, MetricListStale,
+	MetricConfigReloads, MetricActiveBans, MetricBuildInfo,
+}
+
+// observeListUpdate 执行一次列表更新并记录耗时与结果
+// 因列表停止 (配置重载删除或修改列表、程序退出) 而取消的更新不计入结果
+func observeListUpdate(listType, name string, update func() error) error {
+	start := time.Now()
+	err := update()
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	MetricListUpdateDuration.Set(time.Since(start).Seconds(), listType, name)
+	if err != nil {
+		MetricListUpdates.Inc(listType, name, "failure")
+		return err
+	}
+	MetricListUpdates.Inc(listType, name, "success")
+	MetricListLastSuccess.Set(float64(time.Now().Unix()), listType, name)
+	return nil
+}
+
+// deleteListMetrics 删除列表的所有指标 (列表从配置中删除时调用)
+func deleteListMetrics(listType, name string) {
+	for _, result := range []string{"success", "failure"} {
+		MetricListUpdates.DeleteLabelValues(listType, name, result)
+	}
+	MetricListUpdateDuration.DeleteLabelValues(listType, name)
+	MetricListLastSuccess.DeleteLabelValues(listType, name)
+	if listType == "risk_list" {
+		MetricRiskHits.DeletePartialMatch("list", name)
+	}
+}
+
+// collectGaugeMetrics 在抓取时重新计算当前状态类的 gauge
+func collectGaugeMetrics() {
+	MetricListEntries.Reset()
+	for _, group := range []struct {
+		typ  string
+		data *ListGroup
+	}{{"safe_list", SafeListData}, {"risk_list", RiskListData}} {
+		if group.data == nil {
+			continue
+		}
+		_, perList := group.data.Stats()
+		for name, count := range perList {
+			MetricListEntries.Set(float64(count), group.typ, name)
+		}
+	}
+
+	MetricListAge.Reset()
+	MetricListStale.Reset()
+	for _, s := range ListStatesSnapshot() {
+		MetricListAge.Set(float64(s.AgeSeconds), s.Type, s.Name)
+		MetricListStale.Set(float64(BoolToInt(s.Stale)), s.Type, s.Name)
+	}
+
+	PendingNotificationsMutex.Lock()
+	depth := len(PendingNotifications)
+	PendingNotificationsMutex.Unlock()
+	MetricQueueDepth.Set(float64(depth))
+
+	MetricActiveBans.Reset()
+	ActiveBansMutex.Lock()
+	for key := range ActiveBans {
+		MetricActiveBans.Inc(key.Action)
+	}
+	ActiveBansMutex.Unlock()
+
+	MetricBuildInfo.Reset()
+	MetricBuildInfo.Set(1, Version)
+}
+
+// handleMetrics 处理 /metrics 端点 (Prometheus 文本格式)
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	collectGaugeMetrics()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	var sb strings.Builder
+	for _, m := range allMetrics {
+		m.write(&sb)
+	}
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		logrus.Errorf("Failed to write metrics response: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// metricText 返回指标的 Prometheus 文本输出
+func metricText(m *metricVec) string {
+	var sb strings.Builder
+	m.write(&sb)
+	return sb.String()
+}
+
+func TestObserveListUpdateSkipsCancelled(t *testing.T) {
+	t.Cleanup(func() { deleteListMetrics("risk_list", "test") })
+
+	observeListUpdate("risk_list", "test", func() error { return errors.New("HTTP 500") })
+	observeListUpdate("risk_list", "test", func() error { return context.Canceled })
+	observeListUpdate("risk_list", "test", func() error { return fmt.Errorf("download: %w", context.Canceled) })
+	out := metricText(MetricListUpdates)
+	if !strings.Contains(out, `iplog_list_updates_total{type="risk_list",list="test",result="failure"} 1`) {
+		t.Fatalf("cancelled updates must not count as failures:\n%s", out)
+	}
+
+	observeListUpdate("risk_list", "test", func() error { return nil })
+	MetricRiskHits.Inc("test", "2")
+	deleteListMetrics("risk_list", "test")
+	for _, m := range []*metricVec{MetricListUpdates, MetricListUpdateDuration, MetricListLastSuccess, MetricRiskHits} {
+		if out := metricText(m); strings.Contains(out, `list="test"`) {
+			t.Fatalf("series of removed list still exported:\n%s", out)
+		}
+	}
+}
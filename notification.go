@@ -1,41 +1,60 @@
 package main
 
 import (
+	"net/netip"
 	"sync"
 	"time"
 )
 
 // NotificationItem 通知项结构体
 type NotificationItem struct {
-	IP             uint32
+	IP             netip.Addr
 	Count          int
-	SourceListInfo ListInfo // 来源列表信息
-	SourceLogInfo  ListInfo // 来源日志信息
-	Timestamp      int64    // 时间戳
+	SourceListInfo ListInfo   // 来源列表信息 (命中列表中等级最高的一个)
+	Matches        []ListInfo // 命中的所有风险列表 (按等级从高到低排序)
+	SourceLogInfo  ListInfo   // 来源日志信息
+	Field          string     // IP 在日志行中的来源字段
+	Fields         LogFields  // 结构化日志解析出的字段 (仅非 text 格式)
+	Timestamp      int64      // 时间戳
 }
 
 // NewNotificationItem 创建新的通知项
-// finfo: 日志文件信息 (SourceLogInfo), linfo: 风险列表信息 (SourceListInfo)
-func NewNotificationItem(ip uint32, count int, finfo ListInfo, linfo ListInfo) NotificationItem {
+// finfo: 日志文件信息 (SourceLogInfo), matches: 命中的风险列表 (按等级从高到低排序，第一个作为 SourceListInfo)
+// field: IP 在日志行中的来源字段, fields: 结构化日志解析出的字段
+func NewNotificationItem(ip netip.Addr, count int, finfo ListInfo, matches []ListInfo, field string, fields LogFields) NotificationItem {
+	var linfo ListInfo
+	if len(matches) > 0 {
+		linfo = matches[0]
+	}
 	return NotificationItem{
 		IP:             ip,
 		Count:          count,
 		SourceLogInfo:  finfo,
 		SourceListInfo: linfo,
+		Matches:        matches,
+		Field:          field,
+		Fields:         fields,
 		Timestamp:      time.Now().Unix(),
 	}
 }
 
 // TemplateData 用于通知模板的数据结构
 // 可用的模板变量：
-//   - {{.IP}}                      - 风险 IP 地址（字符串格式）
-//   - {{.Count}}                   - 该 IP 的命中次数
-//   - {{.SourceListInfo.Name}}     - 风险 IP 来源列表名称（如 "stamparm_ipsum_level8"）
+//   - {{.IP}}                      - 风险 IP 地址（字符串格式，IPv6 为压缩格式，如 2001:db8::1）
+//   - {{.Count}}                   - 该 IP 的命中次数（配置了 window 时为窗口内的命中次数）
+//   - {{.SourceListInfo.Name}}     - 风险 IP 来源列表名称（命中的最高等级列表，如 "stamparm_ipsum_level8"）
 //   - {{.SourceListInfo.Level}}    - 风险 IP 来源列表的风险等级（1-8，数值越大风险越高）
+//   - {{.Matches}}                 - 命中的所有风险列表（按等级从高到低排序），可用 {{range .Matches}}{{.Name}}({{.Level}}) {{end}} 遍历
 //   - {{.SourceLogInfo.Name}}      - 检测到该 IP 的日志文件名称
 //   - {{.SourceLogInfo.Level}}     - 检测到该 IP 的日志文件等级（数值越大越重要）
+//   - {{.Field}}                   - IP 在日志行中的来源字段（ip_regex 捕获组名 / ip_field 的 "$N" / 行内第 N 个地址 "#N"）
+//   - {{.Fields}}                  - 结构化日志解析出的字段（最近一次命中），如 {{.Fields.path}}、{{.Fields.status}}、
+//     {{.Fields.http_user_agent}}、{{.Fields.time_local}}；字段名含 "-" 或 "." 时使用 {{index .Fields "request.path"}}
 //   - {{.Timestamp}}               - Unix 时间戳（秒）
 //   - {{.Time}}                    - 格式化的时间字符串 (2006-01-02 15:04:05)
+//   - {{.IsFollowUp}}              - 是否为冷却 (cooldown) 结束时的汇总消息
+//   - {{.Suppressed}}              - 冷却期内被抑制的告警次数（仅汇总消息）
+//   - {{.SuppressedHits}}          - 冷却期内被抑制告警的命中次数之和（仅汇总消息）
 //
 // 示例：
 //
@@ -44,18 +63,27 @@ type TemplateData struct {
 	IP             string
 	Count          int
 	SourceListInfo ListInfo
+	Matches        []ListInfo
 	SourceLogInfo  ListInfo
+	Field          string
+	Fields         LogFields
 	Timestamp      int64
 	Time           string
+	IsFollowUp     bool // 是否为冷却结束时的汇总消息
+	Suppressed     int  // 冷却期内被抑制的告警次数 (仅汇总消息)
+	SuppressedHits int  // 冷却期内被抑制告警的命中次数之和 (仅汇总消息)
 }
 
 // NewTemplateData 创建新的模板数据
-func NewTemplateData(ip string, count int, finfo ListInfo, linfo ListInfo, timestamp int64, timeStr string) TemplateData {
+func NewTemplateData(ip string, count int, finfo ListInfo, linfo ListInfo, matches []ListInfo, field string, fields LogFields, timestamp int64, timeStr string) TemplateData {
 	return TemplateData{
 		IP:             ip,
 		Count:          count,
 		SourceListInfo: finfo,
+		Matches:        matches,
 		SourceLogInfo:  linfo,
+		Field:          field,
+		Fields:         fields,
 		Timestamp:      timestamp,
 		Time:           timeStr,
 	}
@@ -63,32 +91,130 @@ func NewTemplateData(ip string, count int, finfo ListInfo, linfo ListInfo, times
 
 // PendingNotification 待发送的通知
 type PendingNotification struct {
-	Notif      Notification // 通知配置
-	Message    string       // 通知消息
-	Title      string       // 通知标题
-	Data       TemplateData // 模板数据
-	RetryCount int          // 已重试次数
+	ID          string       // 队列中的唯一 ID (用于持久化)
+	Notif       Notification // 通知配置
+	Message     string       // 通知消息
+	Title       string       // 通知标题
+	HTML        string       // HTML 正文 (仅 email 服务, 配置了 html_template 时)
+	Data        TemplateData // 模板数据
+	RetryCount  int          // 已重试次数
+	CreatedAt   time.Time    // 入队时间 (用于 retry.max_age)
+	NextAttempt time.Time    // 下次发送时间 (零值表示立即发送)
+	LastError   string       // 最近一次发送失败的错误信息
+	Held        string       // 暂存的缓冲区 (digest / ratelimit), 为空表示在待发送队列中
+	Group       string       // 告警分组 (同一 IP 在一次检查中触发的各通知项), delivery: any 按分组判断是否已送达
+
+	TokenReserved bool // 已占用速率限制令牌 (速率汇总消息生成时占用, 首次发送不再取令牌)
+}
+
+// HitBucket 按秒聚合的命中次数
+type HitBucket struct {
+	Unix  int64 // 秒级时间戳
+	Count int   // 该秒内的命中次数
+}
+
+// IPHitRecord 单个 IP 的命中统计
+// 命中按秒分桶，只保留最大时间窗口内的桶，内存占用不会随命中次数无限增长
+type IPHitRecord struct {
+	Latest  NotificationItem // 最近一次命中的信息 (用于模板数据)
+	Total   int              // 累计命中次数 (不限时间窗口，用于未配置 window 的通知)
+	PerLog  map[string]int   // 各目标日志的累计命中次数 (用于 /check 接口)
+	Buckets []HitBucket      // 命中次数桶 (按时间升序)
+}
+
+// Add 记录一次命中, retention 为桶的保留时长 (<= 0 表示不需要分桶)
+func (r *IPHitRecord) Add(item NotificationItem, retention time.Duration) {
+	r.Total++
+	item.Count = r.Total
+	r.Latest = item
+	if r.PerLog == nil {
+		r.PerLog = make(map[string]int)
+	}
+	r.PerLog[item.SourceLogInfo.Name]++
+	if retention <= 0 {
+		r.Buckets = nil
+		return
+	}
+	if n := len(r.Buckets); n > 0 && r.Buckets[n-1].Unix == item.Timestamp {
+		r.Buckets[n-1].Count++
+	} else {
+		r.Buckets = append(r.Buckets, HitBucket{Unix: item.Timestamp, Count: 1})
+	}
+	r.Evict(item.Timestamp - int64(retention/time.Second))
 }
 
-// 通知映射：IP -> 通知项列表
-var NotificationMap = make(map[uint32][]NotificationItem)
+// Evict 删除时间戳 <= before 的桶
+func (r *IPHitRecord) Evict(before int64) {
+	i := 0
+	for i < len(r.Buckets) && r.Buckets[i].Unix <= before {
+		i++
+	}
+	if i > 0 {
+		r.Buckets = append(r.Buckets[:0], r.Buckets[i:]...)
+	}
+}
+
+// CountIn 返回最近 window 时间内的命中次数，window <= 0 时返回累计命中次数
+func (r *IPHitRecord) CountIn(window time.Duration, now int64) int {
+	if window <= 0 {
+		return r.Total
+	}
+	since := now - int64(window/time.Second)
+	count := 0
+	for i := len(r.Buckets) - 1; i >= 0 && r.Buckets[i].Unix > since; i-- {
+		count += r.Buckets[i].Count
+	}
+	return count
+}
+
+// cooldownKey 告警冷却的键：IP + 通知项
+type cooldownKey struct {
+	IP      netip.Addr
+	NotifID string
+}
+
+// CooldownState 单个 IP 在某个通知项上的冷却状态
+type CooldownState struct {
+	Until          time.Time    // 冷却结束时间
+	Suppressed     int          // 冷却期内被抑制的告警次数
+	SuppressedHits int          // 冷却期内被抑制告警的命中次数之和
+	LastData       TemplateData // 最近一次被抑制告警的模板数据 (用于汇总消息)
+}
+
+// 告警冷却状态
+var AlertCooldowns = make(map[cooldownKey]*CooldownState)
+var AlertCooldownsMutex sync.Mutex
+
+// 通知映射：IP -> 命中统计
+var NotificationMap = make(map[netip.Addr]*IPHitRecord)
 var NotificationMapMutex sync.Mutex
 
 // 待发送通知队列
 var PendingNotifications []PendingNotification
 var PendingNotificationsMutex sync.Mutex
 
-// TakeAllPendingNotifications 取出所有待发送通知 (线程安全)
-// 取出后队列将被清空
-func TakeAllPendingNotifications() []PendingNotification {
+// TakeDuePendingNotifications 取出所有已到发送时间 (NextAttempt <= now) 的待发送通知 (线程安全)
+// 未到发送时间的通知保留在队列中，顺序不变
+func TakeDuePendingNotifications(now time.Time) []PendingNotification {
 	PendingNotificationsMutex.Lock()
 	defer PendingNotificationsMutex.Unlock()
 	if len(PendingNotifications) == 0 {
 		return nil
 	}
-	taken := make([]PendingNotification, len(PendingNotifications))
-	copy(taken, PendingNotifications)
-	PendingNotifications = PendingNotifications[:0]
+	var taken []PendingNotification
+	remaining := PendingNotifications[:0]
+	for _, pn := range PendingNotifications {
+		if pn.NextAttempt.After(now) {
+			remaining = append(remaining, pn)
+		} else {
+			taken = append(taken, pn)
+		}
+	}
+	// 清理被移出的尾部元素，避免持有旧数据
+	for i := len(remaining); i < len(PendingNotifications); i++ {
+		PendingNotifications[i] = PendingNotification{}
+	}
+	PendingNotifications = remaining
 	return taken
 }
 
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultDigestTemplate 未配置 digest_template 时使用的摘要模板
+const defaultDigestTemplate = `Risk IP digest {{.Start}} - {{.End}}: {{.TotalIPs}} IPs, {{.TotalHits}} hits
+{{range .Items}}- {{.IP}} ({{.Count}} hits) list: {{.SourceListInfo.Name}} (level {{.SourceListInfo.Level}}), log: {{.SourceLogInfo.Name}}
+{{end}}`
+
+// DigestData 摘要通知的模板数据
+// 可用的模板变量：
+//   - {{.Items}}          - 本周期内的告警列表 ([]TemplateData)，按命中次数、风险等级降序排列，同一 IP 合并为一项
+//   - {{.TotalIPs}}       - IP 总数
+//   - {{.TotalHits}}      - 命中次数总和
+//   - {{.MaxLevel}}       - 最高风险等级
+//   - {{.Start}}          - 周期开始时间 (2006-01-02 15:04:05)
+//   - {{.End}}            - 周期结束时间 (2006-01-02 15:04:05)
+//   - {{.StartTimestamp}} - 周期开始 Unix 时间戳（秒）
+//   - {{.EndTimestamp}}   - 周期结束 Unix 时间戳（秒）
+//
+// 示例：
+//
+//	{{.TotalIPs}} risk IPs: {{range .Items}}{{.IP}}({{.Count}}) {{end}}
+type DigestData struct {
+	Items          []TemplateData
+	TotalIPs       int
+	TotalHits      int
+	MaxLevel       int
+	Start          string
+	End            string
+	StartTimestamp int64
+	EndTimestamp   int64
+}
+
+// DigestBuffer 单个通知项在当前周期内收集的告警
+type DigestBuffer struct {
+	Start time.Time               // 周期开始时间
+	Items map[string]TemplateData // IP -> 合并后的模板数据
+	Held  []PendingNotification   // 合并到缓冲区的原通知 (保留在持久化队列中, 汇总消息入队后移出)
+}
+
+// 摘要缓冲区：通知项 ID -> 当前周期收集的告警
+var DigestBuffers = make(map[string]*DigestBuffer)
+var DigestBuffersMutex sync.Mutex
+
+// QueueNotification 将通知加入发送流程 (线程安全)，group 为告警分组 (为空表示不与其他通知项关联)
+// 配置了 digest 的通知项先进入摘要缓冲区，周期结束后合并为一条消息；否则直接加入待发送队列
+// 进入摘要缓冲区的告警同样写入持久化队列，进程退出或崩溃后重放到缓冲区
+func QueueNotification(notif Notification, message, title string, data TemplateData, group string) {
+	if notif.DigestParsed <= 0 {
+		enqueuePendingNotification(PendingNotification{
+			Notif:   notif,
+			Message: message,
+			Title:   title,
+			HTML:    renderHTMLBody(notif, data),
+			Data:    data,
+			Group:   group,
+		})
+		return
+	}
+	pn := PendingNotification{
+		Notif:     notif,
+		Message:   message,
+		Title:     title,
+		Data:      data,
+		CreatedAt: time.Now(),
+		Held:      heldDigest,
+	}
+	NotificationQueueStore.Add(&pn)
+	AddDigestItem(pn)
+}
+
+// AddDigestItem 将告警加入通知项的摘要缓冲区 (线程安全)
+// 同一 IP 在一个周期内的多次告警合并为一项：命中次数累加，风险等级取最高
+func AddDigestItem(pn PendingNotification) {
+	DigestBuffersMutex.Lock()
+	defer DigestBuffersMutex.Unlock()
+
+	buf := DigestBuffers[pn.Notif.ID]
+	if buf == nil {
+		buf = NewDigestBuffer(pn.CreatedAt)
+		DigestBuffers[pn.Notif.ID] = buf
+	}
+	buf.Add(pn.Data)
+	buf.Held = append(buf.Held, pn)
+	logrus.Debugf("Added IP %s to digest [%s], %d IPs collected", pn.Data.IP, pn.Notif.ID, len(buf.Items))
+}
+
+// NewDigestBuffer 创建从 start 开始收集的摘要缓冲区
+func NewDigestBuffer(start time.Time) *DigestBuffer {
+	return &DigestBuffer{Start: start, Items: make(map[string]TemplateData)}
+}
+
+// Add 将告警合并到缓冲区：同一 IP 的命中次数累加，风险等级取最高
+func (buf *DigestBuffer) Add(data TemplateData) {
+	if prev, ok := buf.Items[data.IP]; ok {
+		data.Count += prev.Count
+		if prev.SourceListInfo.Level > data.SourceListInfo.Level {
+			data.SourceListInfo = prev.SourceListInfo
+			data.Matches = prev.Matches
+		}
+	}
+	buf.Items[data.IP] = data
+}
+
+// release 将合并到缓冲区的原通知移出持久化队列 (汇总消息入队或缓冲区被丢弃后调用)
+func (buf *DigestBuffer) release() {
+	for _, pn := range buf.Held {
+		NotificationQueueStore.Done(pn)
+	}
+	buf.Held = nil
+}
+
+// FlushDigests 将已到周期的摘要缓冲区渲染为一条通知并加入待发送队列 (线程安全)
+func FlushDigests() {
+	configMutex.RLock()
+	notifByID := make(map[string]Notification, len(config.Notifications.Services))
+	for _, notif := range config.Notifications.Services {
+		notifByID[notif.ID] = notif
+	}
+	configMutex.RUnlock()
+
+	DigestBuffersMutex.Lock()
+	defer DigestBuffersMutex.Unlock()
+
+	now := time.Now()
+	for id, buf := range DigestBuffers {
+		notif, ok := notifByID[id]
+		if !ok || notif.DigestParsed <= 0 {
+			// 通知项已被删除或关闭了摘要：剩余告警直接发送
+			if ok {
+				flushDigestAsSingle(notif, buf)
+			} else {
+				logrus.Warnf("Dropping digest [%s] with %d IPs: notification no longer configured", id, len(buf.Items))
+			}
+			buf.release()
+			delete(DigestBuffers, id)
+			continue
+		}
+		if now.Sub(buf.Start) < notif.DigestParsed {
+			continue
+		}
+		delete(DigestBuffers, id)
+		if len(buf.Items) == 0 {
+			buf.release()
+			continue
+		}
+
+		pn, err := newDigestNotification(notif, buf, now, digestGroupKey(id))
+		if err != nil {
+			logrus.Errorf("Failed to render digest notification [%s], sending %d alerts individually: %v", id, len(buf.Items), err)
+			flushDigestAsSingle(notif, buf)
+			buf.release()
+			continue
+		}
+		// 摘要消息写入持久化队列后才移出合并进来的原通知
+		enqueuePendingNotification(pn)
+		buf.release()
+		logrus.Infof("Queued digest notification [%s] with %d IPs, %d hits", id, len(buf.Items), pn.Data.Count)
+	}
+}
+
+// newDigestNotification 将摘要缓冲区渲染为一条待发送通知
+// groupKey 代替 IP 作为发送队列中的分组键
+func newDigestNotification(notif Notification, buf *DigestBuffer, now time.Time, groupKey string) (PendingNotification, error) {
+	data := NewDigestData(buf, now)
+	message, err := renderDigest(notif, data)
+	if err != nil {
+		return PendingNotification{}, err
+	}
+	title := notif.PayloadTitle
+	if title == "" {
+		title = "Risk IP Digest"
+	}
+	return PendingNotification{
+		Notif:   notif,
+		Message: message,
+		Title:   title,
+		HTML:    renderHTMLBody(notif, data),
+		Data: TemplateData{
+			IP:        groupKey,
+			Count:     data.TotalHits,
+			Timestamp: now.Unix(),
+			Time:      now.Format("2006-01-02 15:04:05"),
+		},
+	}, nil
+}
+
+// flushDigestAsSingle 将摘要缓冲区中的告警逐条加入待发送队列
+func flushDigestAsSingle(notif Notification, buf *DigestBuffer) {
+	for _, data := range buf.Items {
+		message, title, err := renderNotification(notif, notif.PayloadTemplate, data)
+		if err != nil {
+			logrus.Errorf("Failed to render notification [%s]: %v", notif.ID, err)
+			continue
+		}
+		AddPendingNotification(notif, message, title, renderHTMLBody(notif, data), data)
+	}
+}
+
+// digestGroupKey 摘要通知在发送队列中的分组键 (代替 IP)
+func digestGroupKey(notifID string) string {
+	return "digest:" + notifID
+}
+
+// NewDigestData 根据摘要缓冲区生成模板数据
+func NewDigestData(buf *DigestBuffer, end time.Time) DigestData {
+	data := DigestData{
+		Items:          make([]TemplateData, 0, len(buf.Items)),
+		Start:          buf.Start.Format("2006-01-02 15:04:05"),
+		End:            end.Format("2006-01-02 15:04:05"),
+		StartTimestamp: buf.Start.Unix(),
+		EndTimestamp:   end.Unix(),
+	}
+	for _, item := range buf.Items {
+		data.Items = append(data.Items, item)
+		data.TotalHits += item.Count
+		if item.SourceListInfo.Level > data.MaxLevel {
+			data.MaxLevel = item.SourceListInfo.Level
+		}
+	}
+	data.TotalIPs = len(data.Items)
+	sort.Slice(data.Items, func(i, j int) bool {
+		a, b := data.Items[i], data.Items[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.SourceListInfo.Level != b.SourceListInfo.Level {
+			return a.SourceListInfo.Level > b.SourceListInfo.Level
+		}
+		return a.IP < b.IP
+	})
+	return data
+}
+
+// renderDigest 使用 digest_template 渲染摘要消息
+func renderDigest(notif Notification, data DigestData) (string, error) {
+	tmplStr := notif.DigestTemplate
+	if tmplStr == "" {
+		tmplStr = defaultDigestTemplate
+	}
+	tmpl, err := template.New("digest").Parse(tmplStr)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to execute template: %v", err)
+	}
+	return buf.String(), nil
+}
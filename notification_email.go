@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SMTP 连接加密方式
+const (
+	EmailSecurityStartTLS = "starttls" // 明文连接后通过 STARTTLS 升级 (默认, 端口 587)
+	EmailSecurityTLS      = "tls"      // 直接建立 TLS 连接 (SMTPS, 端口 465)
+	EmailSecurityNone     = "none"     // 不加密 (仅建议用于本机或内网中继)
+)
+
+// emailConfig email 服务配置 (来自 Notification.Config)
+type emailConfig struct {
+	Host               string   // SMTP 服务器地址
+	Port               int      // SMTP 端口
+	Username           string   // 登录用户名 (为空时不认证)
+	Password           string   // 登录密码
+	Auth               string   // 认证方式: plain / login
+	Security           string   // 加密方式: starttls / tls / none
+	InsecureSkipVerify bool     // 跳过证书校验
+	From               string   // 发件人 (可带显示名, 如 "IPLog <alert@example.com>")
+	To                 []string // 收件人列表
+}
+
+// parseEmailConfig 解析 email 服务配置
+func parseEmailConfig(cfg map[string]any) (*emailConfig, error) {
+	ec := &emailConfig{}
+	host, ok := cfg["host"].(string)
+	if !ok || host == "" {
+		return nil, fmt.Errorf("Email host not configured or not string")
+	}
+	ec.Host = host
+
+	if s, ok := cfg["security"].(string); ok && s != "" {
+		ec.Security = strings.ToLower(s)
+	}
+	switch ec.Security {
+	case "", EmailSecurityStartTLS, EmailSecurityTLS, EmailSecurityNone:
+	default:
+		return nil, fmt.Errorf("unsupported email security: %s (starttls, tls, none)", ec.Security)
+	}
+
+	switch p := cfg["port"].(type) {
+	case nil:
+	case int:
+		ec.Port = p
+	case float64:
+		ec.Port = int(p)
+	case string:
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid email port: %s", p)
+		}
+		ec.Port = port
+	default:
+		return nil, fmt.Errorf("invalid email port: %v", p)
+	}
+	// 未指定时根据端口与加密方式互相推断
+	if ec.Security == "" {
+		ec.Security = EmailSecurityStartTLS
+		if ec.Port == 465 {
+			ec.Security = EmailSecurityTLS
+		}
+	}
+	if ec.Port == 0 {
+		ec.Port = 587
+		switch ec.Security {
+		case EmailSecurityTLS:
+			ec.Port = 465
+		case EmailSecurityNone:
+			ec.Port = 25
+		}
+	}
+
+	ec.Username, _ = cfg["username"].(string)
+	ec.Password, _ = cfg["password"].(string)
+	ec.Auth = "plain"
+	if a, ok := cfg["auth"].(string); ok && a != "" {
+		ec.Auth = strings.ToLower(a)
+	}
+	if ec.Auth != "plain" && ec.Auth != "login" {
+		return nil, fmt.Errorf("unsupported email auth: %s (plain, login)", ec.Auth)
+	}
+	ec.InsecureSkipVerify, _ = cfg["insecure_skip_verify"].(bool)
+
+	from, ok := cfg["from"].(string)
+	if !ok || from == "" {
+		from = ec.Username
+	}
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid email from address %q: %v", from, err)
+	}
+	ec.From = from
+
+	// 收件人支持列表或逗号分隔的字符串
+	switch to := cfg["to"].(type) {
+	case string:
+		for _, addr := range strings.Split(to, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				ec.To = append(ec.To, addr)
+			}
+		}
+	case []any:
+		for _, v := range to {
+			if addr, ok := v.(string); ok && strings.TrimSpace(addr) != "" {
+				ec.To = append(ec.To, strings.TrimSpace(addr))
+			}
+		}
+	}
+	if len(ec.To) == 0 {
+		return nil, fmt.Errorf("Email to not configured")
+	}
+	for _, addr := range ec.To {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return nil, fmt.Errorf("invalid email to address %q: %v", addr, err)
+		}
+	}
+	return ec, nil
+}
+
+// sendEmailNotification 通过 SMTP 发送 email 通知 (特殊处理，不使用 notify 库，单次尝试)
+// html 非空时发送 multipart/alternative 邮件 (纯文本 + HTML)
+func sendEmailNotification(notif Notification, message, title, html string, timeout time.Duration) error {
+	ec, err := parseEmailConfig(notif.Config)
+	if err != nil {
+		return err
+	}
+	if title == "" {
+		title = "Risk IP Alert"
+	}
+
+	from, _ := mail.ParseAddress(ec.From)
+	rcpts := make([]string, 0, len(ec.To))
+	for _, addr := range ec.To {
+		a, _ := mail.ParseAddress(addr)
+		rcpts = append(rcpts, a.Address)
+	}
+	msg, err := buildEmailMessage(ec.From, ec.To, title, message, html)
+	if err != nil {
+		return fmt.Errorf("failed to build email: %v", err)
+	}
+
+	addr := net.JoinHostPort(ec.Host, strconv.Itoa(ec.Port))
+	tlsConfig := &tls.Config{ServerName: ec.Host, InsecureSkipVerify: ec.InsecureSkipVerify}
+	dialer := &net.Dialer{Timeout: timeout}
+	logrus.Debugf("Email Request (summary): addr=%s security=%s auth=%s from=%s to=%v subject=%s", addr, ec.Security, ec.Auth, from.Address, rcpts, title)
+
+	var conn net.Conn
+	if ec.Security == EmailSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server %s: %v", addr, err)
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	c, err := smtp.NewClient(conn, ec.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to create SMTP client: %v", err)
+	}
+	defer c.Close()
+
+	if ec.Security == EmailSecurityStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("SMTP server %s does not support STARTTLS", addr)
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %v", err)
+		}
+	}
+
+	if ec.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("SMTP server %s does not support AUTH", addr)
+		}
+		var auth smtp.Auth
+		if ec.Auth == "login" {
+			auth = &loginAuth{username: ec.Username, password: ec.Password, host: ec.Host}
+		} else {
+			auth = smtp.PlainAuth("", ec.Username, ec.Password, ec.Host)
+		}
+		if err := c.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP auth failed: %v", err)
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM failed: %v", err)
+	}
+	for _, rcpt := range rcpts {
+		if err := c.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("SMTP RCPT TO %s failed: %v", rcpt, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA failed: %v", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write email body: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP DATA failed: %v", err)
+	}
+	return c.Quit()
+}
+
+// buildEmailMessage 生成邮件内容 (含邮件头)，正文使用 quoted-printable 编码
+func buildEmailMessage(from string, to []string, subject, text, html string) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+	header("From", from)
+	header("To", strings.Join(to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", newMessageID(from))
+	header("MIME-Version", "1.0")
+
+	if html == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(pw, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeQuotedPrintable 以 quoted-printable 编码写入正文
+func writeQuotedPrintable(w interface{ Write([]byte) (int, error) }, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// newMessageID 生成 Message-ID 邮件头，域名取自发件人地址
+func newMessageID(from string) string {
+	domain := "localhost"
+	if a, err := mail.ParseAddress(from); err == nil {
+		if i := strings.LastIndex(a.Address, "@"); i >= 0 {
+			domain = a.Address[i+1:]
+		}
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(b), domain)
+}
+
+// loginAuth 实现 SMTP AUTH LOGIN 认证 (net/smtp 仅内置 PLAIN 与 CRAM-MD5)
+// 与 smtp.PlainAuth 相同，仅允许在 TLS 连接或本机地址上发送密码
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	prompt := strings.ToLower(strings.TrimSpace(string(fromServer)))
+	switch {
+	case strings.HasPrefix(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "password"):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+	}
+}
+
+// isLocalhost 判断是否为本机地址
+func isLocalhost(name string) bool {
+	if name == "localhost" {
+		return true
+	}
+	ip, err := ParseIP(name)
+	return err == nil && ip.IsLoopback()
+}
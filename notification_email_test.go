@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpStub 最小的 SMTP 服务器，记录收到的命令、认证信息与邮件内容
+type smtpStub struct {
+	ln       net.Listener
+	startTLS string // "": 不支持 STARTTLS, "refuse": 声明支持但拒绝升级
+	auth     string // EHLO 中声明的认证方式, 如 "PLAIN LOGIN"
+
+	mu       sync.Mutex
+	cmds     []string
+	authUser string
+	authPass string
+	data     string
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &smtpStub{ln: ln, auth: "PLAIN LOGIN"}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// port 返回监听端口
+func (s *smtpStub) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	tc := textproto.NewConn(conn)
+	tc.PrintfLine("220 stub ESMTP")
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.cmds = append(s.cmds, line)
+		s.mu.Unlock()
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			tc.PrintfLine("250-stub")
+			if s.startTLS != "" {
+				tc.PrintfLine("250-STARTTLS")
+			}
+			tc.PrintfLine("250 AUTH %s", s.auth)
+		case "STARTTLS":
+			tc.PrintfLine("454 TLS not available")
+		case "AUTH":
+			mech, initial, _ := strings.Cut(arg, " ")
+			var user, pass string
+			switch strings.ToUpper(mech) {
+			case "PLAIN":
+				b, _ := base64.StdEncoding.DecodeString(initial)
+				parts := strings.Split(string(b), "\x00")
+				if len(parts) == 3 {
+					user, pass = parts[1], parts[2]
+				}
+			case "LOGIN":
+				user = s.challenge(tc, "Username:")
+				pass = s.challenge(tc, "Password:")
+			}
+			s.mu.Lock()
+			s.authUser, s.authPass = user, pass
+			s.mu.Unlock()
+			tc.PrintfLine("235 ok")
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			tc.PrintfLine("250 ok")
+		case "DATA":
+			tc.PrintfLine("354 go ahead")
+			data, err := io.ReadAll(tc.DotReader())
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.data = string(data)
+			s.mu.Unlock()
+			tc.PrintfLine("250 queued")
+		case "QUIT":
+			tc.PrintfLine("221 bye")
+			return
+		default:
+			tc.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// challenge 发送 AUTH LOGIN 的 334 质询并返回客户端的应答
+func (s *smtpStub) challenge(tc *textproto.Conn, prompt string) string {
+	tc.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+	line, err := tc.ReadLine()
+	if err != nil {
+		return ""
+	}
+	b, _ := base64.StdEncoding.DecodeString(line)
+	return string(b)
+}
+
+func (s *smtpStub) commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.cmds...)
+}
+
+func emailTestNotification(s *smtpStub, extra map[string]any) Notification {
+	cfg := map[string]any{
+		"host":     "127.0.0.1",
+		"port":     s.port(),
+		"security": "none",
+		"from":     "IPLog <alert@example.com>",
+		"to":       []any{"ops@example.com", "sec@example.com"},
+	}
+	for k, v := range extra {
+		cfg[k] = v
+	}
+	return Notification{Service: "email", ID: "email-test", Config: cfg}
+}
+
+func TestEmailStartTLSRefused(t *testing.T) {
+	for _, mode := range []string{"", "refuse"} {
+		s := newSMTPStub(t)
+		s.startTLS = mode
+		notif := emailTestNotification(s, map[string]any{"security": "starttls"})
+		err := sendEmailNotification(notif, "body", "subject", "", 5*time.Second)
+		if err == nil {
+			t.Fatalf("startTLS=%q: expected error when STARTTLS is unavailable", mode)
+		}
+		for _, cmd := range s.commands() {
+			if strings.HasPrefix(cmd, "AUTH") || strings.HasPrefix(cmd, "MAIL") {
+				t.Fatalf("startTLS=%q: %q sent over unencrypted connection", mode, cmd)
+			}
+		}
+	}
+}
+
+func TestEmailAuth(t *testing.T) {
+	for _, mech := range []string{"plain", "login"} {
+		s := newSMTPStub(t)
+		notif := emailTestNotification(s, map[string]any{"username": "alice", "password": "s3cret", "auth": mech})
+		if err := sendEmailNotification(notif, "body", "subject", "", 5*time.Second); err != nil {
+			t.Fatalf("auth %s: %v", mech, err)
+		}
+		s.mu.Lock()
+		user, pass := s.authUser, s.authPass
+		s.mu.Unlock()
+		if user != "alice" || pass != "s3cret" {
+			t.Fatalf("auth %s: got user %q pass %q", mech, user, pass)
+		}
+		var authCmd string
+		for _, cmd := range s.commands() {
+			if strings.HasPrefix(cmd, "AUTH ") {
+				authCmd = cmd
+			}
+		}
+		if !strings.HasPrefix(authCmd, "AUTH "+strings.ToUpper(mech)) {
+			t.Fatalf("auth %s: unexpected command %q", mech, authCmd)
+		}
+	}
+}
+
+func TestEmailMultipartBody(t *testing.T) {
+	s := newSMTPStub(t)
+	notif := emailTestNotification(s, nil)
+	text := "Risk IP 1.2.3.4 hit 5 times"
+	html := "<p>Risk IP <b>1.2.3.4</b> hit 5 times</p>"
+	if err := sendEmailNotification(notif, text, "风险 IP 告警", html, 5*time.Second); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	var rcpts []string
+	for _, cmd := range s.commands() {
+		if strings.HasPrefix(cmd, "RCPT TO:") {
+			rcpts = append(rcpts, cmd)
+		}
+	}
+	if len(rcpts) != 2 {
+		t.Fatalf("expected 2 recipients, got %v", rcpts)
+	}
+
+	s.mu.Lock()
+	data := s.data
+	s.mu.Unlock()
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "风险 IP 告警" {
+		t.Fatalf("unexpected subject %q: %v", subject, err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("unexpected content type %q: %v", msg.Header.Get("Content-Type"), err)
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	want := []struct{ contentType, body string }{
+		{"text/plain", text},
+		{"text/html", html},
+	}
+	for i, w := range want {
+		// NextRawPart 不自动解码 quoted-printable，可以检查 Content-Transfer-Encoding
+		part, err := mr.NextRawPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		if ct, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); ct != w.contentType {
+			t.Fatalf("part %d: content type %q, want %q", i, ct, w.contentType)
+		}
+		if enc := part.Header.Get("Content-Transfer-Encoding"); enc != "quoted-printable" {
+			t.Fatalf("part %d: transfer encoding %q", i, enc)
+		}
+		body, err := io.ReadAll(quotedprintable.NewReader(part))
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		if string(body) != w.body {
+			t.Fatalf("part %d: body %q, want %q", i, body, w.body)
+		}
+	}
+	if _, err := mr.NextRawPart(); err != io.EOF {
+		t.Fatalf("expected 2 parts, got more: %v", err)
+	}
+}
+
+func TestEmailConfigValidatedOnLoad(t *testing.T) {
+	cases := map[string]map[string]any{
+		"missing host": {"to": "ops@example.com", "from": "alert@example.com"},
+		"bad port":     {"host": "smtp.example.com", "port": "smtp", "to": "ops@example.com", "from": "alert@example.com"},
+		"bad from":     {"host": "smtp.example.com", "to": "ops@example.com", "from": "not an address"},
+		"missing to":   {"host": "smtp.example.com", "from": "alert@example.com"},
+		"bad auth":     {"host": "smtp.example.com", "to": "ops@example.com", "from": "alert@example.com", "auth": "cram-md5"},
+	}
+	for name, cfg := range cases {
+		notif := Notification{Service: "email", Config: cfg}
+		if err := initNotificationConfig(&notif, &Notifications{}); err == nil {
+			t.Errorf("%s: expected config error", name)
+		}
+	}
+
+	notif := Notification{Service: "email", Config: map[string]any{"host": "smtp.example.com", "to": "ops@example.com", "from": "alert@example.com"}}
+	if err := initNotificationConfig(&notif, &Notifications{}); err != nil {
+		t.Fatalf("valid config rejected: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// recentNotificationsLimit 保留的最近通知记录数 (超出后覆盖最旧的记录)
+const recentNotificationsLimit = 1000
+
+// RecentNotification 最近一次触发的通知 (用于 /check 接口)
+type RecentNotification struct {
+	ID      string    `json:"id"`               // 通知项标识
+	Service string    `json:"service"`          // 通知服务类型
+	Time    time.Time `json:"time"`             // 触发时间
+	Count   int       `json:"count"`            // 触发时的命中次数
+	List    string    `json:"list"`             // 命中的最高等级风险列表
+	Level   int       `json:"level"`            // 风险等级
+	Log     string    `json:"log"`              // 来源日志
+	Digest  bool      `json:"digest,omitempty"` // 是否加入摘要 (由摘要统一发送)
+	ip      string
+}
+
+// 最近触发的通知 (环形缓冲区)
+var recentNotifications = make([]RecentNotification, 0, recentNotificationsLimit)
+var recentNotificationsNext int
+var recentNotificationsMutex sync.Mutex
+
+// RecordRecentNotification 记录一次触发的通知 (线程安全)
+func RecordRecentNotification(notif Notification, data TemplateData) {
+	rn := RecentNotification{
+		ID:      notif.ID,
+		Service: notif.Service,
+		Time:    time.Now(),
+		Count:   data.Count,
+		List:    data.SourceListInfo.Name,
+		Level:   data.SourceListInfo.Level,
+		Log:     data.SourceLogInfo.Name,
+		Digest:  notif.DigestParsed > 0,
+		ip:      data.IP,
+	}
+
+	recentNotificationsMutex.Lock()
+	defer recentNotificationsMutex.Unlock()
+	if len(recentNotifications) < recentNotificationsLimit {
+		recentNotifications = append(recentNotifications, rn)
+		return
+	}
+	recentNotifications[recentNotificationsNext] = rn
+	recentNotificationsNext = (recentNotificationsNext + 1) % recentNotificationsLimit
+}
+
+// RecentNotificationsFor 返回该 IP 最近触发的通知 (线程安全, 按时间从新到旧)
+func RecentNotificationsFor(ip string) []RecentNotification {
+	recentNotificationsMutex.Lock()
+	defer recentNotificationsMutex.Unlock()
+	result := []RecentNotification{}
+	n := len(recentNotifications)
+	for i := 0; i < n; i++ {
+		// 从最新的记录开始向前遍历
+		rn := recentNotifications[(recentNotificationsNext-1-i+2*n)%n]
+		if rn.ip == ip {
+			result = append(result, rn)
+		}
+	}
+	return result
+}
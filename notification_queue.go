@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 通知队列日志的记录类型
+const (
+	queueOpAdd   = "add"   // 通知入队
+	queueOpRetry = "retry" // 发送失败, 更新重试次数
+	queueOpDone  = "done"  // 发送成功或重试耗尽, 移出队列
+	queueOpHold  = "hold"  // 合并到缓冲区, 等待汇总消息
+
+	queueOpDelivered = "delivered" // 分组中已有通知发送成功 (ID 为分组), delivery: any 的通知不再发送
+)
+
+// 暂存在缓冲区中、尚未进入待发送队列的通知 (PendingNotification.Held)
+// 重放时放回对应的缓冲区，缓冲区生成的汇总消息入队后才移出持久化队列
+const (
+	heldDigest    = "digest"    // 摘要缓冲区 (digest)
+	heldRateLimit = "ratelimit" // 速率汇总缓冲区 (rate_limit_overflow: summary)
+)
+
+// queueCompactThreshold 日志记录数超过该值且过半已失效时压缩日志
+const queueCompactThreshold = 1024
+
+// queuedNotification 持久化的待发送通知
+// 不保存通知配置 (可能包含 token/密码), 重放时按 NotifID 从当前配置中查找
+type queuedNotification struct {
+	ID            string       `json:"-"`
+	NotifID       string       `json:"notif_id"`
+	Message       string       `json:"message"`
+	Title         string       `json:"title"`
+	HTML          string       `json:"html,omitempty"`
+	Data          TemplateData `json:"data"`
+	RetryCount    int          `json:"retry_count"`
+	CreatedAt     time.Time    `json:"created_at"`
+	NextAttempt   time.Time    `json:"next_attempt"`
+	LastError     string       `json:"last_error,omitempty"`
+	Held          string       `json:"held,omitempty"`
+	Group         string       `json:"group,omitempty"`
+	TokenReserved bool         `json:"token_reserved,omitempty"`
+}
+
+// queueRecord 通知队列日志中的一条记录 (一行 JSON)
+type queueRecord struct {
+	Op            string              `json:"op"`
+	ID            string              `json:"id"`
+	Entry         *queuedNotification `json:"entry,omitempty"`
+	RetryCount    int                 `json:"retry_count,omitempty"`
+	NextAttempt   time.Time           `json:"next_attempt,omitzero"`
+	LastError     string              `json:"last_error,omitempty"`
+	Held          string              `json:"held,omitempty"`
+	TokenReserved bool                `json:"token_reserved,omitempty"`
+}
+
+// NotificationQueue 通知队列的持久化日志 (追加写入的 JSON Lines 文件)
+// 入队、重试、完成都追加一条记录，重启后重放日志即可恢复未完成的通知；
+// 失效记录过多时重写日志，队列为空时清空日志。path 为空时只在内存中记录 (日志打开失败时)
+type NotificationQueue struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	live      map[string]*queuedNotification // 未完成的通知
+	order     []string                       // 入队顺序 (可能包含已完成的 ID, 压缩时清理)
+	records   int                            // 日志中的记录数
+	groups    map[string]int                 // 分组 -> 未完成的通知数
+	delivered map[string]bool                // 已有通知发送成功的分组 (分组内的通知全部完成后删除)
+}
+
+// 通知队列日志 (打开失败时只在内存中记录, 通知无法在重启后恢复)
+var NotificationQueueStore *NotificationQueue
+
+var queueIDSeq atomic.Uint64
+
+// newQueueID 生成待发送通知的唯一 ID
+func newQueueID() string {
+	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), queueIDSeq.Add(1))
+}
+
+// notificationQueuePath 返回通知队列日志的路径
+func notificationQueuePath() string {
+	configMutex.RLock()
+	dataDir := config.DataDir
+	configMutex.RUnlock()
+	return filepath.Join(dataDir, "notification_queue.jsonl")
+}
+
+// newNotificationQueue 创建空的通知队列, path 为空时不写入日志
+func newNotificationQueue(path string) *NotificationQueue {
+	return &NotificationQueue{
+		path:      path,
+		live:      make(map[string]*queuedNotification),
+		groups:    make(map[string]int),
+		delivered: make(map[string]bool),
+	}
+}
+
+// OpenNotificationQueue 打开通知队列日志，重放其中未完成的通知并返回
+func OpenNotificationQueue(path string) (*NotificationQueue, []queuedNotification, error) {
+	q := newNotificationQueue(path)
+	if err := q.replay(); err != nil {
+		return nil, nil, err
+	}
+	if err := q.compactLocked(); err != nil {
+		return nil, nil, err
+	}
+
+	pending := make([]queuedNotification, 0, len(q.order))
+	for _, id := range q.order {
+		e := *q.live[id]
+		e.ID = id
+		pending = append(pending, e)
+	}
+	return q, pending, nil
+}
+
+// replay 读取日志并恢复未完成的通知
+func (q *NotificationQueue) replay() error {
+	data, err := os.ReadFile(q.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var rec queueRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// 进程崩溃时最后一行可能只写了一半
+			logrus.Warnf("Skipping malformed record at %s:%d: %v", q.path, lineNo, err)
+			continue
+		}
+		switch rec.Op {
+		case queueOpAdd:
+			if rec.Entry == nil {
+				continue
+			}
+			if _, ok := q.live[rec.ID]; !ok {
+				q.order = append(q.order, rec.ID)
+			}
+			q.live[rec.ID] = rec.Entry
+		case queueOpRetry:
+			if e, ok := q.live[rec.ID]; ok {
+				e.RetryCount = rec.RetryCount
+				e.NextAttempt = rec.NextAttempt
+				e.LastError = rec.LastError
+				e.TokenReserved = rec.TokenReserved
+			}
+		case queueOpHold:
+			if e, ok := q.live[rec.ID]; ok {
+				e.Held = rec.Held
+			}
+		case queueOpDone:
+			delete(q.live, rec.ID)
+		case queueOpDelivered:
+			q.delivered[rec.ID] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range q.live {
+		if e.Group != "" {
+			q.groups[e.Group]++
+		}
+	}
+	for group := range q.delivered {
+		if q.groups[group] == 0 {
+			delete(q.delivered, group)
+		}
+	}
+	return nil
+}
+
+// compactLocked 只保留未完成的通知重写日志，并重新打开日志用于追加 (调用方需持有锁或尚未共享)
+func (q *NotificationQueue) compactLocked() error {
+	if q.file != nil {
+		q.file.Close()
+		q.file = nil
+	}
+	if q.path == "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	order := q.order[:0]
+	for _, id := range q.order {
+		e, ok := q.live[id]
+		if !ok {
+			continue
+		}
+		order = append(order, id)
+		line, err := json.Marshal(queueRecord{Op: queueOpAdd, ID: id, Entry: e})
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	q.order = order
+	q.records = len(order)
+	for group := range q.delivered {
+		line, err := json.Marshal(queueRecord{Op: queueOpDelivered, ID: group})
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		q.records++
+	}
+
+	if err := writeFileAtomic(q.path, buf.Bytes()); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	q.file = f
+	return nil
+}
+
+// append 追加一条记录，必要时压缩日志 (调用方需持有锁)
+func (q *NotificationQueue) append(rec queueRecord) {
+	if q.file == nil {
+		return
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		logrus.Errorf("Failed to encode notification queue record: %v", err)
+		return
+	}
+	if _, err := q.file.Write(append(line, '\n')); err != nil {
+		logrus.Errorf("Failed to write notification queue %s: %v", q.path, err)
+		return
+	}
+	q.records++
+
+	if (len(q.live) == 0 && q.records > 0) || (q.records > queueCompactThreshold && q.records > 2*len(q.live)) {
+		if err := q.compactLocked(); err != nil {
+			logrus.Errorf("Failed to compact notification queue %s: %v", q.path, err)
+		}
+	}
+}
+
+// Add 记录通知入队 (线程安全)，pn.ID 为空时分配新 ID
+func (q *NotificationQueue) Add(pn *PendingNotification) {
+	if pn.ID == "" {
+		pn.ID = newQueueID()
+	}
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e := &queuedNotification{
+		NotifID:       pn.Notif.ID,
+		Message:       pn.Message,
+		Title:         pn.Title,
+		HTML:          pn.HTML,
+		Data:          pn.Data,
+		RetryCount:    pn.RetryCount,
+		CreatedAt:     pn.CreatedAt,
+		NextAttempt:   pn.NextAttempt,
+		LastError:     pn.LastError,
+		Held:          pn.Held,
+		Group:         pn.Group,
+		TokenReserved: pn.TokenReserved,
+	}
+	q.live[pn.ID] = e
+	q.order = append(q.order, pn.ID)
+	if pn.Group != "" {
+		q.groups[pn.Group]++
+	}
+	q.append(queueRecord{Op: queueOpAdd, ID: pn.ID, Entry: e})
+}
+
+// Retry 记录通知的重试次数 (线程安全)
+func (q *NotificationQueue) Retry(pn PendingNotification) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.live[pn.ID]
+	if !ok {
+		return
+	}
+	e.RetryCount = pn.RetryCount
+	e.NextAttempt = pn.NextAttempt
+	e.LastError = pn.LastError
+	e.TokenReserved = pn.TokenReserved
+	q.append(queueRecord{Op: queueOpRetry, ID: pn.ID, RetryCount: pn.RetryCount, NextAttempt: pn.NextAttempt, LastError: pn.LastError, TokenReserved: pn.TokenReserved})
+}
+
+// Hold 记录通知已合并到缓冲区 (pn.Held)，重放时放回对应的缓冲区 (线程安全)
+func (q *NotificationQueue) Hold(pn PendingNotification) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.live[pn.ID]
+	if !ok || e.Held == pn.Held {
+		return
+	}
+	e.Held = pn.Held
+	q.append(queueRecord{Op: queueOpHold, ID: pn.ID, Held: pn.Held})
+}
+
+// Done 将通知移出队列 (发送成功或重试耗尽, 线程安全)
+func (q *NotificationQueue) Done(pn PendingNotification) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.live[pn.ID]
+	if !ok {
+		return
+	}
+	delete(q.live, pn.ID)
+	if e.Group != "" {
+		if q.groups[e.Group]--; q.groups[e.Group] <= 0 {
+			// 分组内的通知已全部完成，不再需要送达标记
+			delete(q.groups, e.Group)
+			delete(q.delivered, e.Group)
+		}
+	}
+	q.append(queueRecord{Op: queueOpDone, ID: pn.ID})
+}
+
+// MarkDelivered 记录通知所在的分组已有通知发送成功 (线程安全)
+// 分组中 delivery: any 的其他通知不再发送，即使它们的重试被安排在不同的时间
+func (q *NotificationQueue) MarkDelivered(pn PendingNotification) {
+	if q == nil || pn.Group == "" {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.delivered[pn.Group] || q.groups[pn.Group] == 0 {
+		return
+	}
+	q.delivered[pn.Group] = true
+	q.append(queueRecord{Op: queueOpDelivered, ID: pn.Group})
+}
+
+// Delivered 判断通知所在的分组是否已有通知发送成功 (线程安全)
+func (q *NotificationQueue) Delivered(pn PendingNotification) bool {
+	if q == nil || pn.Group == "" {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.delivered[pn.Group]
+}
+
+// Close 关闭日志文件 (线程安全)
+func (q *NotificationQueue) Close() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.file != nil {
+		q.file.Close()
+		q.file = nil
+	}
+}
+
+// initNotificationQueue 打开通知队列日志并将其中未完成的通知重新加入待发送队列
+// 只在首次初始化时执行；重载配置时内存中的队列保持不变
+func initNotificationQueue() {
+	if NotificationQueueStore != nil {
+		return
+	}
+	path := notificationQueuePath()
+	q, pending, err := OpenNotificationQueue(path)
+	if err != nil {
+		logrus.Errorf("Failed to open notification queue %s, pending notifications will not survive restarts: %v", path, err)
+		NotificationQueueStore = newNotificationQueue("")
+		return
+	}
+	NotificationQueueStore = q
+	if len(pending) == 0 {
+		return
+	}
+
+	configMutex.RLock()
+	notifByID := make(map[string]Notification, len(config.Notifications.Services))
+	for _, notif := range config.Notifications.Services {
+		notifByID[notif.ID] = notif
+	}
+	configMutex.RUnlock()
+
+	restored := make([]PendingNotification, 0, len(pending))
+	held := 0
+	unknown := make(map[string]int)
+	for _, e := range pending {
+		pn := PendingNotification{
+			ID:            e.ID,
+			Message:       e.Message,
+			Title:         e.Title,
+			HTML:          e.HTML,
+			Data:          e.Data,
+			RetryCount:    e.RetryCount,
+			CreatedAt:     e.CreatedAt,
+			NextAttempt:   e.NextAttempt,
+			LastError:     e.LastError,
+			Held:          e.Held,
+			Group:         e.Group,
+			TokenReserved: e.TokenReserved,
+		}
+		if pn.CreatedAt.IsZero() {
+			pn.CreatedAt = time.Now()
+		}
+		notif, ok := notifByID[e.NotifID]
+		if !ok {
+			// 通知项已从配置中删除，或未配置 name 的通知项修改了 config (标识随之变化)
+			logrus.Warnf("Dropping queued notification for IP %s: notification [%s] no longer configured", e.Data.IP, e.NotifID)
+			MetricNotificationsDropped.Inc(e.NotifID, "", DropReasonServiceRemoved)
+			q.Done(pn)
+			unknown[e.NotifID]++
+			continue
+		}
+		pn.Notif = notif
+		switch pn.Held {
+		case heldDigest:
+			// 摘要周期从缓冲区中最早的告警开始计算
+			AddDigestItem(pn)
+			held++
+			continue
+		case heldRateLimit:
+			AddRateLimitOverflow(pn)
+			held++
+			continue
+		}
+		restored = append(restored, pn)
+	}
+	for id, n := range unknown {
+		logrus.Warnf("Dropped %d queued notifications for unknown notification [%s]; "+
+			"notifications without name are identified by service and config, set name to keep queued notifications across config changes", n, id)
+	}
+	AddPendingNotificationsToEnd(restored)
+	logrus.Infof("Restored %d pending notifications and %d buffered digest/rate limit alerts from %s", len(restored), held, path)
+}
+
+// DeadLetter 重试耗尽的通知 (死信日志中的一行 JSON)
+type DeadLetter struct {
+	Time       time.Time    `json:"time"`
+	ID         string       `json:"id"`
+	NotifID    string       `json:"notif_id"`
+	Service    string       `json:"service"`
+	IP         string       `json:"ip"`
+	Title      string       `json:"title"`
+	Message    string       `json:"message"`
+	RetryCount int          `json:"retry_count"`
+	CreatedAt  time.Time    `json:"created_at"`
+	LastError  string       `json:"last_error"`
+	Data       TemplateData `json:"data"`
+}
+
+var deadLetterMutex sync.Mutex
+
+// deadLetterPath 返回死信日志的路径
+func deadLetterPath() string {
+	configMutex.RLock()
+	dataDir := config.DataDir
+	configMutex.RUnlock()
+	return filepath.Join(dataDir, "dead_letter.jsonl")
+}
+
+// WriteDeadLetter 将重试耗尽的通知追加写入死信日志 (线程安全)
+func WriteDeadLetter(pn PendingNotification, now time.Time) {
+	line, err := json.Marshal(DeadLetter{
+		Time:       now,
+		ID:         pn.ID,
+		NotifID:    pn.Notif.ID,
+		Service:    pn.Notif.Service,
+		IP:         pn.Data.IP,
+		Title:      pn.Title,
+		Message:    pn.Message,
+		RetryCount: pn.RetryCount,
+		CreatedAt:  pn.CreatedAt,
+		LastError:  pn.LastError,
+		Data:       pn.Data,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to encode dead letter: %v", err)
+		return
+	}
+
+	deadLetterMutex.Lock()
+	defer deadLetterMutex.Unlock()
+	path := deadLetterPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logrus.Errorf("Failed to write dead letter %s: %v", path, err)
+		return
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		logrus.Errorf("Failed to write dead letter %s: %v", path, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		logrus.Errorf("Failed to write dead letter %s: %v", path, err)
+	}
+}
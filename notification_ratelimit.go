@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TokenBucket 令牌桶限速器
+// 桶容量为每个周期允许的发送次数，令牌按 count/per 的速率持续补充
+type TokenBucket struct {
+	mu       sync.Mutex
+	capacity float64   // 桶容量
+	rate     float64   // 每秒补充的令牌数
+	tokens   float64   // 当前令牌数
+	last     time.Time // 上次补充时间
+}
+
+// NewTokenBucket 创建每 per 时间允许 count 次的令牌桶 (初始为满)
+func NewTokenBucket(count int, per time.Duration) *TokenBucket {
+	return &TokenBucket{
+		capacity: float64(count),
+		rate:     float64(count) / per.Seconds(),
+		tokens:   float64(count),
+	}
+}
+
+// refill 按经过的时间补充令牌 (调用方需持有锁)
+func (b *TokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+}
+
+// Take 尝试取出一个令牌，失败时返回需要等待的时间
+func (b *TokenBucket) Take(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	return false, wait
+}
+
+// TakeToken 按通知项的速率限制取一个令牌 (未配置 rate_limit 时总是成功)
+func (c *NotifierClient) TakeToken(now time.Time) (bool, time.Duration) {
+	if c.limiter == nil {
+		return true, 0
+	}
+	return c.limiter.Take(now)
+}
+
+// 速率汇总缓冲区：通知项 ID -> 因限速未发送的告警 (rate_limit_overflow: summary)
+var RateLimitOverflows = make(map[string]*DigestBuffer)
+var RateLimitOverflowsMutex sync.Mutex
+
+// rateLimitGroupKey 速率汇总消息在发送队列中的分组键 (代替 IP)
+func rateLimitGroupKey(notifID string) string {
+	return "ratelimit:" + notifID
+}
+
+// isRateLimitSummary 判断是否为速率汇总消息 (汇总消息被限速时在队列中等待，不再合并)
+func isRateLimitSummary(pn PendingNotification) bool {
+	return strings.HasPrefix(pn.Data.IP, "ratelimit:")
+}
+
+// AddRateLimitOverflow 将被限速的通知加入速率汇总缓冲区 (线程安全)
+// 通知保留在持久化队列中 (标记为已合并)，汇总消息入队后才移出，进程退出或崩溃后重放到缓冲区
+func AddRateLimitOverflow(pn PendingNotification) {
+	pn.Held = heldRateLimit
+	NotificationQueueStore.Hold(pn)
+
+	RateLimitOverflowsMutex.Lock()
+	defer RateLimitOverflowsMutex.Unlock()
+	buf := RateLimitOverflows[pn.Notif.ID]
+	if buf == nil {
+		buf = NewDigestBuffer(pn.CreatedAt)
+		RateLimitOverflows[pn.Notif.ID] = buf
+	}
+	buf.Add(pn.Data)
+	buf.Held = append(buf.Held, pn)
+	logrus.Debugf("Rate limited notification [%s] for IP %s folded into summary, %d IPs collected", pn.Notif.ID, pn.Data.IP, len(buf.Items))
+}
+
+// FlushRateLimitOverflows 通知项有可用令牌时，将速率汇总缓冲区渲染为一条消息 (使用 digest_template) 加入待发送队列 (线程安全)
+func FlushRateLimitOverflows() {
+	configMutex.RLock()
+	notifByID := make(map[string]Notification, len(config.Notifications.Services))
+	for _, notif := range config.Notifications.Services {
+		notifByID[notif.ID] = notif
+	}
+	configMutex.RUnlock()
+
+	RateLimitOverflowsMutex.Lock()
+	defer RateLimitOverflowsMutex.Unlock()
+
+	now := time.Now()
+	for id, buf := range RateLimitOverflows {
+		notif, ok := notifByID[id]
+		if !ok || len(buf.Items) == 0 {
+			if !ok {
+				logrus.Warnf("Dropping rate limit summary [%s] with %d IPs: notification no longer configured", id, len(buf.Items))
+			}
+			buf.release()
+			delete(RateLimitOverflows, id)
+			continue
+		}
+		// 客户端尚未注册时 (重载过程中) 保留缓冲区，下次刷新时再生成汇总消息
+		client, err := GetNotifierClient(notif)
+		if err != nil {
+			continue
+		}
+		if ok, _ := client.TakeToken(now); !ok {
+			continue
+		}
+		delete(RateLimitOverflows, id)
+
+		pn, err := newDigestNotification(notif, buf, now, rateLimitGroupKey(id))
+		if err != nil {
+			logrus.Errorf("Failed to render rate limit summary [%s], dropping %d IPs: %v", id, len(buf.Items), err)
+			buf.release()
+			continue
+		}
+		pn.TokenReserved = true
+		// 汇总消息写入持久化队列后才移出合并进来的原通知
+		enqueuePendingNotification(pn)
+		buf.release()
+		logrus.Infof("Queued rate limit summary [%s] with %d IPs", id, len(buf.Items))
+	}
+}
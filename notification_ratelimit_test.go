@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRateLimitOverflowSurvivesRestart summary 模式下被限速的告警在汇总消息入队前保留在持久化队列中
+func TestRateLimitOverflowSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notification_queue.jsonl")
+	stub := newWebhookStub(t)
+	stub.ok.Store(true)
+	services := setupDeliveryTest(t, path, []string{DeliveryAny}, stub)
+	notif := services[0]
+	notif.RateLimit, notif.RateLimitOverflow = "1/h", RateLimitOverflowSummary
+	if err := initNotificationConfig(&notif, &Notifications{}); err != nil {
+		t.Fatalf("init notification: %v", err)
+	}
+	BuildNotifierRegistry([]Notification{notif}, 5*time.Second)
+	RateLimitOverflows = make(map[string]*DigestBuffer)
+	t.Cleanup(func() { RateLimitOverflows = make(map[string]*DigestBuffer) })
+
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		QueueNotification(notif, "alert "+ip, "Risk IP Alert", TemplateData{IP: ip, Count: 5}, ip+"@1")
+		processIPNotificationGroup(ip, TakeDuePendingNotifications(time.Now()))
+	}
+	if stub.hits.Load() != 1 {
+		t.Fatalf("expected 1 notification within the rate limit, got %d", stub.hits.Load())
+	}
+	if n := len(RateLimitOverflows[notif.ID].Items); n != 2 {
+		t.Fatalf("expected 2 IPs folded into the summary, got %d", n)
+	}
+
+	// 汇总消息生成前崩溃：重放日志后被限速的告警仍在，并回到速率汇总缓冲区
+	NotificationQueueStore.Close()
+	_, restored, err := OpenNotificationQueue(path)
+	if err != nil {
+		t.Fatalf("reopen queue: %v", err)
+	}
+	if len(restored) != 2 {
+		t.Fatalf("expected 2 throttled alerts in the journal, got %d", len(restored))
+	}
+	for _, e := range restored {
+		if e.Held != heldRateLimit {
+			t.Fatalf("alert for %s not marked as held: %q", e.Data.IP, e.Held)
+		}
+	}
+}
+
+// TestRateLimitOverflowReleasedAfterSummary 汇总消息入队后原告警移出持久化队列，只保留汇总消息
+func TestRateLimitOverflowReleasedAfterSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notification_queue.jsonl")
+	stub := newWebhookStub(t)
+	services := setupDeliveryTest(t, path, []string{DeliveryAny}, stub)
+	notif := services[0]
+	notif.RateLimitOverflow = RateLimitOverflowSummary
+	RateLimitOverflows = make(map[string]*DigestBuffer)
+	t.Cleanup(func() { RateLimitOverflows = make(map[string]*DigestBuffer) })
+
+	configMutex.Lock()
+	prev := config
+	config.Notifications.Services = []Notification{notif}
+	configMutex.Unlock()
+	t.Cleanup(func() {
+		configMutex.Lock()
+		config = prev
+		configMutex.Unlock()
+	})
+
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+		pn := PendingNotification{Notif: notif, Message: "alert " + ip, Data: TemplateData{IP: ip, Count: 1}}
+		enqueuePendingNotification(pn)
+	}
+	for _, pn := range TakeDuePendingNotifications(time.Now()) {
+		AddRateLimitOverflow(pn)
+	}
+	FlushRateLimitOverflows()
+
+	pending := TakeDuePendingNotifications(time.Now())
+	if len(pending) != 1 || !isRateLimitSummary(pending[0]) {
+		t.Fatalf("expected a single rate limit summary, got %+v", pending)
+	}
+	NotificationQueueStore.Close()
+	_, restored, err := OpenNotificationQueue(path)
+	if err != nil {
+		t.Fatalf("reopen queue: %v", err)
+	}
+	if len(restored) != 1 || restored[0].Data.IP != rateLimitGroupKey(notif.ID) {
+		t.Fatalf("expected only the summary in the journal, got %+v", restored)
+	}
+	// 汇总消息生成时占用的令牌在重启后仍然有效，不会再取第二个令牌
+	if !restored[0].TokenReserved {
+		t.Fatal("token reservation of the rate limit summary not persisted")
+	}
+}
+
+// TestUnregisteredNotifierDeadLettered 通知项不在客户端注册表中 (重载时删除或改名) 时不绕过速率限制发送，而是写入死信日志
+func TestUnregisteredNotifierDeadLettered(t *testing.T) {
+	dir := t.TempDir()
+	setTestConfig(t, Config{DataDir: dir})
+	stub := newWebhookStub(t)
+	stub.ok.Store(true)
+	services := setupDeliveryTest(t, filepath.Join(dir, "notification_queue.jsonl"), []string{DeliveryAny}, stub)
+
+	gone := services[0]
+	gone.ID = "renamed"
+	pn := PendingNotification{Notif: gone, Message: "alert", Data: TemplateData{IP: "192.0.2.1", Count: 1}}
+	enqueuePendingNotification(pn)
+	processIPNotificationGroup("192.0.2.1", TakeDuePendingNotifications(time.Now()))
+
+	if stub.hits.Load() != 0 {
+		t.Fatalf("notification for unregistered id was sent %d times", stub.hits.Load())
+	}
+	data, err := os.ReadFile(deadLetterPath())
+	if err != nil || !strings.Contains(string(data), `"notif_id":"renamed"`) {
+		t.Fatalf("expected dead letter for renamed notification: %v\n%s", err, data)
+	}
+	if n := len(TakeDuePendingNotifications(time.Now().Add(time.Hour))); n != 0 {
+		t.Fatalf("expected empty queue, got %d", n)
+	}
+}
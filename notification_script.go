@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// scriptOutputLimit 捕获的脚本输出上限 (字节)，超出部分被截断
+const scriptOutputLimit = 4096
+
+// defaultScriptConcurrency 同一通知项默认允许同时运行的脚本数
+const defaultScriptConcurrency = 4
+
+// ScriptData script 服务参数与环境变量模板的数据
+// 包含 TemplateData 的全部字段，以及渲染后的消息标题与内容：
+//   - {{.IP}}、{{.Count}}、{{.SourceListInfo.Name}}、{{.SourceLogInfo.Level}} 等 - 同消息模板变量
+//   - {{.Title}}   - 消息标题 (payload_title)
+//   - {{.Message}} - 消息内容 (payload_template 渲染结果)
+type ScriptData struct {
+	TemplateData
+	Title   string
+	Message string
+}
+
+// scriptConfig script 服务配置 (来自 notification.config)
+type scriptConfig struct {
+	Path        string                        // 可执行文件路径 (必填)
+	Args        []*template.Template          // 参数模板
+	Env         map[string]*template.Template // 额外的环境变量模板
+	Dir         string                        // 工作目录 (可选)
+	Stdin       bool                          // 是否将消息内容写入标准输入
+	Timeout     time.Duration                 // 执行超时 (默认 notifications.timeout)
+	Concurrency int                           // 最大并发数 (默认 4)
+}
+
+// scriptRunner 单个 script 通知项的执行器 (由 NotifierClient 复用，并发数在同一通知项的所有发送间共享)
+type scriptRunner struct {
+	cfg *scriptConfig
+	sem chan struct{}
+}
+
+// parseScriptConfig 解析 script 服务配置
+// config 支持: path (必填), args (列表), env (映射), dir, stdin, timeout, max_concurrency
+func parseScriptConfig(cfg map[string]any, defaultTimeout time.Duration) (*scriptConfig, error) {
+	sc := &scriptConfig{Timeout: defaultTimeout, Concurrency: defaultScriptConcurrency}
+	path, ok := cfg["path"].(string)
+	if !ok || path == "" {
+		return nil, fmt.Errorf("Script path not configured or not string")
+	}
+	sc.Path = path
+
+	switch args := cfg["args"].(type) {
+	case nil:
+	case []any:
+		for i, a := range args {
+			tmpl, err := template.New(fmt.Sprintf("arg%d", i)).Parse(fmt.Sprint(a))
+			if err != nil {
+				return nil, fmt.Errorf("invalid script args[%d]: %v", i, err)
+			}
+			sc.Args = append(sc.Args, tmpl)
+		}
+	default:
+		return nil, fmt.Errorf("Script args must be a list")
+	}
+
+	switch env := cfg["env"].(type) {
+	case nil:
+	case map[string]any:
+		sc.Env = make(map[string]*template.Template, len(env))
+		for k, v := range env {
+			tmpl, err := template.New(k).Parse(fmt.Sprint(v))
+			if err != nil {
+				return nil, fmt.Errorf("invalid script env %s: %v", k, err)
+			}
+			sc.Env[k] = tmpl
+		}
+	default:
+		return nil, fmt.Errorf("Script env must be a mapping")
+	}
+
+	if dir, ok := cfg["dir"].(string); ok {
+		sc.Dir = dir
+	}
+	if stdin, ok := cfg["stdin"].(bool); ok {
+		sc.Stdin = stdin
+	}
+	if t, ok := cfg["timeout"].(string); ok && t != "" {
+		dur, err := ParseDuration(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid script timeout: %v", err)
+		}
+		sc.Timeout = dur
+	}
+	if n, ok := cfg["max_concurrency"].(int); ok {
+		if n <= 0 {
+			return nil, fmt.Errorf("Script max_concurrency must be positive")
+		}
+		sc.Concurrency = n
+	}
+	return sc, nil
+}
+
+// newScriptRunner 根据通知配置创建脚本执行器
+func newScriptRunner(notif Notification, defaultTimeout time.Duration) (*scriptRunner, error) {
+	cfg, err := parseScriptConfig(notif.Config, defaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &scriptRunner{cfg: cfg, sem: make(chan struct{}, cfg.Concurrency)}, nil
+}
+
+// Run 执行脚本 (单次尝试)，退出码非 0 或超时视为失败
+// 参数与环境变量由 data 渲染，进程继承当前环境并额外设置 IPLOG_* 变量；输出被捕获用于日志与错误信息
+func (r *scriptRunner) Run(notifID string, data ScriptData) error {
+	args := make([]string, 0, len(r.cfg.Args))
+	for _, tmpl := range r.cfg.Args {
+		arg, err := executeTemplate(tmpl, data)
+		if err != nil {
+			return fmt.Errorf("failed to render script args: %v", err)
+		}
+		args = append(args, arg)
+	}
+	env := append(os.Environ(), scriptBaseEnv(data)...)
+	keys := make([]string, 0, len(r.cfg.Env))
+	for k := range r.cfg.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, err := executeTemplate(r.cfg.Env[k], data)
+		if err != nil {
+			return fmt.Errorf("failed to render script env %s: %v", k, err)
+		}
+		env = append(env, k+"="+v)
+	}
+
+	// 限制并发数，排队等待的时间不计入执行超时
+	r.sem <- struct{}{}
+	defer func() { <-r.sem }()
+
+	ctx := context.Background()
+	if r.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.cfg.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, r.cfg.Path, args...)
+	cmd.Env = env
+	cmd.Dir = r.cfg.Dir
+	// 超时后子进程仍持有输出管道时，最多再等待 1 秒
+	cmd.WaitDelay = time.Second
+	if r.cfg.Stdin {
+		cmd.Stdin = strings.NewReader(data.Message)
+	}
+	out := &limitedBuffer{limit: scriptOutputLimit}
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	logrus.Debugf("Use service: script [%s], run: %s %s", notifID, r.cfg.Path, strings.Join(args, " "))
+	err := cmd.Run()
+	output := strings.TrimSpace(out.String())
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("script timed out after %s: %s", r.cfg.Timeout, output)
+	}
+	if err != nil {
+		return fmt.Errorf("script failed: %v: %s", err, output)
+	}
+	if output != "" {
+		logrus.Debugf("Script [%s] output: %s", notifID, output)
+	}
+	return nil
+}
+
+// scriptBaseEnv 返回传递给脚本的 IPLOG_* 环境变量
+func scriptBaseEnv(data ScriptData) []string {
+	return []string{
+		"IPLOG_IP=" + data.IP,
+		"IPLOG_COUNT=" + strconv.Itoa(data.Count),
+		"IPLOG_LIST=" + data.SourceListInfo.Name,
+		"IPLOG_LIST_LEVEL=" + strconv.Itoa(data.SourceListInfo.Level),
+		"IPLOG_LOG=" + data.SourceLogInfo.Name,
+		"IPLOG_LOG_LEVEL=" + strconv.Itoa(data.SourceLogInfo.Level),
+		"IPLOG_TIMESTAMP=" + strconv.FormatInt(data.Timestamp, 10),
+		"IPLOG_TITLE=" + data.Title,
+		"IPLOG_MESSAGE=" + data.Message,
+	}
+}
+
+// executeTemplate 渲染模板为字符串
+func executeTemplate(tmpl *template.Template, data any) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// limitedBuffer 只保留前 limit 字节的输出缓冲区
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+			b.truncated = true
+		} else {
+			b.buf.Write(p)
+		}
+	} else if len(p) > 0 {
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "...(truncated)"
+	}
+	return b.buf.String()
+}
@@ -2,10 +2,11 @@ package main
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
+	htmltemplate "html/template"
 	nethttp "net/http"
+	"net/netip"
 	"net/url"
 	"strconv"
 	"strings"
@@ -29,55 +30,107 @@ import (
 )
 
 // AddNotificationItem 添加通知项 (线程安全)
-func AddNotificationItem(ip uint32, finfo ListInfo, linfo ListInfo) {
+// matches 为命中的所有风险列表 (按等级从高到低排序), field 为 IP 在日志行中的来源字段, fields 为结构化日志字段
+func AddNotificationItem(ip netip.Addr, finfo ListInfo, matches []ListInfo, field string, fields LogFields) {
+	configMutex.RLock()
+	retention, _ := hitRetention(config.Notifications.Services)
+	configMutex.RUnlock()
+
 	NotificationMapMutex.Lock()
 	defer NotificationMapMutex.Unlock()
-	// 计算当前IP的命中次数（当前已有的项数 + 1）
-	currentCount := len(NotificationMap[ip]) + 1
-	NotificationMap[ip] = append(NotificationMap[ip], NewNotificationItem(ip, currentCount, finfo, linfo))
+	record := NotificationMap[ip]
+	if record == nil {
+		record = &IPHitRecord{}
+		NotificationMap[ip] = record
+	}
+	// Count 由 record.Add 设置为累计命中次数
+	record.Add(NewNotificationItem(ip, 0, finfo, matches, field, fields), retention)
+}
+
+// hitRetention 计算命中桶需要保留的时长 (所有通知 window 的最大值)
+// evictable 表示所有通知均配置了 window，此时超出最大窗口未再命中的 IP 可以直接删除
+func hitRetention(services []Notification) (retention time.Duration, evictable bool) {
+	evictable = len(services) > 0
+	for _, notif := range services {
+		if notif.WindowParsed > retention {
+			retention = notif.WindowParsed
+		}
+		if notif.WindowParsed <= 0 {
+			evictable = false
+		}
+	}
+	return retention, evictable
 }
 
 // AddPendingNotification 添加待发送通知到队列 (线程安全)
-func AddPendingNotification(notif Notification, message, title string, data TemplateData) {
-	PendingNotificationsMutex.Lock()
-	defer PendingNotificationsMutex.Unlock()
-	PendingNotifications = append(PendingNotifications, PendingNotification{
+// html 为 HTML 正文 (仅 email 服务使用, 可为空)
+func AddPendingNotification(notif Notification, message, title, html string, data TemplateData) {
+	enqueuePendingNotification(PendingNotification{
 		Notif:   notif,
 		Message: message,
 		Title:   title,
+		HTML:    html,
 		Data:    data,
 	})
 }
 
+// enqueuePendingNotification 将构造好的通知加入待发送队列 (线程安全)
+func enqueuePendingNotification(pn PendingNotification) {
+	if pn.CreatedAt.IsZero() {
+		pn.CreatedAt = time.Now()
+	}
+	// 先写入持久化日志, 进程退出或重载配置后可恢复
+	NotificationQueueStore.Add(&pn)
+
+	PendingNotificationsMutex.Lock()
+	defer PendingNotificationsMutex.Unlock()
+	PendingNotifications = append(PendingNotifications, pn)
+}
+
 // CheckAndNotify 检查是否达到阈值并将通知加入队列 (异步发送)
 // 该函数检查所有待处理的 IP，对于满足条件的通知加入 PendingNotifications 队列
 // 通知由独立的 goroutine 定时检查并发送
 func CheckAndNotify(info ListInfo, isOnce bool) {
+	configMutex.RLock()
+	notificationServices := make([]Notification, len(config.Notifications.Services))
+	copy(notificationServices, config.Notifications.Services)
+	configMutex.RUnlock()
+	retention, evictable := hitRetention(notificationServices)
+
 	NotificationMapMutex.Lock()
 	defer NotificationMapMutex.Unlock()
 
-	for ip, items := range NotificationMap {
-		if len(items) == 0 {
+	now := time.Now().Unix()
+	for ip, record := range NotificationMap {
+		// 清理超出最大时间窗口的命中桶
+		if retention > 0 {
+			record.Evict(now - int64(retention/time.Second))
+		}
+		if evictable && len(record.Buckets) == 0 {
+			// 所有通知都配置了 window 且窗口内已无命中，删除该 IP
+			delete(NotificationMap, ip)
+			continue
+		}
+		if record.Total == 0 {
 			continue
 		}
 		// 获取最新项
-		latest := items[len(items)-1]
-		ipStr := Uint32ToIPv4(ip).String()
+		latest := record.Latest
+		ipStr := ip.String()
 		timeStr := time.Unix(latest.Timestamp, 0).Format("2006-01-02 15:04:05")
-		data := NewTemplateData(ipStr, latest.Count, latest.SourceListInfo, latest.SourceLogInfo, latest.Timestamp, timeStr)
 
 		// 对于每个通知配置，独立判断其触发条件：
-		// - 命中次数 >= notif.Threshold
+		// - 命中次数 (配置了 window 时为窗口内的命中次数) >= notif.Threshold
 		// - 日志文件等级 (latest.SourceLogInfo.Level) >= notif.LogLevel
-		// - IP 风险等级 (latest.SourceListInfo.Level) >= notif.RiskLevel
-		sentAny := false
-		configMutex.RLock()
-		notificationServices := make([]Notification, len(config.Notifications.Services))
-		copy(notificationServices, config.Notifications.Services)
-		configMutex.RUnlock()
-
+		// - IP 风险等级 (latest.SourceListInfo.Level，即命中列表中的最高等级) >= notif.RiskLevel
+		// handled: 有通知入队或被冷却抑制 (两者都会在 tail 模式下重置该 IP 的计数)
+		handled, queued := false, false
+		// 同一次检查中该 IP 触发的各通知项属于同一告警分组 (delivery: any 时任一送达即可)
+		// 分组 ID 在第一条通知入队时才生成
+		var group string
 		for _, notif := range notificationServices {
-			if latest.Count < notif.Threshold {
+			count := record.CountIn(notif.WindowParsed, now)
+			if count < notif.Threshold {
 				continue
 			}
 
@@ -93,35 +146,40 @@ func CheckAndNotify(info ListInfo, isOnce bool) {
 				continue
 			}
 
-			// 解析模板
-			tmpl, err := template.New("payload").Parse(notif.PayloadTemplate)
+			data := NewTemplateData(ipStr, count, latest.SourceListInfo, latest.SourceLogInfo, latest.Matches, latest.Field, latest.Fields, latest.Timestamp, timeStr)
+
+			// 达到触发条件时执行封禁动作 (不受冷却期影响，已封禁的 IP 会被跳过)
+			TriggerActions(ip, notif, data)
+
+			message, title, err := renderNotification(notif, notif.PayloadTemplate, data)
 			if err != nil {
-				logrus.Errorf("Failed to parse template: %v", err)
+				logrus.Errorf("Failed to render notification [%s]: %v", notif.ID, err)
 				continue
 			}
-			var buf bytes.Buffer
-			if err := tmpl.Execute(&buf, data); err != nil {
-				logrus.Errorf("Failed to execute template: %v", err)
+
+			// 冷却期内只计数，不发送
+			if suppressAlert(ip, notif, data) {
+				handled = true
+				logrus.Debugf("Suppressed notification [%s] for IP %s during cooldown, count: %d", notif.ID, ipStr, count)
 				continue
 			}
-			message := buf.String()
 
-			// 获取标题
-			title := notif.PayloadTitle
-			if title == "" {
-				title = "Risk IP Alert"
+			// 将通知加入待发送队列 (配置了 digest 时加入摘要缓冲区)
+			if group == "" {
+				group = ipStr + "@" + newQueueID()
 			}
-
-			// 将通知加入待发送队列
-			AddPendingNotification(notif, message, title, data)
-			sentAny = true
+			QueueNotification(notif, message, title, data, group)
+			RecordRecentNotification(notif, data)
+			handled, queued = true, true
 			logrus.Debugf("Queued notification [%s] for IP %s, log_level: %d, risk_level: %d, count: %d",
-				notif.Service, ipStr, latest.SourceLogInfo.Level, latest.SourceListInfo.Level, latest.Count)
+				notif.Service, ipStr, latest.SourceLogInfo.Level, latest.SourceListInfo.Level, count)
 		}
 
-		if sentAny {
+		if queued {
 			logrus.Infof("Notification queued for IP %s from %s, list_level: %d, log_level: %d, count: %d",
 				ipStr, info.Name, latest.SourceListInfo.Level, latest.SourceLogInfo.Level, latest.Count)
+		}
+		if handled {
 			if !isOnce {
 				// tail 模式下，通知后清理该 IP
 				delete(NotificationMap, ip)
@@ -136,6 +194,113 @@ func CheckAndNotify(info ListInfo, isOnce bool) {
 	}
 }
 
+// renderNotification 使用模板渲染通知消息，返回消息内容与标题
+func renderNotification(notif Notification, payloadTemplate string, data TemplateData) (message, title string, err error) {
+	tmpl, err := template.New("payload").Parse(payloadTemplate)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse template: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", "", fmt.Errorf("failed to execute template: %v", err)
+	}
+
+	// 获取标题
+	title = notif.PayloadTitle
+	if title == "" {
+		title = "Risk IP Alert"
+	}
+	return buf.String(), title, nil
+}
+
+// renderHTMLBody 使用 html_template 渲染 HTML 正文 (使用 html/template, 变量会被转义)
+// 未配置 html_template 时返回空；渲染失败时记录错误并返回空, 仅发送纯文本正文
+func renderHTMLBody(notif Notification, data any) string {
+	if notif.HTMLTemplate == "" {
+		return ""
+	}
+	tmpl, err := htmltemplate.New("html").Parse(notif.HTMLTemplate)
+	if err != nil {
+		logrus.Errorf("Failed to parse html_template of [%s]: %v", notif.ID, err)
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		logrus.Errorf("Failed to execute html_template of [%s]: %v", notif.ID, err)
+		return ""
+	}
+	return buf.String()
+}
+
+// suppressAlert 检查 IP 在该通知项上是否处于冷却期 (线程安全)
+// 冷却期内记录被抑制的告警并返回 true；否则 (配置了 cooldown 时) 开始新的冷却期并返回 false
+func suppressAlert(ip netip.Addr, notif Notification, data TemplateData) bool {
+	if notif.CooldownParsed <= 0 {
+		return false
+	}
+	AlertCooldownsMutex.Lock()
+	defer AlertCooldownsMutex.Unlock()
+
+	key := cooldownKey{IP: ip, NotifID: notif.ID}
+	now := time.Now()
+	if state, ok := AlertCooldowns[key]; ok && now.Before(state.Until) {
+		state.Suppressed++
+		state.SuppressedHits += data.Count
+		state.LastData = data
+		return true
+	}
+	AlertCooldowns[key] = &CooldownState{Until: now.Add(notif.CooldownParsed)}
+	return false
+}
+
+// FlushExpiredCooldowns 处理已结束的冷却期 (线程安全)
+// 冷却期内有被抑制的告警时，发送一条包含抑制次数的汇总消息并开始新的冷却期；否则删除冷却状态
+func FlushExpiredCooldowns() {
+	configMutex.RLock()
+	notifByID := make(map[string]Notification, len(config.Notifications.Services))
+	for _, notif := range config.Notifications.Services {
+		notifByID[notif.ID] = notif
+	}
+	configMutex.RUnlock()
+
+	AlertCooldownsMutex.Lock()
+	defer AlertCooldownsMutex.Unlock()
+
+	now := time.Now()
+	for key, state := range AlertCooldowns {
+		if now.Before(state.Until) {
+			continue
+		}
+		notif, ok := notifByID[key.NotifID]
+		if !ok || state.Suppressed == 0 || notif.CooldownParsed <= 0 {
+			// 通知项已被删除、冷却已关闭或冷却期内没有新的告警
+			delete(AlertCooldowns, key)
+			continue
+		}
+
+		data := state.LastData
+		data.IsFollowUp = true
+		data.Suppressed = state.Suppressed
+		data.SuppressedHits = state.SuppressedHits
+		tmpl := notif.FollowUpTemplate
+		if tmpl == "" {
+			tmpl = notif.PayloadTemplate
+		}
+		message, title, err := renderNotification(notif, tmpl, data)
+		if err != nil {
+			logrus.Errorf("Failed to render follow-up notification [%s]: %v", notif.ID, err)
+			delete(AlertCooldowns, key)
+			continue
+		}
+		QueueNotification(notif, message, title, data, "")
+		logrus.Infof("Queued follow-up notification [%s] for IP %s, suppressed %d alerts (%d hits) during cooldown",
+			notif.ID, data.IP, state.Suppressed, state.SuppressedHits)
+
+		// 汇总消息同样是一次告警，开始新的冷却期
+		AlertCooldowns[key] = &CooldownState{Until: now.Add(notif.CooldownParsed)}
+	}
+}
+
 // setupNotificationService 根据通知类型设置对应的服务
 func setupNotificationService(notif Notification) (notify.Notifier, error) {
 	switch strings.ToLower(notif.Service) {
@@ -434,7 +599,8 @@ func setupWebpushService(notif Notification) (notify.Notifier, error) {
 }
 
 // sendCurlNotification 发送 curl 通知 (特殊处理，不使用 notify 库，单次尝试)
-func sendCurlNotification(notif Notification, message, title string, timeout time.Duration) error {
+// c 为复用的 HTTP 客户端
+func sendCurlNotification(notif Notification, message, title string, c *req.Client) error {
 	urlStr, ok := notif.Config["url"].(string)
 	if !ok {
 		return fmt.Errorf("Curl url not configured or not string")
@@ -452,13 +618,6 @@ func sendCurlNotification(notif Notification, message, title string, timeout tim
 		}
 	}
 
-	c := req.C()
-	if timeout > 0 {
-		c = c.SetTimeout(timeout)
-	}
-	if logrus.IsLevelEnabled(logrus.DebugLevel) {
-		c.EnableDebugLog()
-	}
 	r := c.R().SetHeaders(headers)
 	var resp *req.Response
 	var err error
@@ -515,32 +674,11 @@ func sendCurlNotification(notif Notification, message, title string, timeout tim
 }
 
 // sendNotification 发送通知, 返回错误信息 (单次尝试，不内部重试，由 notification_worker 统一管理重试)
-func sendNotification(notif Notification, message, title string) error {
-	configMutex.RLock()
-	timeout := config.Notifications.TimeoutParsed
-	configMutex.RUnlock()
-
-	// 特殊处理 curl 服务
-	if strings.ToLower(notif.Service) == "curl" {
-		return sendCurlNotification(notif, message, title, timeout)
-	}
-
-	// 其他服务使用 notify 库
-	service, err := setupNotificationService(notif)
+// html 为 HTML 正文, 仅 email 服务使用；data 为模板数据, 仅 script 服务使用；客户端从注册表中获取并复用
+func sendNotification(notif Notification, message, title, html string, data TemplateData) error {
+	client, err := GetNotifierClient(notif)
 	if err != nil {
-		return fmt.Errorf("failed to setup notification service: %v", err)
+		return err
 	}
-
-	ntf := notify.New()
-	ntf.UseServices(service)
-
-	if timeout > 0 {
-		sendCtx, cancel := context.WithTimeout(context.Background(), timeout)
-		err = ntf.Send(sendCtx, title, message)
-		cancel()
-	} else {
-		err = ntf.Send(context.Background(), title, message)
-	}
-	logrus.Debugf("Use service: %s, send Title: %s, message: %s", notif.Service, title, message)
-	return err
+	return client.Send(message, title, html, data)
 }
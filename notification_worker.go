@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -28,6 +29,12 @@ func StartNotificationWorker(ctx context.Context) {
 				logrus.Info("Notification worker stopped")
 				return
 			case <-ticker.C:
+				// 冷却结束的告警生成汇总消息
+				FlushExpiredCooldowns()
+				// 到达周期的摘要合并为一条消息
+				FlushDigests()
+				// 超出速率限制的通知在有令牌时合并为一条汇总消息
+				FlushRateLimitOverflows()
 				// 不阻塞，每次检测都在新的 goroutine 中处理
 				go processAndSendNotifications()
 			}
@@ -36,11 +43,11 @@ func StartNotificationWorker(ctx context.Context) {
 	logrus.Info("Notification worker started (checking every 1s)")
 }
 
-// processAndSendNotifications 处理并发送所有待发送的通知
-// 按 IP 分组处理，只要有一个通知发送成功，就认为该 IP 的本次通知成功
+// processAndSendNotifications 处理并发送所有已到发送时间的通知
+// 按 IP 分组处理，delivery: any 的通知项只要同一告警分组中有一个通知发送成功，就认为该告警已送达
 func processAndSendNotifications() {
-	// 从队列中取出所有待发送通知
-	toSend := TakeAllPendingNotifications()
+	// 从队列中取出已到发送时间的通知 (退避等待中的通知留在队列中)
+	toSend := TakeDuePendingNotifications(time.Now())
 	if len(toSend) == 0 {
 		return
 	}
@@ -54,21 +61,13 @@ func processAndSendNotifications() {
 		ipGroups[ip] = append(ipGroups[ip], pn)
 	}
 
-	// 获取最大重试次数
-	configMutex.RLock()
-	maxRetry := config.Notifications.RetryCount
-	configMutex.RUnlock()
-	if maxRetry <= 0 {
-		maxRetry = 5 // 默认 5 次
-	}
-
 	// 并行处理每个 IP 分组
 	var wg sync.WaitGroup
 	for ip, group := range ipGroups {
 		wg.Add(1)
 		go func(ip string, notifications []PendingNotification) {
 			defer wg.Done()
-			processIPNotificationGroup(ip, notifications, maxRetry)
+			processIPNotificationGroup(ip, notifications)
 		}(ip, group)
 	}
 	wg.Wait()
@@ -77,27 +76,64 @@ func processAndSendNotifications() {
 }
 
 // processIPNotificationGroup 处理单个 IP 的通知组
-// 只要有一个成功就认为成功，失败的不再重试
-// 如果全部失败，失败的放回队尾
-func processIPNotificationGroup(ip string, notifications []PendingNotification, maxRetry int) {
+// delivery: any (默认) 的通知项只要同一告警分组 (PendingNotification.Group) 中有一个成功就认为成功，失败的不再重试；
+// 送达标记记录在通知队列中，各通知项的重试被安排在不同时间 (不在同一批发送) 时同样生效。
+// delivery: independent 的通知项各自重试直到发送成功或重试耗尽。
+// 需要重试的按各通知项的重试策略计算下次发送时间后放回队尾；重试耗尽的写入死信日志
+func processIPNotificationGroup(ip string, notifications []PendingNotification) {
 	if len(notifications) == 0 {
 		return
 	}
 
+	// 按各通知项的速率限制取令牌，取不到的本次不发送
+	now := time.Now()
+	var toSend, throttled []PendingNotification
+	for _, pn := range notifications {
+		if deliveredOther(pn) {
+			// 同组的其他通知项已在之前的发送中成功
+			logrus.Infof("Skipping notification [%s] for IP %s: already delivered by another notification", pn.Notif.ID, ip)
+			MetricNotificationsDropped.Inc(pn.Notif.ID, pn.Notif.Service, DropReasonDeliveredOther)
+			NotificationQueueStore.Done(pn)
+			continue
+		}
+		client, err := GetNotifierClient(pn.Notif)
+		if err != nil {
+			// 通知项已在重载时删除或改名 (重载前取出的通知)，没有对应的速率限制状态，写入死信日志
+			logrus.Errorf("Dropping notification [%s] for IP %s to dead letter log: %v", pn.Notif.ID, ip, err)
+			pn.LastError = err.Error()
+			WriteDeadLetter(pn, now)
+			MetricNotificationsDropped.Inc(pn.Notif.ID, pn.Notif.Service, DropReasonServiceRemoved)
+			NotificationQueueStore.Done(pn)
+			continue
+		}
+		if pn.TokenReserved {
+			// 速率汇总消息在生成时已占用令牌
+			pn.TokenReserved = false
+			toSend = append(toSend, pn)
+			continue
+		}
+		if ok, wait := client.TakeToken(now); !ok {
+			pn.NextAttempt = now.Add(wait)
+			throttled = append(throttled, pn)
+			continue
+		}
+		toSend = append(toSend, pn)
+	}
+
 	// 并行发送所有通知，使用结构化的结果类型
 	type sendResult struct {
 		notification PendingNotification
 		success      bool
 		err          error
 	}
-	results := make([]sendResult, len(notifications))
+	results := make([]sendResult, len(toSend))
 	var wg sync.WaitGroup
 
-	for i, pn := range notifications {
+	for i, pn := range toSend {
 		wg.Add(1)
 		go func(idx int, notification PendingNotification) {
 			defer wg.Done()
-			err := sendNotification(notification.Notif, notification.Message, notification.Title)
+			err := sendNotification(notification.Notif, notification.Message, notification.Title, notification.HTML, notification.Data)
 			results[idx] = sendResult{
 				notification: notification,
 				success:      err == nil,
@@ -109,13 +145,12 @@ func processIPNotificationGroup(ip string, notifications []PendingNotification,
 	// 等待所有发送完成
 	wg.Wait()
 
-	// 分析结果
+	// 分析结果，发送成功的通知记录其分组已送达
 	var successCount int
-	var failedNotifications []PendingNotification
-
 	for _, result := range results {
 		if result.success {
 			successCount++
+			NotificationQueueStore.MarkDelivered(result.notification)
 			// 增加全局通知发送计数
 			IncrementNotificationsSent()
 			logrus.Infof("Successfully sent notification [%s] for IP %s (count: %d, list_level: %d, log_level: %d)",
@@ -123,41 +158,108 @@ func processIPNotificationGroup(ip string, notifications []PendingNotification,
 				result.notification.Data.Count,
 				result.notification.Data.SourceListInfo.Level,
 				result.notification.Data.SourceLogInfo.Level)
-		} else {
-			// 处理失败的通知
-			pn := result.notification
-			pn.RetryCount++
-
-			if pn.RetryCount >= maxRetry {
-				// 重试耗尽，记录错误
-				logrus.Errorf("Failed to send notification [%s] for IP %s after %d retries: %v",
-					pn.Notif.Service, ip, pn.RetryCount, result.err)
-			} else {
-				// 记录警告，准备重试
-				logrus.Warnf("Failed to send notification [%s] for IP %s (retry %d/%d): %v",
-					pn.Notif.Service, ip, pn.RetryCount, maxRetry, result.err)
-				failedNotifications = append(failedNotifications, pn)
-			}
 		}
 	}
 
-	// 如果有成功的，不再重试失败的
-	if successCount > 0 {
-		if len(failedNotifications) > 0 {
-			logrus.Infof("Notification for IP %s completed: %d success, %d failed (not retrying due to success)",
-				ip, successCount, len(notifications)-successCount)
-		} else {
-			logrus.Infof("All %d notifications for IP %s sent successfully", successCount, ip)
+	// 被限速的通知：delivery: any 且同组已有成功的不再发送；summary 模式合并为汇总消息；其余在队列中等待令牌
+	var deferred []PendingNotification
+	for _, pn := range throttled {
+		switch {
+		case deliveredOther(pn):
+			MetricNotificationsDropped.Inc(pn.Notif.ID, pn.Notif.Service, DropReasonDeliveredOther)
+			NotificationQueueStore.Done(pn)
+		case pn.Notif.RateLimitOverflow == RateLimitOverflowSummary && !isRateLimitSummary(pn):
+			AddRateLimitOverflow(pn)
+		default:
+			NotificationQueueStore.Retry(pn)
+			deferred = append(deferred, pn)
 		}
+	}
+	if len(throttled) > 0 {
+		logrus.Infof("Rate limited %d notifications for IP %s (%d waiting in queue)", len(throttled), ip, len(deferred))
+		AddPendingNotificationsToEnd(deferred)
+	}
+	if len(results) == 0 {
 		return
 	}
 
-	// 全部失败，将未超过重试次数的放回队尾
-	if len(failedNotifications) > 0 {
+	// delivery: any 的通知项在同一告警分组有任一通知成功时不再重试；
+	// delivery: independent 的通知项只看自身结果，失败后按各自的重试策略继续重试
+	var failedNotifications []PendingNotification
+	var skipped, exhausted int
+	for _, result := range results {
+		pn := result.notification
+		if result.success {
+			NotificationQueueStore.Done(pn)
+			continue
+		}
+		if deliveredOther(pn) {
+			skipped++
+			logrus.Warnf("Failed to send notification [%s] for IP %s: %v (not retrying due to success)",
+				pn.Notif.ID, ip, result.err)
+			MetricNotificationsDropped.Inc(pn.Notif.ID, pn.Notif.Service, DropReasonDeliveredOther)
+			NotificationQueueStore.Done(pn)
+			continue
+		}
+
+		pn.RetryCount++
+		pn.LastError = result.err.Error()
+		policy := pn.Notif.Retry
+
+		if pn.RetryCount >= policy.Count || (policy.MaxAgeParsed > 0 && now.Sub(pn.CreatedAt) >= policy.MaxAgeParsed) {
+			// 重试耗尽，写入死信日志并移出持久化队列
+			exhausted++
+			logrus.Errorf("Failed to send notification [%s] for IP %s after %d attempts (%s since queued), giving up: %v",
+				pn.Notif.ID, ip, pn.RetryCount, now.Sub(pn.CreatedAt).Round(time.Second), result.err)
+			WriteDeadLetter(pn, now)
+			MetricNotificationsDropped.Inc(pn.Notif.ID, pn.Notif.Service, DropReasonRetriesExhausted)
+			NotificationQueueStore.Done(pn)
+			continue
+		}
+
+		delay := policy.NextDelay(pn.RetryCount)
+		pn.NextAttempt = now.Add(delay)
+		logrus.Warnf("Failed to send notification [%s] for IP %s (attempt %d/%d), retrying in %s: %v",
+			pn.Notif.ID, ip, pn.RetryCount, policy.Count, delay.Round(time.Millisecond), result.err)
+		NotificationQueueStore.Retry(pn)
+		failedNotifications = append(failedNotifications, pn)
+	}
+
+	switch {
+	case successCount == len(results):
+		logrus.Infof("All %d notifications for IP %s sent successfully", successCount, ip)
+	case successCount > 0:
+		logrus.Infof("Notification for IP %s completed: %d success, %d failed (%d not retried due to success, %d re-queued, %d gave up)",
+			ip, successCount, len(results)-successCount, skipped, len(failedNotifications), exhausted)
+	case len(failedNotifications) > 0:
 		logrus.Warnf("All notifications failed for IP %s, re-queuing %d notifications for retry",
 			ip, len(failedNotifications))
-		AddPendingNotificationsToEnd(failedNotifications)
-	} else {
-		logrus.Errorf("All %d notifications for IP %s failed after max retries", len(notifications), ip)
+	default:
+		logrus.Errorf("All %d notifications for IP %s failed after max retries", len(results), ip)
+	}
+	AddPendingNotificationsToEnd(failedNotifications)
+}
+
+// deliveredOther 判断 delivery: any 的通知所在的告警分组是否已有通知发送成功
+func deliveredOther(pn PendingNotification) bool {
+	return pn.Notif.Delivery != DeliveryIndependent && NotificationQueueStore.Delivered(pn)
+}
+
+// NextDelay 返回第 attempt 次发送失败后的等待时间
+// backoff * 2^(attempt-1)，不超过 max_delay，并加入 ±jitter 比例的随机抖动
+func (p RetryPolicy) NextDelay(attempt int) time.Duration {
+	delay := p.BackoffParsed
+	for i := 1; i < attempt && delay < p.MaxDelayParsed; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelayParsed {
+		delay = p.MaxDelayParsed
+	}
+	if p.JitterParsed > 0 {
+		delay += time.Duration(float64(delay) * p.JitterParsed * (rand.Float64()*2 - 1))
+	}
+	if delay < 0 {
+		delay = 0
 	}
+	return delay
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookStub 记录请求次数的 webhook 服务，ok 为 false 时返回 500
+type webhookStub struct {
+	srv  *httptest.Server
+	ok   atomic.Bool
+	hits atomic.Int32
+}
+
+func newWebhookStub(t *testing.T) *webhookStub {
+	t.Helper()
+	s := &webhookStub{}
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.hits.Add(1)
+		if !s.ok.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+// setupDeliveryTest 使用 path 处的通知队列日志，配置指向 stubs 的 webhook 通知项，返回各通知项配置
+func setupDeliveryTest(t *testing.T, path string, delivery []string, stubs ...*webhookStub) []Notification {
+	t.Helper()
+	q, _, err := OpenNotificationQueue(path)
+	if err != nil {
+		t.Fatalf("open queue: %v", err)
+	}
+	NotificationQueueStore = q
+	PendingNotifications = nil
+	t.Cleanup(func() {
+		q.Close()
+		NotificationQueueStore = nil
+		PendingNotifications = nil
+	})
+
+	services := make([]Notification, len(stubs))
+	for i, stub := range stubs {
+		services[i] = Notification{
+			Name:     string(rune('a' + i)),
+			Service:  "webhook",
+			Delivery: delivery[i],
+			Config:   map[string]any{"url": stub.srv.URL},
+		}
+		if err := initNotificationConfig(&services[i], &Notifications{}); err != nil {
+			t.Fatalf("init notification: %v", err)
+		}
+	}
+	BuildNotifierRegistry(services, 5*time.Second)
+	return services
+}
+
+// takePendingByID 取出队列中的所有通知，按通知项 ID 索引
+func takePendingByID(t *testing.T, want int) map[string]PendingNotification {
+	t.Helper()
+	pending := TakeDuePendingNotifications(time.Now().Add(24 * time.Hour))
+	if len(pending) != want {
+		t.Fatalf("expected %d pending notifications, got %d", want, len(pending))
+	}
+	byID := make(map[string]PendingNotification, len(pending))
+	for _, pn := range pending {
+		byID[pn.Notif.ID] = pn
+	}
+	return byID
+}
+
+// TestDeliveryAnySplitRetries 同一告警的两个通知项首次都发送失败，重试被安排在不同时间：
+// a 的重试先成功后，b 的重试单独到期时不再发送 (送达标记在重启后仍然有效)
+func TestDeliveryAnySplitRetries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notification_queue.jsonl")
+	stubA, stubB := newWebhookStub(t), newWebhookStub(t)
+	services := setupDeliveryTest(t, path, []string{DeliveryAny, DeliveryAny}, stubA, stubB)
+
+	data := TemplateData{IP: "192.0.2.1", Count: 5}
+	for _, notif := range services {
+		QueueNotification(notif, "alert", "Risk IP Alert", data, "192.0.2.1@1")
+	}
+
+	// 第一次发送：两个通知项都失败，各自按退避时间重新入队
+	processIPNotificationGroup(data.IP, TakeDuePendingNotifications(time.Now()))
+	if stubA.hits.Load() != 1 || stubB.hits.Load() != 1 {
+		t.Fatalf("first attempt: hits a=%d b=%d, want 1/1", stubA.hits.Load(), stubB.hits.Load())
+	}
+	pending := takePendingByID(t, 2)
+
+	// a 的重试先到期并发送成功
+	stubA.ok.Store(true)
+	processIPNotificationGroup(data.IP, []PendingNotification{pending["a"]})
+	if stubA.hits.Load() != 2 {
+		t.Fatalf("retry of a: hits = %d, want 2", stubA.hits.Load())
+	}
+
+	// 重启后重放日志：b 仍在队列中，其分组的送达标记也被恢复
+	NotificationQueueStore.Close()
+	q, restored, err := OpenNotificationQueue(path)
+	if err != nil {
+		t.Fatalf("reopen queue: %v", err)
+	}
+	NotificationQueueStore = q
+	if len(restored) != 1 || restored[0].NotifID != "b" {
+		t.Fatalf("expected only b to be restored, got %+v", restored)
+	}
+	if !q.Delivered(pending["b"]) {
+		t.Fatal("delivered marker not restored from journal")
+	}
+
+	// b 的重试单独到期：同组已送达，不再发送并移出队列
+	processIPNotificationGroup(data.IP, []PendingNotification{pending["b"]})
+	if stubB.hits.Load() != 1 {
+		t.Fatalf("b was retried after a succeeded: hits = %d", stubB.hits.Load())
+	}
+	if n := len(TakeDuePendingNotifications(time.Now().Add(24 * time.Hour))); n != 0 {
+		t.Fatalf("expected empty queue, got %d", n)
+	}
+	q.mu.Lock()
+	live, delivered := len(q.live), len(q.delivered)
+	q.mu.Unlock()
+	if live != 0 || delivered != 0 {
+		t.Fatalf("queue not cleaned up: live=%d delivered=%d", live, delivered)
+	}
+}
+
+// TestDeliveryIndependentSplitRetries delivery: independent 的通知项不受同组其他通知项送达的影响
+func TestDeliveryIndependentSplitRetries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notification_queue.jsonl")
+	stubA, stubB := newWebhookStub(t), newWebhookStub(t)
+	services := setupDeliveryTest(t, path, []string{DeliveryAny, DeliveryIndependent}, stubA, stubB)
+
+	data := TemplateData{IP: "2001:db8::1", Count: 5}
+	for _, notif := range services {
+		QueueNotification(notif, "alert", "Risk IP Alert", data, "2001:db8::1@1")
+	}
+	processIPNotificationGroup(data.IP, TakeDuePendingNotifications(time.Now()))
+	pending := takePendingByID(t, 2)
+
+	stubA.ok.Store(true)
+	processIPNotificationGroup(data.IP, []PendingNotification{pending["a"]})
+
+	stubB.ok.Store(true)
+	processIPNotificationGroup(data.IP, []PendingNotification{pending["b"]})
+	if stubB.hits.Load() != 2 {
+		t.Fatalf("independent notification not retried: hits = %d, want 2", stubB.hits.Load())
+	}
+}
+
+// TestCheckAndNotifyGroupsPerPass 同一次检查中同一 IP 触发的通知共用一个分组；
+// 未达到阈值的 IP 不生成分组 ID
+func TestCheckAndNotifyGroupsPerPass(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notification_queue.jsonl")
+	services := setupDeliveryTest(t, path, []string{DeliveryAny, DeliveryAny}, newWebhookStub(t), newWebhookStub(t))
+	for i := range services {
+		services[i].Threshold = 2
+	}
+	setTestConfig(t, Config{Notifications: Notifications{Services: services}})
+	NotificationMapMutex.Lock()
+	prev := NotificationMap
+	NotificationMap = make(map[netip.Addr]*IPHitRecord)
+	NotificationMapMutex.Unlock()
+	t.Cleanup(func() {
+		NotificationMapMutex.Lock()
+		NotificationMap = prev
+		NotificationMapMutex.Unlock()
+	})
+
+	logInfo := NewNetListInfo("app", 1)
+	matches := []ListInfo{NewNetListInfo("risk", 1)}
+	quiet, noisy := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")
+
+	AddNotificationItem(quiet, logInfo, matches, "", nil)
+	seq := queueIDSeq.Load()
+	CheckAndNotify(logInfo, false)
+	if got := queueIDSeq.Load(); got != seq {
+		t.Fatalf("queue IDs allocated for an IP below threshold: %d -> %d", seq, got)
+	}
+
+	AddNotificationItem(noisy, logInfo, matches, "", nil)
+	AddNotificationItem(noisy, logInfo, matches, "", nil)
+	CheckAndNotify(logInfo, false)
+	pending := takePendingByID(t, 2)
+	group := pending["a"].Group
+	if !strings.HasPrefix(group, noisy.String()+"@") || pending["b"].Group != group {
+		t.Fatalf("groups in one pass: a=%q b=%q", group, pending["b"].Group)
+	}
+
+	// 下一次检查生成新的分组
+	AddNotificationItem(noisy, logInfo, matches, "", nil)
+	AddNotificationItem(noisy, logInfo, matches, "", nil)
+	CheckAndNotify(logInfo, false)
+	if next := takePendingByID(t, 2); next["a"].Group == group || next["a"].Group != next["b"].Group {
+		t.Fatalf("groups in second pass: a=%q b=%q, first pass %q", next["a"].Group, next["b"].Group, group)
+	}
+}
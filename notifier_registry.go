@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/nikoksr/notify"
+	"github.com/sirupsen/logrus"
+)
+
+// 通知服务健康状态
+const (
+	ServiceStatusUnknown = "unknown" // 尚未发送过
+	ServiceStatusOK      = "ok"      // 最近一次发送成功
+	ServiceStatusFailing = "failing" // 最近一次发送失败
+)
+
+// ServiceHealth 通知服务的健康状态 (通过 /status 接口返回)
+type ServiceHealth struct {
+	ID                  string    `json:"id"`                    // 通知项标识
+	Service             string    `json:"service"`               // 通知服务类型
+	Status              string    `json:"status"`                // unknown / ok / failing
+	Sent                uint64    `json:"sent"`                  // 发送成功次数
+	Failed              uint64    `json:"failed"`                // 发送失败次数
+	ConsecutiveFailures int       `json:"consecutive_failures"`  // 连续失败次数
+	LastSuccess         time.Time `json:"last_success,omitzero"` // 最近一次发送成功时间
+	LastFailure         time.Time `json:"last_failure,omitzero"` // 最近一次发送失败时间
+	LastError           string    `json:"last_error,omitempty"`  // 最近一次发送失败的错误信息
+}
+
+// NotifierClient 单个通知项的发送客户端
+// notify 库的服务与 curl 的 HTTP 客户端在首次发送时创建并复用 (复用连接, 避免如 Telegram 每次发送都调用 getMe)；
+// 创建失败时下次发送会重新创建
+type NotifierClient struct {
+	notif   Notification
+	timeout time.Duration // 发送超时 (notifications.timeout)
+
+	mu       sync.Mutex
+	notifier notify.Notifier // notify 库的服务 (curl/email 以外)
+	curl     *req.Client     // curl 服务的 HTTP 客户端
+	script   *scriptRunner   // script 服务的执行器 (并发数在该通知项的所有发送间共享)
+	limiter  *TokenBucket    // 速率限制 (未配置 rate_limit 时为 nil)
+
+	healthMu sync.Mutex
+	health   ServiceHealth
+}
+
+// 通知客户端注册表: 通知项 ID -> 客户端 (加载/重载配置时重建)
+var notifierRegistry = make(map[string]*NotifierClient)
+var notifierRegistryMutex sync.RWMutex
+
+// newNotifierClient 创建通知客户端 (不建立连接)
+func newNotifierClient(notif Notification, timeout time.Duration) *NotifierClient {
+	var limiter *TokenBucket
+	if notif.RateLimitCount > 0 {
+		limiter = NewTokenBucket(notif.RateLimitCount, notif.RateLimitPer)
+	}
+	return &NotifierClient{
+		limiter: limiter,
+		notif:   notif,
+		timeout: timeout,
+		health:  ServiceHealth{ID: notif.ID, Service: notif.Service, Status: ServiceStatusUnknown},
+	}
+}
+
+// BuildNotifierRegistry 根据通知配置重建客户端注册表
+// 配置未变化的通知项保留原客户端 (及其连接)，服务类型未变化的保留健康统计
+func BuildNotifierRegistry(services []Notification, timeout time.Duration) {
+	notifierRegistryMutex.Lock()
+	defer notifierRegistryMutex.Unlock()
+
+	registry := make(map[string]*NotifierClient, len(services))
+	for _, notif := range services {
+		old := notifierRegistry[notif.ID]
+		if old != nil && old.timeout == timeout && sameNotifierConfig(old.notif, notif) {
+			registry[notif.ID] = old
+			continue
+		}
+		client := newNotifierClient(notif, timeout)
+		if old != nil && old.notif.Service == notif.Service {
+			client.health = old.Health()
+		}
+		registry[notif.ID] = client
+	}
+	notifierRegistry = registry
+	logrus.Debugf("Notifier registry built with %d services", len(registry))
+}
+
+// sameNotifierConfig 判断两个通知项的客户端配置是否相同 (只有服务类型、config 与 rate_limit 影响客户端)
+func sameNotifierConfig(a, b Notification) bool {
+	return a.Service == b.Service && fmt.Sprint(a.Config) == fmt.Sprint(b.Config) &&
+		a.RateLimitCount == b.RateLimitCount && a.RateLimitPer == b.RateLimitPer
+}
+
+// errNotifierNotConfigured 通知项不在客户端注册表中 (已在重载时删除或改名)
+var errNotifierNotConfigured = errors.New("notification no longer configured")
+
+// GetNotifierClient 返回通知项对应的客户端
+// 注册表中不存在时返回错误：新建的客户端没有该通知项的速率限制状态，不能用于发送
+func GetNotifierClient(notif Notification) (*NotifierClient, error) {
+	notifierRegistryMutex.RLock()
+	client := notifierRegistry[notif.ID]
+	notifierRegistryMutex.RUnlock()
+	if client == nil {
+		return nil, fmt.Errorf("[%s]: %w", notif.ID, errNotifierNotConfigured)
+	}
+	return client, nil
+}
+
+// NotifierHealth 返回所有通知项的健康状态 (按配置顺序)
+func NotifierHealth() []ServiceHealth {
+	configMutex.RLock()
+	ids := make([]string, 0, len(config.Notifications.Services))
+	for _, notif := range config.Notifications.Services {
+		ids = append(ids, notif.ID)
+	}
+	configMutex.RUnlock()
+
+	notifierRegistryMutex.RLock()
+	defer notifierRegistryMutex.RUnlock()
+	health := make([]ServiceHealth, 0, len(ids))
+	for _, id := range ids {
+		if client := notifierRegistry[id]; client != nil {
+			health = append(health, client.Health())
+		}
+	}
+	return health
+}
+
+// Health 返回客户端的健康状态 (线程安全)
+func (c *NotifierClient) Health() ServiceHealth {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	return c.health
+}
+
+// record 记录一次发送结果
+func (c *NotifierClient) record(err error) {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	now := time.Now()
+	if err == nil {
+		MetricNotificationsSent.Inc(c.notif.ID, c.notif.Service)
+		c.health.Status = ServiceStatusOK
+		c.health.Sent++
+		c.health.ConsecutiveFailures = 0
+		c.health.LastSuccess = now
+		return
+	}
+	MetricNotificationsFailed.Inc(c.notif.ID, c.notif.Service)
+	c.health.Status = ServiceStatusFailing
+	c.health.Failed++
+	c.health.ConsecutiveFailures++
+	c.health.LastFailure = now
+	c.health.LastError = err.Error()
+}
+
+// curlClient 返回 curl 服务复用的 HTTP 客户端
+func (c *NotifierClient) curlClient() *req.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.curl == nil {
+		c.curl = req.C()
+		if c.timeout > 0 {
+			c.curl.SetTimeout(c.timeout)
+		}
+		if logrus.IsLevelEnabled(logrus.DebugLevel) {
+			c.curl.EnableDebugLog()
+		}
+	}
+	return c.curl
+}
+
+// notifyService 返回复用的 notify 服务，尚未创建或上次创建失败时重新创建
+func (c *NotifierClient) notifyService() (notify.Notifier, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.notifier == nil {
+		service, err := setupNotificationService(c.notif)
+		if err != nil {
+			return nil, err
+		}
+		c.notifier = service
+	}
+	return c.notifier, nil
+}
+
+// scriptService 返回复用的 script 执行器，尚未创建或上次创建失败时重新创建
+func (c *NotifierClient) scriptService() (*scriptRunner, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.script == nil {
+		runner, err := newScriptRunner(c.notif, c.timeout)
+		if err != nil {
+			return nil, err
+		}
+		c.script = runner
+	}
+	return c.script, nil
+}
+
+// Send 发送通知并记录健康状态 (单次尝试)
+// 使用注册表中的服务配置 (重载后为最新配置)，消息内容由调用方提供，data 为消息的模板数据 (script 服务使用)
+func (c *NotifierClient) Send(message, title, html string, data TemplateData) error {
+	err := c.send(message, title, html, data)
+	c.record(err)
+	return err
+}
+
+func (c *NotifierClient) send(message, title, html string, data TemplateData) error {
+	timeout := c.timeout
+	switch strings.ToLower(c.notif.Service) {
+	case "curl":
+		// 特殊处理 curl 服务
+		return sendCurlNotification(c.notif, message, title, c.curlClient())
+	case "email":
+		// 特殊处理 email 服务 (SMTP 会话在空闲时会被服务器断开，每次发送单独建立连接)
+		return sendEmailNotification(c.notif, message, title, html, timeout)
+	case "script":
+		// 特殊处理 script 服务 (执行本地程序，参数与环境变量由模板数据渲染)
+		runner, err := c.scriptService()
+		if err != nil {
+			return fmt.Errorf("failed to setup script service: %v", err)
+		}
+		return runner.Run(c.notif.ID, ScriptData{TemplateData: data, Title: title, Message: message})
+	}
+
+	// 其他服务使用 notify 库
+	service, err := c.notifyService()
+	if err != nil {
+		return fmt.Errorf("failed to setup notification service: %v", err)
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err = service.Send(ctx, title, message)
+	logrus.Debugf("Use service: %s, send Title: %s, message: %s", c.notif.Service, title, message)
+	return err
+}
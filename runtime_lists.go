@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 运行时列表类型
+const (
+	RuntimeListSafe = "safe" // 加入 SafeListData
+	RuntimeListRisk = "risk" // 加入 RiskListData
+)
+
+// RuntimeEntry 运行时列表中的一个条目
+type RuntimeEntry struct {
+	Entry     string    `json:"entry"`               // IP 或 CIDR (规范化后)
+	Comment   string    `json:"comment,omitempty"`   // 备注
+	AddedAt   time.Time `json:"added_at"`            // 添加时间
+	ExpiresAt time.Time `json:"expires_at,omitzero"` // 过期时间 (零值表示永不过期)
+}
+
+// RuntimeList 通过 API 管理的列表 (持久化到 data_dir/runtime_lists.json)
+type RuntimeList struct {
+	Type    string          `json:"type"`    // safe / risk
+	Name    string          `json:"name"`    // 列表名称
+	Level   int             `json:"level"`   // 风险等级 (safe 列表始终为 0)
+	Entries []*RuntimeEntry `json:"entries"` // 条目 (按添加顺序)
+}
+
+// runtimeListKey 运行时列表的键：类型 + 名称
+type runtimeListKey struct {
+	Type string
+	Name string
+}
+
+// 运行时列表
+var RuntimeLists = make(map[runtimeListKey]*RuntimeList)
+var RuntimeListsMutex sync.Mutex
+
+// runtimeListsLoaded 是否已从磁盘加载运行时列表 (只在首次初始化时加载)
+var runtimeListsLoaded bool
+
+// runtimeListsPath 返回运行时列表文件的路径
+func runtimeListsPath() string {
+	configMutex.RLock()
+	dataDir := config.DataDir
+	configMutex.RUnlock()
+	return filepath.Join(dataDir, "runtime_lists.json")
+}
+
+// LoadRuntimeLists 从磁盘加载运行时列表 (仅首次调用时加载)
+func LoadRuntimeLists() {
+	RuntimeListsMutex.Lock()
+	defer RuntimeListsMutex.Unlock()
+	if runtimeListsLoaded {
+		return
+	}
+	runtimeListsLoaded = true
+
+	path := runtimeListsPath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Errorf("Failed to read runtime lists %s: %v", path, err)
+		}
+		return
+	}
+	var lists []*RuntimeList
+	if err := json.Unmarshal(data, &lists); err != nil {
+		logrus.Errorf("Failed to parse runtime lists %s: %v", path, err)
+		return
+	}
+	for _, l := range lists {
+		RuntimeLists[runtimeListKey{Type: l.Type, Name: l.Name}] = l
+	}
+	logrus.Infof("Loaded %d runtime lists from %s", len(lists), path)
+}
+
+// saveRuntimeListsLocked 将运行时列表写入磁盘 (调用方需持有 RuntimeListsMutex)
+func saveRuntimeListsLocked() error {
+	data, err := json.MarshalIndent(sortedRuntimeListsLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(runtimeListsPath(), data)
+}
+
+// sortedRuntimeListsLocked 按类型、名称排序返回所有运行时列表 (调用方需持有 RuntimeListsMutex)
+func sortedRuntimeListsLocked() []*RuntimeList {
+	lists := make([]*RuntimeList, 0, len(RuntimeLists))
+	for _, l := range RuntimeLists {
+		lists = append(lists, l)
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		if lists[i].Type != lists[j].Type {
+			return lists[i].Type < lists[j].Type
+		}
+		return lists[i].Name < lists[j].Name
+	})
+	return lists
+}
+
+// runtimeListGroup 返回运行时列表类型对应的 ListGroup
+func runtimeListGroup(typ string) *ListGroup {
+	if typ == RuntimeListSafe {
+		return SafeListData
+	}
+	return RiskListData
+}
+
+// applyRuntimeListLocked 将运行时列表的未过期条目写入对应的 ListGroup (调用方需持有 RuntimeListsMutex)
+// 列表为空时从 ListGroup 中删除；与配置文件中的列表同名时不做任何修改 (配置文件优先)，返回 false
+func applyRuntimeListLocked(l *RuntimeList, now time.Time) bool {
+	group := runtimeListGroup(l.Type)
+	if group == nil {
+		return true
+	}
+	if configuredListNames(l.Type)[l.Name] {
+		return false
+	}
+	var ips []netip.Addr
+	var cidrs []netip.Prefix
+	for _, e := range l.Entries {
+		if !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt) {
+			continue
+		}
+		if p, err := parseRuntimeEntry(e.Entry); err == nil {
+			if p.IsSingleIP() {
+				ips = append(ips, p.Addr())
+			} else {
+				cidrs = append(cidrs, p)
+			}
+		}
+	}
+	if len(ips)+len(cidrs) > 0 {
+		group.AddList(NewNetListInfo(l.Name, l.Level), ips, cidrs)
+	} else {
+		group.DelList(l.Name)
+	}
+	return true
+}
+
+// ApplyRuntimeLists 将所有运行时列表写入 SafeListData / RiskListData (重建 ListGroup 后调用)
+// 与配置文件中的列表同名的运行时列表会被跳过 (配置文件优先)
+func ApplyRuntimeLists() {
+	RuntimeListsMutex.Lock()
+	defer RuntimeListsMutex.Unlock()
+	now := time.Now()
+	for _, l := range RuntimeLists {
+		if !applyRuntimeListLocked(l, now) {
+			logrus.Warnf("Runtime %s list %s is shadowed by a list of the same name in the config file, skipping", l.Type, l.Name)
+		}
+	}
+}
+
+// parseRuntimeEntry 解析 IP 或 CIDR 条目，单个 IP 返回 /32 或 /128 前缀
+func parseRuntimeEntry(s string) (netip.Prefix, error) {
+	if ip, err := ParseIP(s); err == nil {
+		return netip.PrefixFrom(ip, ip.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP or CIDR: %s", s)
+	}
+	return normalizePrefix(p), nil
+}
+
+// configuredListNames 返回配置文件中该类型的列表名称
+func configuredListNames(typ string) map[string]bool {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	lists := config.RiskList
+	if typ == RuntimeListSafe {
+		lists = config.SafeList
+	}
+	names := make(map[string]bool, len(lists))
+	for _, l := range lists {
+		names[l.Name] = true
+	}
+	return names
+}
+
+// AddRuntimeEntries 向运行时列表添加条目 (列表不存在时创建)
+// 已存在的条目会更新备注与过期时间；level 仅对 risk 列表有效 (<= 0 时保持原值, 新列表默认 1)
+// 返回更新后的列表副本
+func AddRuntimeEntries(typ, name string, entries []string, comment string, ttl time.Duration, level int) (RuntimeList, error) {
+	if typ != RuntimeListSafe && typ != RuntimeListRisk {
+		return RuntimeList{}, fmt.Errorf("unknown list type: %s (safe, risk)", typ)
+	}
+	if configuredListNames(typ)[name] {
+		return RuntimeList{}, fmt.Errorf("list %s is defined in the config file and cannot be modified at runtime", name)
+	}
+	if len(entries) == 0 {
+		return RuntimeList{}, fmt.Errorf("no entries given")
+	}
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, s := range entries {
+		p, err := parseRuntimeEntry(s)
+		if err != nil {
+			return RuntimeList{}, err
+		}
+		prefixes = append(prefixes, p)
+	}
+
+	now := time.Now()
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = now.Add(ttl)
+	}
+
+	RuntimeListsMutex.Lock()
+	defer RuntimeListsMutex.Unlock()
+	key := runtimeListKey{Type: typ, Name: name}
+	l := RuntimeLists[key]
+	if l == nil {
+		l = &RuntimeList{Type: typ, Name: name, Level: 1}
+		RuntimeLists[key] = l
+	}
+	if typ == RuntimeListSafe {
+		l.Level = 0
+	} else if level > 0 {
+		l.Level = level
+	}
+
+	index := make(map[string]*RuntimeEntry, len(l.Entries))
+	for _, e := range l.Entries {
+		index[e.Entry] = e
+	}
+	for _, p := range prefixes {
+		entry := formatExportPrefix(p)
+		if e := index[entry]; e != nil {
+			e.Comment = comment
+			e.ExpiresAt = expiresAt
+			continue
+		}
+		e := &RuntimeEntry{Entry: entry, Comment: comment, AddedAt: now, ExpiresAt: expiresAt}
+		l.Entries = append(l.Entries, e)
+		index[entry] = e
+	}
+
+	applyRuntimeListLocked(l, now)
+	if err := saveRuntimeListsLocked(); err != nil {
+		logrus.Errorf("Failed to save runtime lists: %v", err)
+	}
+	logrus.Infof("Added %d entries to runtime %s list %s (%d entries)", len(prefixes), typ, name, len(l.Entries))
+	return copyRuntimeList(l), nil
+}
+
+// RemoveRuntimeEntries 从运行时列表删除条目，返回实际删除的条目数；entries 为空时删除整个列表
+func RemoveRuntimeEntries(typ, name string, entries []string) (int, error) {
+	wanted := make(map[string]bool, len(entries))
+	for _, s := range entries {
+		p, err := parseRuntimeEntry(s)
+		if err != nil {
+			return 0, err
+		}
+		wanted[formatExportPrefix(p)] = true
+	}
+
+	RuntimeListsMutex.Lock()
+	defer RuntimeListsMutex.Unlock()
+	key := runtimeListKey{Type: typ, Name: name}
+	l := RuntimeLists[key]
+	if l == nil {
+		return 0, fmt.Errorf("runtime list not found: %s/%s", typ, name)
+	}
+
+	removed := 0
+	kept := l.Entries[:0]
+	for _, e := range l.Entries {
+		if len(entries) == 0 || wanted[e.Entry] {
+			removed++
+			continue
+		}
+		kept = append(kept, e)
+	}
+	l.Entries = kept
+	if len(l.Entries) == 0 {
+		delete(RuntimeLists, key)
+	}
+
+	applyRuntimeListLocked(l, time.Now())
+	if err := saveRuntimeListsLocked(); err != nil {
+		logrus.Errorf("Failed to save runtime lists: %v", err)
+	}
+	logrus.Infof("Removed %d entries from runtime %s list %s (%d entries)", removed, typ, name, len(l.Entries))
+	return removed, nil
+}
+
+// RuntimeListsSnapshot 返回所有运行时列表的副本 (线程安全, 按类型、名称排序)
+func RuntimeListsSnapshot() []RuntimeList {
+	RuntimeListsMutex.Lock()
+	defer RuntimeListsMutex.Unlock()
+	lists := sortedRuntimeListsLocked()
+	result := make([]RuntimeList, 0, len(lists))
+	for _, l := range lists {
+		result = append(result, copyRuntimeList(l))
+	}
+	return result
+}
+
+// copyRuntimeList 深拷贝运行时列表 (调用方需持有 RuntimeListsMutex)
+func copyRuntimeList(l *RuntimeList) RuntimeList {
+	c := *l
+	c.Entries = make([]*RuntimeEntry, len(l.Entries))
+	for i, e := range l.Entries {
+		entry := *e
+		c.Entries[i] = &entry
+	}
+	return c
+}
+
+// StartRuntimeListScheduler 启动运行时列表的过期清理
+// 每 1 秒检查一次，删除已过期的条目并更新对应的 ListGroup
+func StartRuntimeListScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				expireRuntimeEntries(time.Now())
+			}
+		}
+	}()
+}
+
+// expireRuntimeEntries 删除已过期的运行时列表条目
+func expireRuntimeEntries(now time.Time) {
+	RuntimeListsMutex.Lock()
+	defer RuntimeListsMutex.Unlock()
+	changed := false
+	for key, l := range RuntimeLists {
+		kept := l.Entries[:0]
+		for _, e := range l.Entries {
+			if !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt) {
+				logrus.Infof("Runtime %s list %s entry %s expired", l.Type, l.Name, e.Entry)
+				continue
+			}
+			kept = append(kept, e)
+		}
+		if len(kept) == len(l.Entries) {
+			continue
+		}
+		changed = true
+		l.Entries = kept
+		if len(l.Entries) == 0 {
+			delete(RuntimeLists, key)
+		}
+		applyRuntimeListLocked(l, now)
+	}
+	if changed {
+		if err := saveRuntimeListsLocked(); err != nil {
+			logrus.Errorf("Failed to save runtime lists: %v", err)
+		}
+	}
+}
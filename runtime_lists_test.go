@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// TestRuntimeListShadowedByConfig 与配置文件中的列表同名的运行时列表删除或过期条目时不影响配置文件中的列表
+func TestRuntimeListShadowedByConfig(t *testing.T) {
+	configMutex.Lock()
+	prev := config
+	config = Config{DataDir: t.TempDir(), SafeList: []IPList{{Name: "office"}}}
+	configMutex.Unlock()
+	prevSafe := SafeListData
+	t.Cleanup(func() {
+		configMutex.Lock()
+		config = prev
+		configMutex.Unlock()
+		SafeListData = prevSafe
+		RuntimeLists = make(map[runtimeListKey]*RuntimeList)
+	})
+
+	configured := netip.MustParseAddr("10.0.0.1")
+	now := time.Now()
+	for name, change := range map[string]func(){
+		"remove": func() {
+			if _, err := RemoveRuntimeEntries(RuntimeListSafe, "office", []string{"192.0.2.1"}); err != nil {
+				t.Fatalf("remove: %v", err)
+			}
+		},
+		"expire": func() { expireRuntimeEntries(now.Add(time.Minute)) },
+	} {
+		SafeListData = NewListGroup()
+		SafeListData.AddList(NewNetListInfo("office", 0), []netip.Addr{configured}, nil)
+		RuntimeLists = map[runtimeListKey]*RuntimeList{
+			{Type: RuntimeListSafe, Name: "office"}: {
+				Type: RuntimeListSafe,
+				Name: "office",
+				Entries: []*RuntimeEntry{
+					{Entry: "192.0.2.1", AddedAt: now, ExpiresAt: now.Add(time.Second)},
+				},
+			},
+		}
+
+		ApplyRuntimeLists()
+		change()
+		if ok, info := SafeListData.Contains(configured); !ok || info.Name != "office" {
+			t.Fatalf("%s: configured safe list office was modified by the shadowed runtime list", name)
+		}
+		if ok, _ := SafeListData.Contains(netip.MustParseAddr("192.0.2.1")); ok {
+			t.Fatalf("%s: shadowed runtime entry applied", name)
+		}
+		if len(RuntimeLists) != 0 {
+			t.Fatalf("%s: runtime entry not removed: %+v", name, RuntimeLists)
+		}
+	}
+}
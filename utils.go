@@ -38,26 +38,16 @@ func ParseDuration(s string) (time.Duration, error) {
 	return time.Duration(d) * multiplier, nil
 }
 
-// IPv4ToUint32 将IPv4地址字符串转换为uint32
-func IPv4ToUint32(ip string) (uint32, error) {
-	parts := strings.Split(ip, ".")
-	if len(parts) != 4 {
-		return 0, fmt.Errorf("invalid IPv4 address: %s", ip)
-	}
-	var result uint32
-	for i, part := range parts {
-		num, err := strconv.Atoi(part)
-		if err != nil || num < 0 || num > 255 {
-			return 0, fmt.Errorf("invalid IPv4 address: %s", ip)
-		}
-		result |= uint32(num) << ((3 - i) * 8)
+// ParseIP 解析 IPv4/IPv6 地址字符串
+// 会去除首尾空白、IPv6 方括号与 zone (如 %eth0)，并将 IPv4-mapped IPv6 地址统一为 IPv4
+func ParseIP(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid IP address: %s", s)
 	}
-	return result, nil
-}
-
-// Uint32ToIPv4 将uint32转换为net.IP
-func Uint32ToIPv4(ip uint32) netip.Addr {
-	return netip.AddrFrom4([4]byte{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)})
+	return addr.WithZone("").Unmap(), nil
 }
 
 // BoolToInt 将布尔值转换为整数（true -> 1, false -> 0）
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestParseIP(t *testing.T) {
+	cases := []struct {
+		in, want string // want 为空表示解析失败
+	}{
+		{"192.0.2.1", "192.0.2.1"},
+		{" 192.0.2.1\t", "192.0.2.1"},
+		{"2001:DB8::1", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"fe80::1%eth0", "fe80::1"},
+		{"::ffff:192.0.2.1", "192.0.2.1"},
+		{"::ffff:c000:201", "192.0.2.1"},
+		{"::1", "::1"},
+		{"256.0.0.1", ""},
+		{"192.0.2", ""},
+		{"12:34:56", ""},
+		{"example.com", ""},
+		{"", ""},
+	}
+	for _, c := range cases {
+		ip, err := ParseIP(c.in)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("ParseIP(%q) = %s, want error", c.in, ip)
+			}
+			continue
+		}
+		if err != nil || ip.String() != c.want {
+			t.Errorf("ParseIP(%q) = %s, %v; want %s", c.in, ip, err, c.want)
+		}
+	}
+}